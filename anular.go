@@ -0,0 +1,104 @@
+package main
+
+import (
+	"app/api"
+	"app/db"
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runAnular annuls the invoices of the given abonados after asking for
+// confirmation:
+//
+//	facturacion.exe anular -motivo 1 [-emision 2024-07-01] [-si] 1001 1002
+func runAnular(ctx context.Context, fe api.InvoiceClient, args []string) error {
+	fs := flag.NewFlagSet("anular", flag.ExitOnError)
+	motivo := fs.Int("motivo", int(api.MotivoFacturaMalEmitida), "Código de motivo de anulación (catálogo SIN)")
+	emision := fs.String("emision", "", "Fecha de emisión (por defecto la emisión actual de Factores)")
+	yes := fs.Bool("si", false, "No pedir confirmación")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Uso: anular [opciones] abonado...")
+		fs.PrintDefaults()
+		fmt.Fprintln(fs.Output(), "Motivos:")
+		for codigo := api.MotivoFacturaMalEmitida; codigo <= api.MotivoFacturaDevuelta; codigo++ {
+			fmt.Fprintf(fs.Output(), "  %d  %s\n", codigo, codigo)
+		}
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("indique al menos un abonado")
+	}
+	motivoAnulacion := api.MotivoAnulacion(*motivo)
+	if _, ok := api.MotivosAnulacion[motivoAnulacion]; !ok {
+		return fmt.Errorf("motivo de anulación inválido: %d", *motivo)
+	}
+
+	if *emision == "" {
+		var err error
+		*emision, err = db.GetEmisionActual()
+		if err != nil {
+			return err
+		}
+	}
+
+	var facturas []*db.FacturaEmitida
+	for _, abonado := range fs.Args() {
+		factura, err := db.GetFacturaEmitida(*emision, abonado)
+		if err != nil {
+			return err
+		}
+		facturas = append(facturas, factura)
+	}
+
+	fmt.Printf("Emisión %s, motivo %d (%s)\n", *emision, motivoAnulacion, motivoAnulacion)
+	for _, f := range facturas {
+		fmt.Printf("  abonado %s  factura %d  cuf %s\n", f.Abonado, f.NumFactura, f.CodigoControl)
+	}
+	if !*yes && !confirmar(os.Stdin, fmt.Sprintf("¿Anular %d factura(s)?", len(facturas))) {
+		fmt.Println("Cancelado")
+		return nil
+	}
+
+	var fallos int
+	for _, f := range facturas {
+		if _, err := fe.AnularFactura(api.ConAbonado(ctx, f.Abonado), f.CodigoControl, motivoAnulacion); err != nil && !anulada(ctx, fe, f.CodigoControl, err) {
+			fmt.Printf("Error al anular abonado %s: %v\n", f.Abonado, err)
+			fallos++
+			continue
+		}
+		if err := db.AnularFacturaCodigoControl(f.FacturaID, f.CodigoControl); err != nil {
+			fmt.Printf("Factura del abonado %s anulada, pero no se actualizó la base de datos: %v\n", f.Abonado, err)
+			fallos++
+			continue
+		}
+		fmt.Printf("Abonado %s anulado\n", f.Abonado)
+	}
+	if fallos > 0 {
+		return fmt.Errorf("%d de %d anulaciones fallaron", fallos, len(facturas))
+	}
+	return nil
+}
+
+// anulada reports whether an annulment that failed without a response went
+// through anyway; third-party-cancel is not resent blindly.
+func anulada(ctx context.Context, fe api.InvoiceClient, cuf string, err error) bool {
+	if !api.IsIncierto(err) {
+		return false
+	}
+	estado, consultaErr := fe.ConsultarEstado(ctx, cuf)
+	return consultaErr == nil && estado.Estado == api.EstadoAnulada
+}
+
+func confirmar(r io.Reader, pregunta string) bool {
+	fmt.Printf("%s (s/N): ", pregunta)
+	respuesta, _ := bufio.NewReader(r).ReadString('\n')
+	respuesta = strings.ToLower(strings.TrimSpace(respuesta))
+	return respuesta == "s" || respuesta == "si" || respuesta == "sí"
+}
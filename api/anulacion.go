@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"fmt"
+)
+
+// MotivoAnulacion corresponde al catálogo paramétrico "MOTIVO ANULACION" de SIN.
+type MotivoAnulacion int
+
+const (
+	MotivoFacturaMalEmitida    MotivoAnulacion = 1
+	MotivoNotaMalEmitida       MotivoAnulacion = 2
+	MotivoDatosEmisionErroneos MotivoAnulacion = 3
+	MotivoFacturaDevuelta      MotivoAnulacion = 4
+)
+
+// MotivosAnulacion lista los motivos válidos con su descripción oficial.
+var MotivosAnulacion = map[MotivoAnulacion]string{
+	MotivoFacturaMalEmitida:    "FACTURA MAL EMITIDA",
+	MotivoNotaMalEmitida:       "NOTA DE CREDITO-DEBITO MAL EMITIDA",
+	MotivoDatosEmisionErroneos: "DATOS DE EMISION INCORRECTOS",
+	MotivoFacturaDevuelta:      "FACTURA O NOTA DE CREDITO-DEBITO DEVUELTA",
+}
+
+func (m MotivoAnulacion) String() string {
+	if descripcion, ok := MotivosAnulacion[m]; ok {
+		return descripcion
+	}
+	return fmt.Sprintf("MOTIVO %d", int(m))
+}
+
+// AnulacionResponse es la respuesta de third-party-cancel.
+type AnulacionResponse struct {
+	Cuf     string `json:"cuf"`
+	Estado  string `json:"estado"`
+	Fecha   string `json:"fecha"`
+	Mensaje string `json:"mensaje"`
+}
+
+// AnularFactura solicita la anulación de una factura ya emitida.
+func (fe *FacturacionElectronica) AnularFactura(ctx context.Context, cuf string, motivo MotivoAnulacion) (*AnulacionResponse, error) {
+	if _, ok := MotivosAnulacion[motivo]; !ok {
+		return nil, fmt.Errorf("motivo de anulación inválido: %d", int(motivo))
+	}
+
+	payload := map[string]interface{}{
+		"cuf":          cuf,
+		"codigoMotivo": int(motivo),
+	}
+
+	var result AnulacionResponse
+	if err := fe.postJSON(ctx, "/api/v1/invoice-utils/third-party-cancel", payload, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
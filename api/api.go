@@ -1,11 +1,15 @@
 package api
 
 import (
+	"app/money"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
-	"math"
+	"log"
+	"net"
 	"net/http"
 	"os"
 	"time"
@@ -16,130 +20,288 @@ import (
 type ApiConfig struct {
 	Url    string
 	ApiKey string
+	// Retry es la política de reintentos; si MaxAttempts es 0 se usa
+	// DefaultRetryPolicy.
+	Retry RetryPolicy
+	// ConnectTimeout limita el establecimiento de la conexión TCP.
+	ConnectTimeout time.Duration
+	// ResponseTimeout limita la espera de las cabeceras de la respuesta.
+	ResponseTimeout time.Duration
+	// RequestTimeout limita cada intento completo, incluida la lectura del
+	// cuerpo. Cero significa sin límite.
+	RequestTimeout time.Duration
 }
 
 type FacturacionElectronica struct {
 	client  *http.Client
 	baseUrl string
 	apiKey  string
+	emisor  EmisorProfile
+	retry   RetryPolicy
+
+	requestTimeout time.Duration
+	leyendas       *CatalogoLeyendas
+	catalogos      *Catalogos
+	auditoria      *Auditoria
+	validarXML     bool
+	cufd           *Cufd
 }
 
-// func round(val float64, precision int) float64 {
-// 	ratio := math.Pow(10, float64(precision))
-// 	return math.Round(val*ratio) / ratio
-// }
+func NewFacturacionElectronica(apiConfig ApiConfig, emisor EmisorProfile) *FacturacionElectronica {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if apiConfig.ConnectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   apiConfig.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	transport.ResponseHeaderTimeout = apiConfig.ResponseTimeout
 
-func NewFacturacionElectronica(apiConfig ApiConfig) *FacturacionElectronica {
 	return &FacturacionElectronica{
-		client:  &http.Client{},
+		client:  &http.Client{Transport: transport},
 		baseUrl: apiConfig.Url,
 		apiKey:  apiConfig.ApiKey,
+		emisor:  emisor,
+		retry:   apiConfig.Retry,
+
+		requestTimeout: apiConfig.RequestTimeout,
+		leyendas:       DefaultCatalogoLeyendas(),
+		catalogos:      DefaultCatalogos(),
+		validarXML:     true,
+	}
+}
+
+// Emisor devuelve el perfil con el que se arman las facturas.
+func (fe *FacturacionElectronica) Emisor() EmisorProfile {
+	return fe.emisor
+}
+
+// SetLeyendas reemplaza el catálogo incluido por uno sincronizado.
+func (fe *FacturacionElectronica) SetLeyendas(catalogo *CatalogoLeyendas) {
+	fe.leyendas = catalogo
+}
+
+// SetCatalogos reemplaza los catálogos incluidos por unos sincronizados.
+func (fe *FacturacionElectronica) SetCatalogos(catalogos *Catalogos) {
+	fe.catalogos = catalogos
+}
+
+// SetValidarXML activa o desactiva la validación del XML contra el XSD de
+// SIN al armar cada factura.
+func (fe *FacturacionElectronica) SetValidarXML(validar bool) {
+	fe.validarXML = validar
+}
+
+// Catalogos devuelve los catálogos contra los que se validan las facturas.
+func (fe *FacturacionElectronica) Catalogos() *Catalogos {
+	return fe.catalogos
+}
+
+// elegirLeyenda toma una leyenda al azar del catálogo para la actividad del
+// emisor; la leyenda fija del perfil solo se usa si el catálogo no tiene
+// esa actividad.
+func (fe *FacturacionElectronica) elegirLeyenda() (string, error) {
+	leyenda, err := fe.leyendas.Elegir(fe.emisor.CodigoActividad)
+	if err != nil && fe.emisor.Leyenda != "" {
+		return fe.emisor.Leyenda, nil
 	}
+	return leyenda, err
 }
 
 func (fe *FacturacionElectronica) FacturaServicios(
+	ctx context.Context,
 	periodo time.Time,
-	con_m3, impTotal, impAlcanta, impRep, impFactura, impRecargo, desc_ley1886 float64,
-	razon, abonado, nit, zona, calle string,
+	con_m3 float64,
+	impTotal, impAlcanta, impRep, impFactura, impRecargo, desc_ley1886 money.Money,
+	cargos CargosServicios,
+	razon, abonado, nit, zona, calle, correo string,
 	numero int,
-) (map[string]interface{}, error) {
+) (*FacturaResponse, error) {
+	facturaRequest, err := fe.BuildFacturaServicios(
+		periodo,
+		con_m3, impTotal, impAlcanta, impRep, impFactura, impRecargo, desc_ley1886,
+		cargos,
+		razon, abonado, nit, zona, calle, correo,
+		numero,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return fe.EnviarFactura(ctx, facturaRequest)
+}
+
+// BuildFacturaServicios arma la solicitud de sector 13 sin enviarla y la
+// valida. impTotal es el consumo ya descontados Ley 1886 y tarifa dignidad,
+// que van como descuentos de la cabecera; impFactura es el total a pagar.
+func (fe *FacturacionElectronica) BuildFacturaServicios(
+	periodo time.Time,
+	con_m3 float64,
+	impTotal, impAlcanta, impRep, impFactura, impRecargo, desc_ley1886 money.Money,
+	cargos CargosServicios,
+	razon, abonado, nit, zona, calle, correo string,
+	numero int,
+) (FacturaRequest, error) {
+	facturaRequest, err := fe.ArmarFacturaServicios(
+		periodo, con_m3,
+		impTotal, impAlcanta, impRep, impFactura, impRecargo, desc_ley1886,
+		cargos, razon, abonado, nit, zona, calle, correo, numero,
+	)
+	if err != nil {
+		return FacturaRequest{}, err
+	}
+	if err := fe.validar(facturaRequest); err != nil {
+		return FacturaRequest{}, err
+	}
+	return facturaRequest, nil
+}
+
+// ArmarFacturaServicios arma la solicitud como BuildFacturaServicios pero
+// sin validarla, para revisar las reglas por separado.
+func (fe *FacturacionElectronica) ArmarFacturaServicios(
+	periodo time.Time,
+	con_m3 float64,
+	impTotal, impAlcanta, impRep, impFactura, impRecargo, desc_ley1886 money.Money,
+	cargos CargosServicios,
+	razon, abonado, nit, zona, calle, correo string,
+	numero int,
+) (FacturaRequest, error) {
 	// mes := periodo.Format("January")
 	mes := monday.Format(periodo, "January", monday.LocaleEsES)
 	gestion := periodo.Format("2006")
 
-	nit = ifZero(nit, abonado)
-
-	descuento := 0.0
-	if desc_ley1886 > 0 {
-		descuento = desc_ley1886
-		impFactura = impFactura
-		impTotal = impTotal
+	documento := ClasificarDocumento(nit, abonado)
+	leyenda, err := fe.elegirLeyenda()
+	if err != nil {
+		return FacturaRequest{}, err
 	}
 
 	fechaHora := time.Now().Format("2006-01-02T15:04:05.000")
 
-	ajusteSejetoIvaTotal := round(impAlcanta + impRep + impRecargo)
+	ajusteSejetoIvaTotal := impAlcanta + impRep + impRecargo
 
 	ajusteSejetoIvaDetalleJson := map[string]interface{}{}
 	if impAlcanta > 0 {
-		ajusteSejetoIvaDetalleJson["Alcantarillado"] = fmt.Sprintf("%f", impAlcanta)
+		ajusteSejetoIvaDetalleJson["Alcantarillado"] = impAlcanta.String()
 	}
 	if impRep > 0 {
-		ajusteSejetoIvaDetalleJson["Rep. Formulario"] = fmt.Sprintf("%f", impRep)
+		ajusteSejetoIvaDetalleJson["Rep. Formulario"] = impRep.String()
 	}
 	if impRecargo > 0 {
-		ajusteSejetoIvaDetalleJson["Recargo"] = fmt.Sprintf("%f", impRecargo)
+		ajusteSejetoIvaDetalleJson["Recargo"] = impRecargo.String()
 	}
 
 	// Convertir el mapa a JSON
 	ajusteSejetoIvaDetalleJsonString, err := json.Marshal(ajusteSejetoIvaDetalleJson)
 	if err != nil {
-		return nil, fmt.Errorf("error al convertir ajusteSejetoIvaDetalleJson a JSON: %v", err)
+		return FacturaRequest{}, fmt.Errorf("error al convertir ajusteSejetoIvaDetalleJson a JSON: %v", err)
 	}
 
 	camposAdicionales := []CampoAdicionalModel{
-		{Clave: "numeroMedidor", Valor: "0"},
+		{Clave: "numeroMedidor", Valor: ifEmpty(cargos.NumeroMedidor, "0")},
 		{Clave: "mes", Valor: mes},
 		{Clave: "gestion", Valor: gestion},
-		{Clave: "ciudad", Valor: "Tupiza"},
+		{Clave: "ciudad", Valor: fe.emisor.Ciudad},
 		{Clave: "zona", Valor: zona},
 		{Clave: "domicilioCliente", Valor: ifEmpty(calle, "Sin dirección")},
 		{Clave: "consumoPeriodo", Valor: fmt.Sprintf("%.2f", con_m3)},
-		{Clave: "ajusteSujetoIva", Valor: fmt.Sprintf("%.2f", ajusteSejetoIvaTotal)},
+		{Clave: "ajusteSujetoIva", Valor: ajusteSejetoIvaTotal.String()},
 		{Clave: "detalleAjusteSujetoIva", Valor: string(ajusteSejetoIvaDetalleJsonString)},
 	}
 
 	if desc_ley1886 > 0 {
-		camposAdicionales = append(camposAdicionales, CampoAdicionalModel{Clave: "beneficiarioLey1886", Valor: nit})
+		camposAdicionales = append(camposAdicionales,
+			CampoAdicionalModel{Clave: "beneficiarioLey1886", Valor: documento.Numero},
+			CampoAdicionalModel{Clave: "montoDescuentoLey1886", Valor: desc_ley1886.String()},
+		)
+	}
+	for _, m := range []struct {
+		clave string
+		monto money.Money
+	}{
+		{"montoDescuentoTarifaDignidad", cargos.DescuentoTarifaDignidad},
+		{"tasaAseo", cargos.TasaAseo},
+		{"tasaAlumbrado", cargos.TasaAlumbrado},
+		{"otrasTasas", cargos.OtrasTasas},
+	} {
+		if m.monto != 0 {
+			camposAdicionales = append(camposAdicionales, CampoAdicionalModel{Clave: m.clave, Valor: m.monto.String()})
+		}
 	}
+	for _, m := range []struct {
+		clave, claveDetalle, concepto, detalle string
+		monto                                  money.Money
+	}{
+		{"ajusteNoSujetoIva", "detalleAjusteNoSujetoIva", "Ajuste no sujeto a IVA", cargos.DetalleAjusteNoSujetoIva, cargos.AjusteNoSujetoIva},
+		{"otrosPagosNoSujetoIva", "detalleOtrosPagosNoSujetoIva", "Otros pagos", cargos.DetalleOtrosPagosNoSujetoIva, cargos.OtrosPagosNoSujetoIva},
+	} {
+		if m.monto == 0 {
+			continue
+		}
+		detalle := m.detalle
+		if detalle == "" {
+			// sin desglose en la base de datos el monto va en un solo concepto
+			b, err := json.Marshal(map[string]string{m.concepto: m.monto.String()})
+			if err != nil {
+				return FacturaRequest{}, fmt.Errorf("error al convertir %s a JSON: %v", m.claveDetalle, err)
+			}
+			detalle = string(b)
+		}
+		camposAdicionales = append(camposAdicionales,
+			CampoAdicionalModel{Clave: m.clave, Valor: m.monto.String()},
+			CampoAdicionalModel{Clave: m.claveDetalle, Valor: detalle},
+		)
+	}
+	consumo := impTotal + desc_ley1886 + cargos.DescuentoTarifaDignidad
+	noSujetoIva := cargos.TasaAseo + cargos.TasaAlumbrado + cargos.OtrasTasas +
+		cargos.AjusteNoSujetoIva + cargos.OtrosPagosNoSujetoIva
 
 	solicitud := SolicitudModel{
-		CodigoModalidad:       1,
+		CodigoModalidad:       fe.emisor.CodigoModalidad,
 		CodigoEmision:         1,
-		CodigoDocumentoSector: 13,
-		CodigoSucursal:        0,
-		CodigoAmbiente:        1,
-		CodigoPuntoVenta:      0,
-		CodigoActividad:       360000,
-		NitEmisor:             "1023807025",
+		CodigoDocumentoSector: SectorServiciosBasicos,
+		CodigoSucursal:        fe.emisor.CodigoSucursal,
+		CodigoAmbiente:        fe.emisor.CodigoAmbiente,
+		CodigoPuntoVenta:      fe.emisor.CodigoPuntoVenta,
+		CodigoActividad:       fe.emisor.CodigoActividad,
+		NitEmisor:             fe.emisor.NitString(),
 		CodigoTipoEvento:      0,
 		// Leyenda: "Leyenda",
 		// NumeroDocumento: nit,
 		// CodigoTipoDocumento: 1,
 		// ComplementoDocumento: "",
 		// RazonSocial: razon,
-		// CorreoCliente: "",
-		FechaEmision: fechaHora,
-		FormatoPdf:   1,
+		CorreoCliente: correo,
+		FechaEmision:  fechaHora,
+		FormatoPdf:    1,
 	}
 
 	cabecera := CabeceraModel{
-		NitEmisor:                    1023807025,
-		RazonSocialEmisor:            "EMPSAAT",
-		Municipio:                    "TUPIZA",
-		Telefono:                     "(2) 6944636",
-		CodigoSucursal:               0,
-		Direccion:                    "Calle Bolivar S/N Zona central",
-		CodigoPuntoVenta:             0,
+		NitEmisor:                    fe.emisor.Nit,
+		RazonSocialEmisor:            fe.emisor.RazonSocial,
+		Municipio:                    fe.emisor.Municipio,
+		Telefono:                     fe.emisor.Telefono,
+		CodigoSucursal:               fe.emisor.CodigoSucursal,
+		Direccion:                    fe.emisor.Direccion,
+		CodigoPuntoVenta:             fe.emisor.CodigoPuntoVenta,
 		NombreRazonSocial:            razon,
-		CodigoTipoDocumentoIdentidad: obtenerTipoDocumento(nit),
-		NumeroDocumento:              nit,
-		Complemento:                  "",
+		CodigoTipoDocumentoIdentidad: int(documento.Tipo),
+		NumeroDocumento:              documento.Numero,
+		Complemento:                  documento.Complemento,
 		CodigoCliente:                abonado,
-		CodigoMetodoPago:             1,
+		CodigoMetodoPago:             MetodoPagoEfectivo,
 		NumeroTarjeta:                0,
 		MontoTotal:                   impFactura,
-		MontoTotalSujetoIva:          impFactura,
-		CodigoMoneda:                 1,
+		MontoTotalSujetoIva:          impFactura - noSujetoIva,
+		CodigoMoneda:                 MonedaBoliviano,
 		TipoCambio:                   1,
 		MontoTotalMoneda:             impFactura,
 		MontoGiftCard:                0,
 		DescuentoAdicional:           0,
-		CodigoExcepcion:              1,
+		CodigoExcepcion:              codigoExcepcion(documento),
 		Cafc:                         "",
-		Leyenda:                      "hola",
-		Usuario:                      "Santiago",
-		CodigoDocumentoSector:        13,
+		Leyenda:                      leyenda,
+		Usuario:                      fe.emisor.Usuario,
+		CodigoDocumentoSector:        SectorServiciosBasicos,
 		FechaEmision:                 fechaHora,
 		CamposAdicionales:            camposAdicionales,
 	}
@@ -151,100 +313,122 @@ func (fe *FacturacionElectronica) FacturaServicios(
 
 	detalle := []DetalleModel{
 		{
-			ActividadEconomica: 360000,
-			CodigoProductoSin:  86330,
+			ActividadEconomica: fe.emisor.CodigoActividad,
+			CodigoProductoSin:  ProductoSinAgua,
 			CodigoProducto:     "001",
 			Descripcion:        "SUBTOTAL SERVICIO DE AGUA",
 			Cantidad:           1,
-			UnidadMedida:       58,
-			PrecioUnitario:     round(impTotal + descuento),
-			MontoDescuento:     descuento,
-			SubTotal:           impTotal,
+			UnidadMedida:       UnidadMedidaServicios,
+			PrecioUnitario:     consumo,
+			MontoDescuento:     0,
+			SubTotal:           consumo,
 			CamposAdicionales:  []CampoAdicionalModel{},
 		},
 	}
 
-	facturaRequest := FacturaRequest{
+	return FacturaRequest{
 		Solicitud: solicitud,
 		Cabecera:  cabecera,
 		Detalle:   detalle,
 		ExtraInfo: []ExtraInfoModel{},
-	}
-
-	return fe.sendFacturaRequest(facturaRequest)
+	}, nil
 }
 
 func (fe *FacturacionElectronica) FacturaCompraVenta(
+	ctx context.Context,
+	facturaDetalle []FacturacionCompraVentaDetalle,
+	impTotal money.Money,
+	razon, abonado, nit, correo string,
+	numero int,
+) (*FacturaResponse, error) {
+	facturaRequest, err := fe.BuildFacturaCompraVenta(facturaDetalle, impTotal, razon, abonado, nit, correo, numero)
+	if err != nil {
+		return nil, err
+	}
+	return fe.EnviarFactura(ctx, facturaRequest)
+}
+
+// BuildFacturaCompraVenta arma la solicitud de sector 1 sin enviarla.
+func (fe *FacturacionElectronica) BuildFacturaCompraVenta(
 	facturaDetalle []FacturacionCompraVentaDetalle,
-	impTotal float64,
-	razon, abonado, nit string,
+	impTotal money.Money,
+	razon, abonado, nit, correo string,
 	numero int,
-) (map[string]interface{}, error) {
-	nit = ifZero(nit, abonado)
+) (FacturaRequest, error) {
+	documento := ClasificarDocumento(nit, abonado)
+	leyenda, err := fe.elegirLeyenda()
+	if err != nil {
+		return FacturaRequest{}, err
+	}
 
 	fechaHora := time.Now().Format("2006-01-02T15:04:05.000")
 
 	solicitud := SolicitudModel{
-		CodigoModalidad:       1,
+		CodigoModalidad:       fe.emisor.CodigoModalidad,
 		CodigoEmision:         1,
-		CodigoDocumentoSector: 1,
-		CodigoSucursal:        0,
-		CodigoAmbiente:        1,
-		CodigoPuntoVenta:      0,
-		CodigoActividad:       360000,
-		NitEmisor:             "1023807025",
+		CodigoDocumentoSector: SectorCompraVenta,
+		CodigoSucursal:        fe.emisor.CodigoSucursal,
+		CodigoAmbiente:        fe.emisor.CodigoAmbiente,
+		CodigoPuntoVenta:      fe.emisor.CodigoPuntoVenta,
+		CodigoActividad:       fe.emisor.CodigoActividad,
+		NitEmisor:             fe.emisor.NitString(),
 		CodigoTipoEvento:      0,
-		Leyenda:               "Leyenda",
+		Leyenda:               leyenda,
 		// NumeroDocumento: nit,
 		// CodigoTipoDocumento: null,
 		// ComplementoDocumento: "",
 		// RazonSocial: razon,
-		// CorreoCliente: "",
-		FechaEmision: fechaHora,
+		CorreoCliente: correo,
+		FechaEmision:  fechaHora,
 	}
 
 	cabecera := CabeceraModel{
-		NitEmisor:                    1023807025,
-		RazonSocialEmisor:            "EMPSAAT",
-		Municipio:                    "TUPIZA",
-		Telefono:                     "(2) 6944636",
-		CodigoSucursal:               0,
-		Direccion:                    "Calle Bolivar S/N Zona central",
-		CodigoPuntoVenta:             0,
+		NitEmisor:                    fe.emisor.Nit,
+		RazonSocialEmisor:            fe.emisor.RazonSocial,
+		Municipio:                    fe.emisor.Municipio,
+		Telefono:                     fe.emisor.Telefono,
+		CodigoSucursal:               fe.emisor.CodigoSucursal,
+		Direccion:                    fe.emisor.Direccion,
+		CodigoPuntoVenta:             fe.emisor.CodigoPuntoVenta,
 		NombreRazonSocial:            razon,
-		CodigoTipoDocumentoIdentidad: obtenerTipoDocumento(nit),
-		NumeroDocumento:              nit,
-		Complemento:                  "",
+		CodigoTipoDocumentoIdentidad: int(documento.Tipo),
+		NumeroDocumento:              documento.Numero,
+		Complemento:                  documento.Complemento,
 		CodigoCliente:                abonado,
-		CodigoMetodoPago:             1,
+		CodigoMetodoPago:             MetodoPagoEfectivo,
 		NumeroTarjeta:                0,
 		MontoTotal:                   impTotal,
 		MontoTotalSujetoIva:          impTotal,
-		CodigoMoneda:                 1,
+		CodigoMoneda:                 MonedaBoliviano,
 		TipoCambio:                   1,
 		MontoTotalMoneda:             impTotal,
 		MontoGiftCard:                0,
 		DescuentoAdicional:           0,
-		CodigoExcepcion:              1,
+		CodigoExcepcion:              codigoExcepcion(documento),
 		Cafc:                         "",
-		Leyenda:                      "hola",
-		Usuario:                      "Santiago",
-		CodigoDocumentoSector:        1,
+		Leyenda:                      leyenda,
+		Usuario:                      fe.emisor.Usuario,
+		CodigoDocumentoSector:        SectorCompraVenta,
 		FechaEmision:                 fechaHora,
 		CamposAdicionales:            []CampoAdicionalModel{},
 	}
 
+	if numero > 0 {
+		solicitud.NumeroFactura = numero
+		cabecera.NumeroFactura = numero
+	}
+
 	detalle := []DetalleModel{}
 	for _, item := range facturaDetalle {
 		detalle = append(detalle, DetalleModel{
-			ActividadEconomica: 360000,
-			CodigoProductoSin:  86330,
+			ActividadEconomica: fe.emisor.CodigoActividad,
+			CodigoProductoSin:  ProductoSinAgua,
 			CodigoProducto:     item.CodigoProducto,
 			Descripcion:        item.Descripcion,
 			Cantidad:           item.Cantidad,
-			UnidadMedida:       62,
+			UnidadMedida:       UnidadMedidaOtro,
 			PrecioUnitario:     item.PrecioUnitario,
-			MontoDescuento:     0,
+			MontoDescuento:     item.MontoDescuento,
 			SubTotal:           item.SubTotal,
 			CamposAdicionales:  []CampoAdicionalModel{},
 		})
@@ -257,69 +441,156 @@ func (fe *FacturacionElectronica) FacturaCompraVenta(
 		ExtraInfo: []ExtraInfoModel{},
 	}
 
-	return fe.sendFacturaRequest(facturaRequest)
+	if err := fe.validar(facturaRequest); err != nil {
+		return FacturaRequest{}, err
+	}
+	return facturaRequest, nil
 }
 
-func (fe *FacturacionElectronica) sendFacturaRequest(facturaRequest FacturaRequest) (map[string]interface{}, error) {
-	jsonData, err := json.MarshalIndent(facturaRequest, "", "  ")
-	// println("jsonData:", string(jsonData))
-	if err != nil {
-		return nil, err
+// validar comprueba la factura contra los catálogos, las reglas de montos
+// y, si está activado, contra el XSD.
+func (fe *FacturacionElectronica) validar(facturaRequest FacturaRequest) error {
+	if err := fe.catalogos.ValidarFactura(facturaRequest); err != nil {
+		return err
+	}
+	if err := ValidarAritmetica(facturaRequest); err != nil {
+		return err
 	}
+	if fe.validarXML {
+		return ValidarFacturaXML(facturaRequest)
+	}
+	return nil
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/invoice-utils/third-party-create", fe.baseUrl), bytes.NewBuffer(jsonData))
+// EnviarFactura envía una solicitud armada con BuildFacturaServicios o
+// BuildFacturaCompraVenta a third-party-create.
+func (fe *FacturacionElectronica) EnviarFactura(ctx context.Context, facturaRequest FacturaRequest) (*FacturaResponse, error) {
+	c := facturaRequest.Cabecera
+	result, err := fe.crear(ctx, facturaRequest, BusquedaFactura{
+		NumeroFactura:         c.NumeroFactura,
+		CodigoSucursal:        c.CodigoSucursal,
+		CodigoPuntoVenta:      c.CodigoPuntoVenta,
+		CodigoDocumentoSector: c.CodigoDocumentoSector,
+		CodigoCliente:         c.CodigoCliente,
+	})
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("api_key", fe.apiKey)
+	// un cuf que no corresponde a la factura se registra pero no se rechaza:
+	// SIN ya la tiene emitida con ese cuf
+	if datos, err := DatosCUFDeFactura(facturaRequest); err == nil {
+		if datos.Numero == 0 {
+			datos.Numero = result.NumeroFactura
+		}
+		if err := VerificarCUF(result.Cuf, datos); err != nil {
+			log.Println("Error checking CUF:", err)
+		}
+	}
+	return result, nil
+}
 
-	resp, err := fe.client.Do(req)
+// crear envía cualquier documento a third-party-create y exige el cuf en
+// la respuesta.
+//
+// third-party-create no es idempotente: solo se reintenta sin más cuando
+// la solicitud no llegó al backend (conexión rechazada o 429). Si pudo
+// llegar (timeout, conexión cortada, 5xx o 409) primero se busca la factura
+// por el X-Request-Id del envío y por su número; si está registrada se
+// devuelve su cuf, si el backend no la tiene se reenvía, y si no se puede
+// saber se devuelve un *ErrorEnvioIncierto. Los documentos sin número solo
+// se encuentran por X-Request-Id, que cambia en cada llamada a crear.
+func (fe *FacturacionElectronica) crear(ctx context.Context, documento interface{}, busqueda BusquedaFactura) (*FacturaResponse, error) {
+	jsonData, err := json.MarshalIndent(documento, "", "  ")
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		println("jsonData:", string(jsonData))
-		return nil, fmt.Errorf("API error: %s", string(body))
+	payloadID := newPayloadID()
+	busqueda.RequestID = payloadID
+	newRequest := func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/invoice-utils/third-party-create", fe.baseUrl), bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("api_key", fe.apiKey)
+		req.Header.Set("X-Request-Id", payloadID)
+		return req, nil
+	}
+
+	policy := fe.politica()
+	var body []byte
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			if err := policy.esperar(ctx, attempt, err); err != nil {
+				return nil, err
+			}
+		}
+		body, err = fe.doOnce(ctx, newRequest, http.StatusCreated)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if PudoRegistrarse(err) {
+			estado, consultaErr := fe.buscarRegistrada(ctx, busqueda)
+			if consultaErr == nil {
+				log.Printf("Factura %d ya registrada con cuf %s (solicitud %s): %v", estado.NumeroFactura, estado.Cuf, payloadID, err)
+				return &FacturaResponse{
+					Cuf:           estado.Cuf,
+					NumeroFactura: estado.NumeroFactura,
+					Estado:        string(estado.Estado),
+					Fecha:         estado.Fecha,
+					Observaciones: estado.Observaciones,
+				}, nil
+			}
+			if !esNoEncontrada(consultaErr) {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				return nil, &ErrorEnvioIncierto{Err: err, Consulta: consultaErr}
+			}
+			// el backend no la tiene: un 409 es un conflicto real y las
+			// demás fallas se pueden reenviar
+		}
+		if !IsRetryable(err) || attempt >= policy.MaxAttempts {
+			return nil, err
+		}
 	}
 
-	var result map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	var result FacturaResponse
+	err = json.Unmarshal(body, &result)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error al interpretar respuesta de la API: %v", err)
+	}
+	if result.Cuf == "" {
+		apiErr := newAPIError(http.StatusCreated, body, payloadID)
+		apiErr.Messages = []string{"la respuesta no contiene cuf"}
+		return nil, apiErr
 	}
 
-	return result, nil
+	return &result, nil
 }
 
-func (fe *FacturacionElectronica) GetFile(cuf string, abonado int) (string, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/invoice-utils/pdf?cuf=%s&formato=4", fe.baseUrl, cuf), nil)
+// GetFile descarga el PDF en formato carta a ./facturas con un nombre
+// temporal y devuelve la ruta.
+func (fe *FacturacionElectronica) GetFile(ctx context.Context, cuf string, abonado int) (string, error) {
+	body, err := fe.DescargarPDF(ctx, cuf, FormatoCarta)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Add("api_key", fe.apiKey)
 
-	resp, err := fe.client.Do(req)
-	if err != nil {
+	if err := os.MkdirAll("./facturas", 0755); err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API error: %s", string(body))
-	}
-
 	tempFile, err := os.CreateTemp("./facturas", fmt.Sprintf("factura_abonado_%d_*.pdf", abonado))
 	if err != nil {
 		return "", err
 	}
 	defer tempFile.Close()
 
-	_, err = io.Copy(tempFile, resp.Body)
+	_, err = tempFile.Write(body)
 	if err != nil {
 		return "", err
 	}
@@ -327,21 +598,12 @@ func (fe *FacturacionElectronica) GetFile(cuf string, abonado int) (string, erro
 	return tempFile.Name(), nil
 }
 
-func obtenerTipoDocumento(documento string) int {
-	if len(documento) > 8 {
-		return 5
-	} else if len(documento) > 6 {
-		return 1
-	} else {
-		return 4
+func newPayloadID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
 	}
-}
-
-func ifZero(value, fallback string) string {
-	if value == "0" {
-		return fallback
-	}
-	return value
+	return hex.EncodeToString(b)
 }
 
 func ifEmpty(value, fallback string) string {
@@ -351,19 +613,43 @@ func ifEmpty(value, fallback string) string {
 	return value
 }
 
-func round(value float64) float64 {
-	return math.Round(value*100) / 100
+// CargosServicios son los montos del sector 13 aparte del consumo, el
+// alcantarillado, la reposición, el recargo y la Ley 1886. Los detalles son
+// objetos JSON de concepto a monto; vacíos, el monto va en un solo concepto.
+type CargosServicios struct {
+	NumeroMedidor           string
+	DescuentoTarifaDignidad money.Money
+	// TasaAseo, TasaAlumbrado, OtrasTasas, AjusteNoSujetoIva y
+	// OtrosPagosNoSujetoIva se cobran pero no son parte del monto sujeto a
+	// IVA
+	TasaAseo                     money.Money
+	TasaAlumbrado                money.Money
+	OtrasTasas                   money.Money
+	AjusteNoSujetoIva            money.Money
+	DetalleAjusteNoSujetoIva     string
+	OtrosPagosNoSujetoIva        money.Money
+	DetalleOtrosPagosNoSujetoIva string
 }
 
 // Definiciones de estructuras y tipos para la API
 type FacturacionCompraVentaDetalle struct {
-	CodigoProducto string  `json:"codigoProducto"`
-	Descripcion    string  `json:"descripcion"`
-	Cantidad       int     `json:"cantidad"`
-	UnidadMedida   int     `json:"unidadMedida"`
-	PrecioUnitario float64 `json:"precioUnitario"`
-	MontoDescuento float64 `json:"montoDescuento"`
-	SubTotal       float64 `json:"subTotal"`
+	CodigoProducto string      `json:"codigoProducto"`
+	Descripcion    string      `json:"descripcion"`
+	Cantidad       int         `json:"cantidad"`
+	UnidadMedida   int         `json:"unidadMedida"`
+	PrecioUnitario money.Money `json:"precioUnitario"`
+	MontoDescuento money.Money `json:"montoDescuento"`
+	SubTotal       money.Money `json:"subTotal"`
+}
+
+// FacturaResponse es la respuesta de third-party-create.
+type FacturaResponse struct {
+	Cuf           string            `json:"cuf"`
+	NumeroFactura int               `json:"numeroFactura"`
+	Estado        string            `json:"estado"`
+	Fecha         string            `json:"fecha"`
+	Urls          map[string]string `json:"urls"`
+	Observaciones []string          `json:"observaciones"`
 }
 
 type FacturaRequest struct {
@@ -413,13 +699,13 @@ type CabeceraModel struct {
 	CodigoCliente                string                `json:"codigoCliente"`
 	CodigoMetodoPago             int                   `json:"codigoMetodoPago"`
 	NumeroTarjeta                int                   `json:"numeroTarjeta"`
-	MontoTotal                   float64               `json:"montoTotal"`
-	MontoTotalSujetoIva          float64               `json:"montoTotalSujetoIva"`
+	MontoTotal                   money.Money           `json:"montoTotal"`
+	MontoTotalSujetoIva          money.Money           `json:"montoTotalSujetoIva"`
 	CodigoMoneda                 int                   `json:"codigoMoneda"`
 	TipoCambio                   float64               `json:"tipoCambio"`
-	MontoTotalMoneda             float64               `json:"montoTotalMoneda"`
-	MontoGiftCard                float64               `json:"montoGiftCard"`
-	DescuentoAdicional           float64               `json:"descuentoAdicional"`
+	MontoTotalMoneda             money.Money           `json:"montoTotalMoneda"`
+	MontoGiftCard                money.Money           `json:"montoGiftCard"`
+	DescuentoAdicional           money.Money           `json:"descuentoAdicional"`
 	CodigoExcepcion              int                   `json:"codigoExcepcion"`
 	Cafc                         string                `json:"cafc"`
 	Leyenda                      string                `json:"leyenda"`
@@ -440,9 +726,9 @@ type DetalleModel struct {
 	Descripcion        string                `json:"descripcion"`
 	Cantidad           int                   `json:"cantidad"`
 	UnidadMedida       int                   `json:"unidadMedida"`
-	PrecioUnitario     float64               `json:"precioUnitario"`
-	MontoDescuento     float64               `json:"montoDescuento"`
-	SubTotal           float64               `json:"subTotal"`
+	PrecioUnitario     money.Money           `json:"precioUnitario"`
+	MontoDescuento     money.Money           `json:"montoDescuento"`
+	SubTotal           money.Money           `json:"subTotal"`
 	CamposAdicionales  []CampoAdicionalModel `json:"camposAdicionales"`
 }
 
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"app/money"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrorAritmetico lista las reglas de montos de SIN que no cumple una
+// factura, con la ruta del campo como en ErrorEsquema.
+type ErrorAritmetico struct {
+	Errores []ErrorCampo
+}
+
+func (e *ErrorAritmetico) Error() string {
+	errores := make([]string, len(e.Errores))
+	for i, campo := range e.Errores {
+		errores[i] = campo.String()
+	}
+	return "los montos no cuadran: " + strings.Join(errores, "; ")
+}
+
+// IsAritmetico indica si err es un rechazo local por montos que no cuadran.
+func IsAritmetico(err error) bool {
+	var aritErr *ErrorAritmetico
+	return errors.As(err, &aritErr)
+}
+
+// Montos del sector 13 que van en los campos adicionales: los descuentos se
+// restan del total y los no sujetos a IVA se suman al total pero no al
+// monto sujeto a IVA.
+var (
+	descuentosServicios = []string{"montoDescuentoLey1886", "montoDescuentoTarifaDignidad"}
+	noSujetosIva        = []string{"tasaAseo", "tasaAlumbrado", "otrasTasas", "ajusteNoSujetoIva", "otrosPagosNoSujetoIva"}
+	detallesServicios   = [][2]string{
+		{"ajusteSujetoIva", "detalleAjusteSujetoIva"},
+		{"ajusteNoSujetoIva", "detalleAjusteNoSujetoIva"},
+		{"otrosPagosNoSujetoIva", "detalleOtrosPagosNoSujetoIva"},
+	}
+)
+
+// ValidarAritmetica comprueba las reglas de montos de SIN para los sectores
+// 1 y 13: cada subTotal es precioUnitario × cantidad - montoDescuento, el
+// montoTotal es la suma del detalle con los descuentos y ajustes de la
+// cabecera, el montoTotalSujetoIva descuenta la gift card y los montos no
+// sujetos a IVA, y cada detalle de ajuste suma su total. Devuelve un
+// *ErrorAritmetico con todas las reglas que no se cumplen.
+func ValidarAritmetica(req FacturaRequest) error {
+	c := req.Cabecera
+	if c.CodigoDocumentoSector != SectorServiciosBasicos && c.CodigoDocumentoSector != SectorCompraVenta {
+		return nil
+	}
+
+	var errores []ErrorCampo
+	agregar := func(campo, formato string, args ...interface{}) {
+		errores = append(errores, ErrorCampo{Campo: campo, Mensaje: fmt.Sprintf(formato, args...)})
+	}
+
+	if len(req.Detalle) == 0 {
+		agregar("detalle", "la factura no tiene líneas")
+	}
+	var suma money.Money
+	for i, item := range req.Detalle {
+		linea := fmt.Sprintf("detalle[%d]/", i)
+		if item.Cantidad <= 0 {
+			agregar(linea+"cantidad", "%d debe ser mayor a cero", item.Cantidad)
+		}
+		if item.PrecioUnitario < 0 {
+			agregar(linea+"precioUnitario", "%s no puede ser negativo", item.PrecioUnitario)
+		}
+		bruto := item.PrecioUnitario.Mul(item.Cantidad)
+		if item.MontoDescuento < 0 || item.MontoDescuento > bruto {
+			agregar(linea+"montoDescuento", "%s fuera de 0 a %s", item.MontoDescuento, bruto)
+		}
+		if esperado := bruto - item.MontoDescuento; item.SubTotal != esperado {
+			agregar(linea+"subTotal", "es %s y precioUnitario × cantidad - montoDescuento da %s", item.SubTotal, esperado)
+		}
+		suma += item.SubTotal
+	}
+
+	if c.DescuentoAdicional < 0 {
+		agregar("cabecera/descuentoAdicional", "%s no puede ser negativo", c.DescuentoAdicional)
+	}
+	if c.MontoGiftCard < 0 {
+		agregar("cabecera/montoGiftCard", "%s no puede ser negativo", c.MontoGiftCard)
+	}
+
+	total := suma - c.DescuentoAdicional
+	var noSujeto money.Money
+	if c.CodigoDocumentoSector == SectorServiciosBasicos {
+		monto := func(clave string) money.Money {
+			valor := strings.TrimSpace(campoAdicional(c, clave))
+			if valor == "" {
+				return 0
+			}
+			m, err := money.Parse(valor)
+			if err != nil {
+				agregar("cabecera/"+clave, "%v", err)
+			}
+			return m
+		}
+
+		for _, clave := range descuentosServicios {
+			descuento := monto(clave)
+			if descuento < 0 {
+				agregar("cabecera/"+clave, "%s no puede ser negativo", descuento)
+			}
+			total -= descuento
+		}
+		total += monto("ajusteSujetoIva")
+		for _, clave := range noSujetosIva {
+			noSujeto += monto(clave)
+		}
+		total += noSujeto
+
+		for _, par := range detallesServicios {
+			detalle := strings.TrimSpace(campoAdicional(c, par[1]))
+			if detalle == "" {
+				continue
+			}
+			var partes map[string]money.Money
+			if err := json.Unmarshal([]byte(detalle), &partes); err != nil {
+				agregar("cabecera/"+par[1], "no es un JSON de montos: %v", err)
+				continue
+			}
+			var sumaDetalle money.Money
+			for _, parte := range partes {
+				sumaDetalle += parte
+			}
+			if esperado := monto(par[0]); sumaDetalle != esperado {
+				agregar("cabecera/"+par[1], "suma %s y %s es %s", sumaDetalle, par[0], esperado)
+			}
+		}
+	}
+
+	if c.MontoTotal != total {
+		agregar("cabecera/montoTotal", "es %s y el detalle con descuentos y ajustes da %s", c.MontoTotal, total)
+	}
+	if esperado := c.MontoTotal - c.MontoGiftCard - noSujeto; c.MontoTotalSujetoIva != esperado {
+		agregar("cabecera/montoTotalSujetoIva", "es %s y montoTotal sin gift card ni montos no sujetos a IVA da %s", c.MontoTotalSujetoIva, esperado)
+	}
+	if c.TipoCambio <= 0 {
+		agregar("cabecera/tipoCambio", "%v debe ser mayor a cero", c.TipoCambio)
+	} else if esperado := money.FromFloat(c.MontoTotal.Float64() / c.TipoCambio); c.MontoTotalMoneda != esperado {
+		agregar("cabecera/montoTotalMoneda", "es %s y montoTotal / tipoCambio da %s", c.MontoTotalMoneda, esperado)
+	}
+
+	if len(errores) > 0 {
+		return &ErrorAritmetico{Errores: errores}
+	}
+	return nil
+}
+
+// campoAdicional devuelve el valor de un campo adicional de la cabecera, o
+// "" si no está.
+func campoAdicional(c CabeceraModel, clave string) string {
+	for _, campo := range c.CamposAdicionales {
+		if campo.Clave == clave {
+			return campo.Valor
+		}
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCuerpoAuditoria limita lo que se guarda de cada cuerpo; los PDF
+// descargados se registran solo por tamaño.
+const maxCuerpoAuditoria = 1 << 20
+
+const redactado = "[REDACTADO]"
+
+// RegistroAuditoria es una línea de la auditoría: un intento de llamada a
+// la API con lo enviado y lo recibido.
+type RegistroAuditoria struct {
+	Fecha      time.Time         `json:"fecha"`
+	Corrida    string            `json:"corrida"`
+	Abonado    string            `json:"abonado,omitempty"`
+	Metodo     string            `json:"metodo"`
+	URL        string            `json:"url"`
+	PayloadID  string            `json:"payloadId,omitempty"`
+	Cabeceras  map[string]string `json:"cabeceras"`
+	Solicitud  string            `json:"solicitud,omitempty"`
+	Estado     int               `json:"estado"`
+	Respuesta  string            `json:"respuesta,omitempty"`
+	LatenciaMs int64             `json:"latenciaMs"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Auditoria guarda cada llamada a la API en archivos JSON por línea que
+// solo crecen, uno por día (auditoria-2024-08-01.jsonl), para poder
+// responder reclamos de SIN o de clientes meses después.
+type Auditoria struct {
+	dir       string
+	retencion time.Duration
+	corrida   string
+	mu        sync.Mutex
+}
+
+// NewAuditoria guarda en dir y borra los archivos más antiguos que
+// retencion; con retencion 0 no se borra nada.
+func NewAuditoria(dir string, retencion time.Duration) *Auditoria {
+	a := &Auditoria{
+		dir:       dir,
+		retencion: retencion,
+		corrida:   NewCorrida(),
+	}
+	if err := a.Depurar(time.Now()); err != nil {
+		log.Println("Error purging auditoria:", err)
+	}
+	return a
+}
+
+// Corrida es el identificador de esta ejecución del programa, que se usa
+// cuando el contexto no trae uno propio.
+func (a *Auditoria) Corrida() string {
+	return a.corrida
+}
+
+// Registrar agrega r al archivo del día.
+func (a *Auditoria) Registrar(r RegistroAuditoria) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(a.dir, "auditoria-"+r.Fecha.Format("2006-01-02")+".jsonl")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Depurar borra los archivos de días anteriores a ahora menos la retención.
+func (a *Auditoria) Depurar(ahora time.Time) error {
+	if a.retencion <= 0 {
+		return nil
+	}
+	archivos, err := filepath.Glob(filepath.Join(a.dir, "auditoria-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	limite := ahora.Add(-a.retencion)
+	for _, archivo := range archivos {
+		nombre := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(archivo), "auditoria-"), ".jsonl")
+		dia, err := time.ParseInLocation("2006-01-02", nombre, ahora.Location())
+		if err != nil {
+			continue
+		}
+		if dia.AddDate(0, 0, 1).Before(limite) {
+			if err := os.Remove(archivo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type auditoriaKey int
+
+const (
+	abonadoKey auditoriaKey = iota
+	corridaKey
+)
+
+// ConAbonado marca las llamadas hechas con ctx como de ese abonado en la
+// auditoría.
+func ConAbonado(ctx context.Context, abonado string) context.Context {
+	return context.WithValue(ctx, abonadoKey, abonado)
+}
+
+// ConCorrida agrupa en la auditoría las llamadas hechas con ctx, p. ej. una
+// facturación masiva.
+func ConCorrida(ctx context.Context, corrida string) context.Context {
+	return context.WithValue(ctx, corridaKey, corrida)
+}
+
+// NewCorrida devuelve un identificador para ConCorrida.
+func NewCorrida() string {
+	return time.Now().Format("20060102-150405") + "-" + newPayloadID()[:4]
+}
+
+// SetAuditoria activa la auditoría de todas las llamadas; nil la desactiva.
+func (fe *FacturacionElectronica) SetAuditoria(auditoria *Auditoria) {
+	fe.auditoria = auditoria
+}
+
+// auditar registra un intento de doOnce. Un error al escribir la auditoría
+// no hace fallar la llamada.
+func (fe *FacturacionElectronica) auditar(ctx context.Context, req *http.Request, solicitud []byte, inicio time.Time, estado int, contentType string, respuesta []byte, callErr error) {
+	if fe.auditoria == nil || req == nil {
+		return
+	}
+
+	cabeceras := map[string]string{}
+	for nombre, valores := range req.Header {
+		cabeceras[nombre] = strings.Join(valores, ", ")
+		if strings.EqualFold(nombre, "api_key") || strings.EqualFold(nombre, "Authorization") {
+			cabeceras[nombre] = redactado
+		}
+	}
+
+	r := RegistroAuditoria{
+		Fecha:      inicio,
+		Corrida:    fe.auditoria.corrida,
+		Metodo:     req.Method,
+		URL:        req.URL.String(),
+		PayloadID:  req.Header.Get("X-Request-Id"),
+		Cabeceras:  cabeceras,
+		Solicitud:  cuerpoAuditoria("application/json", solicitud),
+		Estado:     estado,
+		Respuesta:  cuerpoAuditoria(contentType, respuesta),
+		LatenciaMs: time.Since(inicio).Milliseconds(),
+	}
+	if abonado, ok := ctx.Value(abonadoKey).(string); ok {
+		r.Abonado = abonado
+	}
+	if corrida, ok := ctx.Value(corridaKey).(string); ok {
+		r.Corrida = corrida
+	}
+	if callErr != nil {
+		r.Error = callErr.Error()
+	}
+	if fe.apiKey != "" {
+		r.URL = strings.ReplaceAll(r.URL, fe.apiKey, redactado)
+		r.Solicitud = strings.ReplaceAll(r.Solicitud, fe.apiKey, redactado)
+	}
+
+	if err := fe.auditoria.Registrar(r); err != nil {
+		log.Println("Error writing auditoria:", err)
+	}
+}
+
+// cuerpoAuditoria guarda los cuerpos de texto y resume los binarios.
+func cuerpoAuditoria(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	texto := contentType == "" || strings.Contains(contentType, "json") || strings.Contains(contentType, "xml") || strings.HasPrefix(contentType, "text/")
+	if !texto {
+		return fmt.Sprintf("<%s, %d bytes>", contentType, len(body))
+	}
+	if len(body) > maxCuerpoAuditoria {
+		return string(body[:maxCuerpoAuditoria]) + fmt.Sprintf("... <%d bytes>", len(body))
+	}
+	return string(body)
+}
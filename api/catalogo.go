@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Códigos de los catálogos de SIN que usan las facturas del emisor.
+const (
+	SectorCompraVenta      = 1
+	SectorServiciosBasicos = 13
+
+	MetodoPagoEfectivo = 1
+	MonedaBoliviano    = 1
+
+	ProductoSinAgua       = 86330
+	UnidadMedidaServicios = 58
+	UnidadMedidaOtro      = 62
+)
+
+// TipoCatalogo identifica un catálogo paramétrico de SIN; es también el
+// valor del parámetro tipo del endpoint de catálogos del backend.
+type TipoCatalogo string
+
+const (
+	CatalogoActividades         TipoCatalogo = "actividades"
+	CatalogoProductos           TipoCatalogo = "productos-servicios"
+	CatalogoUnidadesMedida      TipoCatalogo = "unidades-medida"
+	CatalogoMetodosPago         TipoCatalogo = "metodos-pago"
+	CatalogoMonedas             TipoCatalogo = "monedas"
+	CatalogoDocumentosIdentidad TipoCatalogo = "documentos-identidad"
+	CatalogoDocumentosSector    TipoCatalogo = "documentos-sector"
+)
+
+// TiposCatalogo son los catálogos que se sincronizan.
+var TiposCatalogo = []TipoCatalogo{
+	CatalogoActividades,
+	CatalogoProductos,
+	CatalogoUnidadesMedida,
+	CatalogoMetodosPago,
+	CatalogoMonedas,
+	CatalogoDocumentosIdentidad,
+	CatalogoDocumentosSector,
+}
+
+// ItemCatalogo es una entrada de un catálogo. CodigoActividad solo se usa en
+// productos y servicios, que SIN publica por actividad.
+type ItemCatalogo struct {
+	Codigo          int    `json:"codigo"`
+	Descripcion     string `json:"descripcion"`
+	CodigoActividad int    `json:"codigoActividad,omitempty"`
+}
+
+// catalogosPorDefecto son los códigos que usa hoy el emisor, para cuando
+// todavía no se sincronizó el archivo.
+//
+//go:embed catalogos.json
+var catalogosPorDefecto []byte
+
+// Catalogos guarda los catálogos paramétricos y valida las facturas contra
+// ellos. No se modifica después de cargarlo.
+type Catalogos struct {
+	items map[TipoCatalogo][]ItemCatalogo
+}
+
+func NewCatalogos(items map[TipoCatalogo][]ItemCatalogo) *Catalogos {
+	c := &Catalogos{items: map[TipoCatalogo][]ItemCatalogo{}}
+	for tipo, lista := range items {
+		c.items[tipo] = append([]ItemCatalogo(nil), lista...)
+	}
+	return c
+}
+
+// DefaultCatalogos devuelve los catálogos incluidos en el programa.
+func DefaultCatalogos() *Catalogos {
+	var items map[TipoCatalogo][]ItemCatalogo
+	if err := json.Unmarshal(catalogosPorDefecto, &items); err != nil {
+		panic(fmt.Sprintf("catalogos.json inválido: %v", err))
+	}
+	return NewCatalogos(items)
+}
+
+// LoadCatalogos lee catálogos guardados con Save.
+func LoadCatalogos(path string) (*Catalogos, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var items map[TipoCatalogo][]ItemCatalogo
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("error al leer los catálogos %s: %v", path, err)
+	}
+	return NewCatalogos(items), nil
+}
+
+// ImportarCatalogo lee un catálogo exportado del portal de SIN como CSV
+// (codigo;descripcion[;codigoActividad], con o sin encabezado). El tipo se
+// toma del nombre del archivo, p. ej. unidades-medida.csv.
+func ImportarCatalogo(path string) (TipoCatalogo, []ItemCatalogo, error) {
+	tipo := TipoCatalogo(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	if !tipoConocido(tipo) {
+		return "", nil, fmt.Errorf("%s: el nombre del archivo debe ser el tipo de catálogo (%s)", path, listaTipos())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = ';'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var items []ItemCatalogo
+	for linea := 1; ; linea++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(record) < 2 {
+			return "", nil, fmt.Errorf("%s línea %d: se esperaba codigo;descripcion", path, linea)
+		}
+		codigo, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			if linea == 1 {
+				continue // encabezado
+			}
+			return "", nil, fmt.Errorf("%s línea %d: código inválido %q", path, linea, record[0])
+		}
+		item := ItemCatalogo{Codigo: codigo, Descripcion: strings.TrimSpace(record[1])}
+		if len(record) > 2 && strings.TrimSpace(record[2]) != "" {
+			item.CodigoActividad, err = strconv.Atoi(strings.TrimSpace(record[2]))
+			if err != nil {
+				return "", nil, fmt.Errorf("%s línea %d: actividad inválida %q", path, linea, record[2])
+			}
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return "", nil, fmt.Errorf("%s no tiene códigos", path)
+	}
+	return tipo, items, nil
+}
+
+func tipoConocido(tipo TipoCatalogo) bool {
+	for _, t := range TiposCatalogo {
+		if t == tipo {
+			return true
+		}
+	}
+	return false
+}
+
+func listaTipos() string {
+	tipos := make([]string, len(TiposCatalogo))
+	for i, t := range TiposCatalogo {
+		tipos[i] = string(t)
+	}
+	return strings.Join(tipos, ", ")
+}
+
+// Save guarda los catálogos como JSON.
+func (c *Catalogos) Save(path string) error {
+	data, err := json.MarshalIndent(c.items, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Con devuelve una copia con el catálogo tipo reemplazado.
+func (c *Catalogos) Con(tipo TipoCatalogo, items []ItemCatalogo) *Catalogos {
+	copia := NewCatalogos(c.items)
+	copia.items[tipo] = append([]ItemCatalogo(nil), items...)
+	return copia
+}
+
+// Items devuelve las entradas de un catálogo.
+func (c *Catalogos) Items(tipo TipoCatalogo) []ItemCatalogo {
+	return append([]ItemCatalogo(nil), c.items[tipo]...)
+}
+
+// Descripcion devuelve la descripción del código, o "" si no está.
+func (c *Catalogos) Descripcion(tipo TipoCatalogo, codigo int) string {
+	for _, item := range c.items[tipo] {
+		if item.Codigo == codigo {
+			return item.Descripcion
+		}
+	}
+	return ""
+}
+
+// Etiqueta devuelve "código - descripción" para mostrar en pantalla.
+func (c *Catalogos) Etiqueta(tipo TipoCatalogo, codigo int) string {
+	if descripcion := c.Descripcion(tipo, codigo); descripcion != "" {
+		return fmt.Sprintf("%d - %s", codigo, descripcion)
+	}
+	return strconv.Itoa(codigo)
+}
+
+// contiene indica si el código está en el catálogo. Un catálogo vacío no
+// restringe nada: todavía no se sincronizó.
+func (c *Catalogos) contiene(tipo TipoCatalogo, codigo int) bool {
+	items := c.items[tipo]
+	if len(items) == 0 {
+		return true
+	}
+	for _, item := range items {
+		if item.Codigo == codigo {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Catalogos) contieneProducto(actividad, codigo int) bool {
+	items := c.items[CatalogoProductos]
+	if len(items) == 0 {
+		return true
+	}
+	for _, item := range items {
+		if item.Codigo == codigo && (item.CodigoActividad == 0 || item.CodigoActividad == actividad) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorCatalogo lista los códigos de una factura que no están en los
+// catálogos de SIN.
+type ErrorCatalogo struct {
+	Errores []string
+}
+
+func (e *ErrorCatalogo) Error() string {
+	return strings.Join(e.Errores, "; ")
+}
+
+// ValidarFactura comprueba cada código de la factura contra los catálogos.
+func (c *Catalogos) ValidarFactura(req FacturaRequest) error {
+	var errores []string
+	validar := func(tipo TipoCatalogo, campo string, codigo int) {
+		if !c.contiene(tipo, codigo) {
+			errores = append(errores, fmt.Sprintf("%s %d no está en el catálogo %s", campo, codigo, tipo))
+		}
+	}
+
+	validar(CatalogoActividades, "codigoActividad", req.Solicitud.CodigoActividad)
+	validar(CatalogoDocumentosSector, "codigoDocumentoSector", req.Solicitud.CodigoDocumentoSector)
+	if req.Cabecera.CodigoDocumentoSector != req.Solicitud.CodigoDocumentoSector {
+		validar(CatalogoDocumentosSector, "codigoDocumentoSector", req.Cabecera.CodigoDocumentoSector)
+	}
+	validar(CatalogoDocumentosIdentidad, "codigoTipoDocumentoIdentidad", req.Cabecera.CodigoTipoDocumentoIdentidad)
+	validar(CatalogoMetodosPago, "codigoMetodoPago", req.Cabecera.CodigoMetodoPago)
+	validar(CatalogoMonedas, "codigoMoneda", req.Cabecera.CodigoMoneda)
+
+	for i, item := range req.Detalle {
+		linea := fmt.Sprintf("detalle[%d].", i)
+		validar(CatalogoActividades, linea+"actividadEconomica", item.ActividadEconomica)
+		validar(CatalogoUnidadesMedida, linea+"unidadMedida", item.UnidadMedida)
+		if !c.contieneProducto(item.ActividadEconomica, item.CodigoProductoSin) {
+			errores = append(errores, fmt.Sprintf("%scodigoProductoSin %d no está en el catálogo %s de la actividad %d",
+				linea, item.CodigoProductoSin, CatalogoProductos, item.ActividadEconomica))
+		}
+	}
+
+	if len(errores) > 0 {
+		return &ErrorCatalogo{Errores: errores}
+	}
+	return nil
+}
+
+// IsCatalogo indica si err es un rechazo local por códigos fuera de catálogo.
+func IsCatalogo(err error) bool {
+	var catErr *ErrorCatalogo
+	return errors.As(err, &catErr)
+}
+
+// ListarCatalogo descarga un catálogo paramétrico desde el backend.
+func (fe *FacturacionElectronica) ListarCatalogo(ctx context.Context, tipo TipoCatalogo) ([]ItemCatalogo, error) {
+	query := url.Values{"tipo": {string(tipo)}}
+	var result []ItemCatalogo
+	if err := fe.getJSON(ctx, "/api/v1/invoice-utils/catalogos?"+query.Encode(), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// InvoiceClient son las llamadas al backend de facturación que usan la UI
+// y los comandos. FacturacionElectronica es la implementación real; el
+// paquete mockapi ofrece una simulada para pruebas locales.
+type InvoiceClient interface {
+	EnviarFactura(ctx context.Context, facturaRequest FacturaRequest) (*FacturaResponse, error)
+	EnviarNotaCreditoDebito(ctx context.Context, notaRequest NotaCreditoDebitoRequest) (*FacturaResponse, error)
+	GetFile(ctx context.Context, cuf string, abonado int) (string, error)
+	DescargarPDF(ctx context.Context, cuf string, formato FormatoPdf) ([]byte, error)
+	DescargarXML(ctx context.Context, cuf string) ([]byte, error)
+	ConsultarEstado(ctx context.Context, cuf string) (*EstadoFacturaResponse, error)
+	VerificarNit(ctx context.Context, nit string) (*VerificacionNit, error)
+	ListarLeyendas(ctx context.Context, actividad int) ([]Leyenda, error)
+	ListarCatalogo(ctx context.Context, tipo TipoCatalogo) ([]ItemCatalogo, error)
+	AnularFactura(ctx context.Context, cuf string, motivo MotivoAnulacion) (*AnulacionResponse, error)
+	RegistrarEvento(ctx context.Context, motivo EventoSignificativo, descripcion string, inicio, fin time.Time) (*EventoResponse, error)
+	EnviarPaquete(ctx context.Context, codigoEvento string, facturas []FacturaRequest) (*PaqueteResponse, error)
+}
+
+var _ InvoiceClient = (*FacturacionElectronica)(nil)
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Códigos de emisión de SIN.
+const (
+	EmisionEnLinea      = 1
+	EmisionFueraDeLinea = 2
+	EmisionMasiva       = 3
+)
+
+// EventoSignificativo corresponde al catálogo "EVENTOS SIGNIFICATIVOS" de SIN.
+type EventoSignificativo int
+
+const (
+	EventoCorteInternet         EventoSignificativo = 1
+	EventoInaccesibilidadSIN    EventoSignificativo = 2
+	EventoZonaSinInternet       EventoSignificativo = 3
+	EventoVentaSinInternet      EventoSignificativo = 4
+	EventoFallaSoftware         EventoSignificativo = 5
+	EventoCambioInfraestructura EventoSignificativo = 6
+	EventoCorteEnergia          EventoSignificativo = 7
+)
+
+var EventosSignificativos = map[EventoSignificativo]string{
+	EventoCorteInternet:         "CORTE DEL SERVICIO DE INTERNET",
+	EventoInaccesibilidadSIN:    "INACCESIBILIDAD AL SERVICIO WEB DE LA ADMINISTRACIÓN TRIBUTARIA",
+	EventoZonaSinInternet:       "INGRESO A ZONAS SIN INTERNET POR DESPLIEGUE DE PUNTO DE VENTA EN VEHICULOS AUTOMOTORES",
+	EventoVentaSinInternet:      "VENTA EN LUGARES SIN INTERNET",
+	EventoFallaSoftware:         "VIRUS INFORMÁTICO O FALLA DE SOFTWARE",
+	EventoCambioInfraestructura: "CAMBIO DE INFRAESTRUCTURA DE SISTEMA O FALLA DE HARDWARE",
+	EventoCorteEnergia:          "CORTE DE SUMINISTRO DE ENERGIA ELECTRICA",
+}
+
+func (e EventoSignificativo) String() string {
+	if descripcion, ok := EventosSignificativos[e]; ok {
+		return descripcion
+	}
+	return fmt.Sprintf("EVENTO %d", int(e))
+}
+
+// FueraDeLinea marca una solicitud como emitida en contingencia bajo el
+// evento indicado. La factura conserva su fecha de emisión original.
+func FueraDeLinea(facturaRequest *FacturaRequest, evento EventoSignificativo) {
+	facturaRequest.Solicitud.CodigoEmision = EmisionFueraDeLinea
+	facturaRequest.Solicitud.CodigoTipoEvento = int(evento)
+}
+
+// EventoContingencia es el evento significativo que se registra antes de
+// enviar un paquete de facturas emitidas fuera de línea.
+type EventoContingencia struct {
+	CodigoMotivoEvento    EventoSignificativo `json:"codigoMotivoEvento"`
+	Descripcion           string              `json:"descripcion"`
+	FechaHoraInicioEvento string              `json:"fechaHoraInicioEvento"`
+	FechaHoraFinEvento    string              `json:"fechaHoraFinEvento"`
+	CodigoSucursal        int                 `json:"codigoSucursal"`
+	CodigoPuntoVenta      int                 `json:"codigoPuntoVenta"`
+	NitEmisor             string              `json:"nitEmisor"`
+}
+
+type EventoResponse struct {
+	CodigoRecepcionEvento string `json:"codigoRecepcionEventoSignificativo"`
+}
+
+// PaqueteResponse es la respuesta de third-party-package; Facturas trae
+// el resultado de cada factura del paquete.
+type PaqueteResponse struct {
+	CodigoRecepcion string            `json:"codigoRecepcion"`
+	Estado          string            `json:"estado"`
+	Facturas        []FacturaResponse `json:"facturas"`
+}
+
+// RegistrarEvento registra un evento significativo entre inicio y fin.
+func (fe *FacturacionElectronica) RegistrarEvento(ctx context.Context, motivo EventoSignificativo, descripcion string, inicio, fin time.Time) (*EventoResponse, error) {
+	evento := EventoContingencia{
+		CodigoMotivoEvento:    motivo,
+		Descripcion:           ifEmpty(descripcion, motivo.String()),
+		FechaHoraInicioEvento: inicio.Format("2006-01-02T15:04:05.000"),
+		FechaHoraFinEvento:    fin.Format("2006-01-02T15:04:05.000"),
+		CodigoSucursal:        fe.emisor.CodigoSucursal,
+		CodigoPuntoVenta:      fe.emisor.CodigoPuntoVenta,
+		NitEmisor:             fe.emisor.NitString(),
+	}
+
+	var result EventoResponse
+	if err := fe.postJSON(ctx, "/api/v1/invoice-utils/third-party-event", evento, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// EnviarPaquete envía las facturas emitidas fuera de línea durante el
+// evento registrado con codigoEvento.
+func (fe *FacturacionElectronica) EnviarPaquete(ctx context.Context, codigoEvento string, facturas []FacturaRequest) (*PaqueteResponse, error) {
+	paquete := map[string]interface{}{
+		"codigoRecepcionEventoSignificativo": codigoEvento,
+		"facturas":                           facturas,
+	}
+
+	var result PaqueteResponse
+	if err := fe.postJSON(ctx, "/api/v1/invoice-utils/third-party-package", paquete, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// postJSON envía payload a path y decodifica la respuesta 201 en result.
+//
+// Eventos, paquetes y anulaciones no son idempotentes: como en crear, solo
+// se reintenta cuando la solicitud no llegó al backend. Si pudo llegar se
+// devuelve un *ErrorEnvioIncierto sin reenviarla.
+func (fe *FacturacionElectronica) postJSON(ctx context.Context, path string, payload, result interface{}) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	payloadID := newPayloadID()
+	newRequest := func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", fe.baseUrl+path, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("api_key", fe.apiKey)
+		req.Header.Set("X-Request-Id", payloadID)
+		return req, nil
+	}
+
+	policy := fe.politica()
+	var body []byte
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			if err := policy.esperar(ctx, attempt, err); err != nil {
+				return err
+			}
+		}
+		body, err = fe.doOnce(ctx, newRequest, http.StatusCreated)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if PudoRegistrarse(err) {
+			return &ErrorEnvioIncierto{Err: err}
+		}
+		if !IsRetryable(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("error al interpretar respuesta de %s: %v", path, err)
+	}
+	return nil
+}
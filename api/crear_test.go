@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// backendCrear simula third-party-create con respuestas perdidas: registra
+// la factura y responde con fallarCon, o responde sin registrarla si
+// registrar es false.
+type backendCrear struct {
+	mu          sync.Mutex
+	posts       int
+	fallarCon   []int
+	registrar   []bool
+	statusCaido bool
+	// sinFiltros responde a cualquier consulta con otra factura, como un
+	// backend que ignora requestId y numeroFactura
+	sinFiltros   bool
+	porSolicitud map[string]EstadoFacturaResponse
+	porNumero    map[int]EstadoFacturaResponse
+}
+
+func (b *backendCrear) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/api/v1/invoice-utils/third-party-create":
+		var req FacturaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		intento := b.posts
+		b.posts++
+		if intento < len(b.registrar) && !b.registrar[intento] {
+			w.WriteHeader(b.fallarCon[intento])
+			return
+		}
+		numero := req.Cabecera.NumeroFactura
+		if existente, ok := b.porNumero[numero]; ok {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"message": "ya emitida con cuf " + existente.Cuf})
+			return
+		}
+		estado := EstadoFacturaResponse{Cuf: "CUF" + r.Header.Get("X-Request-Id"), NumeroFactura: numero,
+			RequestID: r.Header.Get("X-Request-Id"), CodigoCliente: req.Cabecera.CodigoCliente, Estado: EstadoValidada}
+		b.porSolicitud[r.Header.Get("X-Request-Id")] = estado
+		b.porNumero[numero] = estado
+		if intento < len(b.fallarCon) {
+			w.WriteHeader(b.fallarCon[intento])
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(FacturaResponse{Cuf: estado.Cuf, NumeroFactura: numero})
+	case "/api/v1/invoice-utils/status":
+		if b.statusCaido {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if b.sinFiltros {
+			json.NewEncoder(w).Encode(EstadoFacturaResponse{Cuf: "CUFOTRA", NumeroFactura: 8, CodigoCliente: "100", Estado: EstadoValidada})
+			return
+		}
+		q := r.URL.Query()
+		estado, ok := b.porSolicitud[q.Get("requestId")]
+		if !ok && q.Get("numeroFactura") != "" {
+			var numero int
+			json.Unmarshal([]byte(q.Get("numeroFactura")), &numero)
+			estado, ok = b.porNumero[numero]
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(estado)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestCrearNoDuplicaFacturas(t *testing.T) {
+	anterior := EstadoFacturaResponse{Cuf: "CUFANTERIOR", NumeroFactura: 7, CodigoCliente: "100", Estado: EstadoValidada}
+	otroCliente := EstadoFacturaResponse{Cuf: "CUFOTRO", NumeroFactura: 7, CodigoCliente: "200", Estado: EstadoValidada}
+
+	tests := []struct {
+		nombre    string
+		backend   *backendCrear
+		existente *EstadoFacturaResponse
+		cuf       string
+		posts     int
+		incierto  bool
+		conflicto bool
+	}{
+		{nombre: "registrada con respuesta perdida", backend: &backendCrear{fallarCon: []int{http.StatusGatewayTimeout}}, posts: 1},
+		{nombre: "409 de un envío anterior", existente: &anterior, cuf: "CUFANTERIOR", posts: 1},
+		{nombre: "409 de otro cliente", existente: &otroCliente, posts: 1, conflicto: true},
+		{nombre: "5xx sin registrar se reenvía", backend: &backendCrear{fallarCon: []int{http.StatusServiceUnavailable}, registrar: []bool{false}}, posts: 2},
+		{nombre: "sin poder consultar", backend: &backendCrear{fallarCon: []int{http.StatusGatewayTimeout}, statusCaido: true}, posts: 1, incierto: true},
+		{nombre: "status sin filtrar", backend: &backendCrear{fallarCon: []int{http.StatusGatewayTimeout}, sinFiltros: true}, posts: 1, incierto: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nombre, func(t *testing.T) {
+			b := tt.backend
+			if b == nil {
+				b = &backendCrear{}
+			}
+			b.porSolicitud = map[string]EstadoFacturaResponse{}
+			b.porNumero = map[int]EstadoFacturaResponse{}
+			if tt.existente != nil {
+				b.porNumero[7] = *tt.existente
+			}
+			server := httptest.NewServer(b)
+			defer server.Close()
+
+			fe := NewFacturacionElectronica(ApiConfig{
+				Url:   server.URL,
+				Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			}, EmisorProfile{})
+			req := FacturaRequest{Cabecera: CabeceraModel{NumeroFactura: 7, CodigoCliente: "100", CodigoDocumentoSector: SectorServiciosBasicos}}
+			result, err := fe.crear(context.Background(), req, BusquedaFactura{
+				NumeroFactura: 7, CodigoCliente: "100", CodigoDocumentoSector: SectorServiciosBasicos,
+			})
+
+			if b.posts != tt.posts {
+				t.Errorf("posts = %d, se esperaban %d", b.posts, tt.posts)
+			}
+			switch {
+			case tt.incierto:
+				if !IsIncierto(err) || IsOutage(err) {
+					t.Fatalf("err = %v, se esperaba un envío incierto que no sea caída", err)
+				}
+			case tt.conflicto:
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+					t.Fatalf("err = %v, se esperaba el 409", err)
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(b.porNumero) != 1 {
+					t.Errorf("%d facturas registradas", len(b.porNumero))
+				}
+				if tt.cuf != "" && result.Cuf != tt.cuf {
+					t.Errorf("cuf = %s, se esperaba %s", result.Cuf, tt.cuf)
+				}
+				if result.Cuf != b.porNumero[7].Cuf {
+					t.Errorf("cuf = %s, el registrado es %s", result.Cuf, b.porNumero[7].Cuf)
+				}
+			}
+		})
+	}
+}
+
+func TestPostJSONNoReenvia(t *testing.T) {
+	tests := []struct {
+		nombre   string
+		status   int
+		posts    int
+		incierto bool
+	}{
+		{nombre: "5xx pudo registrarse", status: http.StatusServiceUnavailable, posts: 1, incierto: true},
+		{nombre: "429 no llegó a procesarse", status: http.StatusTooManyRequests, posts: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nombre, func(t *testing.T) {
+			var mu sync.Mutex
+			posts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				posts++
+				mu.Unlock()
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			fe := NewFacturacionElectronica(ApiConfig{
+				Url:   server.URL,
+				Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			}, EmisorProfile{})
+			_, err := fe.EnviarPaquete(context.Background(), "EV1", []FacturaRequest{{}})
+			if err == nil {
+				t.Fatal("se esperaba un error")
+			}
+			if IsIncierto(err) != tt.incierto {
+				t.Errorf("err = %v, incierto = %v", err, IsIncierto(err))
+			}
+			if posts != tt.posts {
+				t.Errorf("posts = %d, se esperaban %d", posts, tt.posts)
+			}
+		})
+	}
+}
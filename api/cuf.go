@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tipos de factura/documento que forman parte del CUF.
+const (
+	TipoFacturaCreditoFiscal    = 1
+	TipoFacturaSinCreditoFiscal = 2
+	TipoDocumentoAjuste         = 3
+)
+
+const formatoFechaCUF = "20060102150405.000"
+
+// DatosCUF son los campos de la factura con los que SIN arma el CUF.
+type DatosCUF struct {
+	Nit             int64
+	FechaHora       time.Time
+	Sucursal        int
+	Modalidad       int
+	TipoEmision     int
+	TipoFactura     int
+	DocumentoSector int
+	Numero          int
+	PuntoVenta      int
+}
+
+// cadena concatena los campos con el ancho fijo que define SIN: NIT (13),
+// fecha y hora con milisegundos (17), sucursal (4), modalidad (1), tipo de
+// emisión (1), tipo de factura (1), documento sector (2), número (10) y
+// punto de venta (4).
+func (d DatosCUF) cadena() (string, error) {
+	campos := []struct {
+		nombre string
+		valor  int64
+		ancho  int
+	}{
+		{"nit", d.Nit, 13},
+		{"sucursal", int64(d.Sucursal), 4},
+		{"modalidad", int64(d.Modalidad), 1},
+		{"tipo de emisión", int64(d.TipoEmision), 1},
+		{"tipo de factura", int64(d.TipoFactura), 1},
+		{"documento sector", int64(d.DocumentoSector), 2},
+		{"número de factura", int64(d.Numero), 10},
+		{"punto de venta", int64(d.PuntoVenta), 4},
+	}
+	partes := map[string]string{}
+	for _, c := range campos {
+		texto := strconv.FormatInt(c.valor, 10)
+		if c.valor < 0 || len(texto) > c.ancho {
+			return "", fmt.Errorf("el %s %d no entra en %d dígitos del CUF", c.nombre, c.valor, c.ancho)
+		}
+		partes[c.nombre] = strings.Repeat("0", c.ancho-len(texto)) + texto
+	}
+	if d.Numero == 0 {
+		return "", fmt.Errorf("la factura no tiene número para armar el CUF")
+	}
+	fecha := strings.Replace(d.FechaHora.Format(formatoFechaCUF), ".", "", 1)
+
+	return partes["nit"] + fecha + partes["sucursal"] + partes["modalidad"] +
+		partes["tipo de emisión"] + partes["tipo de factura"] + partes["documento sector"] +
+		partes["número de factura"] + partes["punto de venta"], nil
+}
+
+// modulo11 es el dígito verificador de SIN: pesos de 2 a 9 desde la
+// derecha, resto de la suma entre 11, y 10 se escribe como 1.
+func modulo11(cadena string) string {
+	suma, peso := 0, 2
+	for i := len(cadena) - 1; i >= 0; i-- {
+		suma += int(cadena[i]-'0') * peso
+		if peso++; peso > 9 {
+			peso = 2
+		}
+	}
+	digito := suma % 11
+	if digito == 10 {
+		return "1"
+	}
+	return strconv.Itoa(digito)
+}
+
+// GenerarCUF arma el CUF como lo hace SIN: la cadena de 53 dígitos de
+// DatosCUF más su dígito módulo 11, pasada a base 16 en mayúsculas, seguida
+// del código de control del CUFD vigente.
+//
+// Por ejemplo, NIT 1023807025, 2024-08-01 10:30:15.123, sucursal 0,
+// modalidad 1, emisión en línea, crédito fiscal, sector 13, factura 152 y
+// punto de venta 0 forman
+//
+//	0001023807025 20240801103015123 0000 1 1 1 13 0000000152 0000
+//
+// con dígito verificador 1, y con el código de control A19E95B0C3DAB92 dan
+// el CUF 460D3D7A9E66865C75E792B7FAA95E66E6A0D26F01A19E95B0C3DAB92.
+func GenerarCUF(datos DatosCUF, codigoControl string) (string, error) {
+	cadena, err := datos.cadena()
+	if err != nil {
+		return "", err
+	}
+	numero, _ := new(big.Int).SetString(cadena+modulo11(cadena), 10)
+	return strings.ToUpper(numero.Text(16)) + codigoControl, nil
+}
+
+// DatosCUFDeFactura toma los datos del CUF de una factura armada con
+// BuildFacturaServicios o BuildFacturaCompraVenta.
+func DatosCUFDeFactura(req FacturaRequest) (DatosCUF, error) {
+	c := req.Cabecera
+	fecha, err := time.ParseInLocation("2006-01-02T15:04:05.000", c.FechaEmision, time.Local)
+	if err != nil {
+		return DatosCUF{}, fmt.Errorf("fecha de emisión %q inválida para el CUF: %v", c.FechaEmision, err)
+	}
+	return DatosCUF{
+		Nit:             c.NitEmisor,
+		FechaHora:       fecha,
+		Sucursal:        c.CodigoSucursal,
+		Modalidad:       req.Solicitud.CodigoModalidad,
+		TipoEmision:     req.Solicitud.CodigoEmision,
+		TipoFactura:     tipoFactura(c.CodigoDocumentoSector),
+		DocumentoSector: c.CodigoDocumentoSector,
+		Numero:          c.NumeroFactura,
+		PuntoVenta:      c.CodigoPuntoVenta,
+	}, nil
+}
+
+func tipoFactura(sector int) int {
+	if sector == SectorNotaCreditoDebito {
+		return TipoDocumentoAjuste
+	}
+	return TipoFacturaCreditoFiscal
+}
+
+// VerificarCUF controla que el cuf devuelto por el backend corresponda a
+// datos. La fecha y hora no se comparan porque el backend puede usar la de
+// su recepción.
+func VerificarCUF(cuf string, datos DatosCUF) error {
+	_, err := buscarCadenaCUF(cuf, datos)
+	return err
+}
+
+// FechaCUF devuelve la fecha y hora de emisión que lleva el cuf de la
+// factura descrita por datos.
+func FechaCUF(cuf string, datos DatosCUF) (time.Time, error) {
+	cadena, err := buscarCadenaCUF(cuf, datos)
+	if err != nil {
+		return time.Time{}, err
+	}
+	fecha := cadena[13:27] + "." + cadena[27:30]
+	return time.ParseInLocation(formatoFechaCUF, fecha, time.Local)
+}
+
+// buscarCadenaCUF recupera la cadena de 53 dígitos del cuf. El código de
+// control del CUFD no se conoce de antemano, así que se prueba cada largo
+// posible de la parte en base 16 hasta encontrar una con dígito verificador
+// válido y los mismos campos que datos, salvo la fecha.
+func buscarCadenaCUF(cuf string, datos DatosCUF) (string, error) {
+	esperado, err := datos.cadena()
+	if err != nil {
+		return "", err
+	}
+	sinFecha := func(cadena string) string {
+		return cadena[:13] + cadena[30:]
+	}
+
+	for largo := min(len(cuf), 45); largo >= 30; largo-- {
+		numero, ok := new(big.Int).SetString(cuf[:largo], 16)
+		if !ok {
+			continue
+		}
+		texto := numero.Text(10)
+		if len(texto) > 54 {
+			continue
+		}
+		texto = strings.Repeat("0", 54-len(texto)) + texto
+		cadena, digito := texto[:53], texto[53:]
+		if modulo11(cadena) != digito {
+			continue
+		}
+		if _, err := time.Parse("20060102150405", cadena[13:27]); err != nil {
+			continue
+		}
+		if sinFecha(cadena) == sinFecha(esperado) {
+			return cadena, nil
+		}
+	}
+	return "", fmt.Errorf("el cuf %s no corresponde a nit %d, sucursal %d, documento sector %d, factura %d y punto de venta %d",
+		cuf, datos.Nit, datos.Sucursal, datos.DocumentoSector, datos.Numero, datos.PuntoVenta)
+}
+
+// Cufd es el código diario con el que se firman los CUF. Se guarda en un
+// archivo JSON para poder armar CUF fuera de línea.
+type Cufd struct {
+	Codigo        string    `json:"codigo"`
+	CodigoControl string    `json:"codigoControl"`
+	FechaVigencia time.Time `json:"fechaVigencia"`
+}
+
+// LoadCufd lee el CUFD de un archivo JSON.
+func LoadCufd(path string) (*Cufd, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cufd Cufd
+	if err := json.Unmarshal(data, &cufd); err != nil {
+		return nil, fmt.Errorf("error al interpretar CUFD %s: %v", path, err)
+	}
+	if cufd.Codigo == "" || cufd.CodigoControl == "" {
+		return nil, fmt.Errorf("el CUFD %s no tiene código o código de control", path)
+	}
+	return &cufd, nil
+}
+
+// SetCufd fija el CUFD con el que PreasignarCUF arma los CUF.
+func (fe *FacturacionElectronica) SetCufd(cufd *Cufd) {
+	fe.cufd = cufd
+}
+
+// PreasignarCUF completa el cufd y el cuf de una factura fuera de línea con
+// el CUFD cargado, para guardarlo antes de enviarla. Devuelve false sin
+// tocar la factura si no hay un CUFD vigente para su fecha de emisión o si
+// el número lo asigna el backend.
+func (fe *FacturacionElectronica) PreasignarCUF(req *FacturaRequest) (bool, error) {
+	if fe.cufd == nil || req.Cabecera.NumeroFactura == 0 {
+		return false, nil
+	}
+	datos, err := DatosCUFDeFactura(*req)
+	if err != nil {
+		return false, err
+	}
+	if !fe.cufd.FechaVigencia.IsZero() && datos.FechaHora.After(fe.cufd.FechaVigencia) {
+		return false, nil
+	}
+	cuf, err := GenerarCUF(datos, fe.cufd.CodigoControl)
+	if err != nil {
+		return false, err
+	}
+	req.Cabecera.Cufd = fe.cufd.Codigo
+	req.Cabecera.Cuf = cuf
+	return true, nil
+}
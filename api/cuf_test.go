@@ -0,0 +1,127 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// datosEjemplo es el ejemplo del comentario de GenerarCUF.
+func datosEjemplo() DatosCUF {
+	return DatosCUF{
+		Nit:             1023807025,
+		FechaHora:       time.Date(2024, 8, 1, 10, 30, 15, 123e6, time.Local),
+		Sucursal:        0,
+		Modalidad:       1,
+		TipoEmision:     EmisionEnLinea,
+		TipoFactura:     TipoFacturaCreditoFiscal,
+		DocumentoSector: SectorServiciosBasicos,
+		Numero:          152,
+		PuntoVenta:      0,
+	}
+}
+
+func TestModulo11(t *testing.T) {
+	tests := []struct {
+		cadena string
+		digito string
+	}{
+		{cadena: "00010238070252024080110301512300001111300000001520000", digito: "1"},
+		{cadena: "1", digito: "2"},
+		{cadena: "6", digito: "1"},
+		// 5*2 = 10, que se escribe como 1
+		{cadena: "5", digito: "1"},
+		{cadena: "0", digito: "0"},
+		// los pesos vuelven a 2 después de 9: 1*2 + 1*9 = 11
+		{cadena: "10000001", digito: "0"},
+	}
+	for _, tt := range tests {
+		if got := modulo11(tt.cadena); got != tt.digito {
+			t.Errorf("modulo11(%s) = %s, se esperaba %s", tt.cadena, got, tt.digito)
+		}
+	}
+}
+
+func TestGenerarCUF(t *testing.T) {
+	sinNumero := datosEjemplo()
+	sinNumero.Numero = 0
+	sucursalLarga := datosEjemplo()
+	sucursalLarga.Sucursal = 12345
+
+	tests := []struct {
+		nombre string
+		datos  DatosCUF
+		cuf    string
+		err    bool
+	}{
+		{nombre: "ejemplo del comentario", datos: datosEjemplo(), cuf: "460D3D7A9E66865C75E792B7FAA95E66E6A0D26F01A19E95B0C3DAB92"},
+		{nombre: "sin número", datos: sinNumero, err: true},
+		{nombre: "sucursal de más de 4 dígitos", datos: sucursalLarga, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nombre, func(t *testing.T) {
+			cadena, _ := tt.datos.cadena()
+			cuf, err := GenerarCUF(tt.datos, "A19E95B0C3DAB92")
+			if tt.err {
+				if err == nil {
+					t.Fatalf("cuf = %s, se esperaba un error", cuf)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cadena != "00010238070252024080110301512300001111300000001520000" {
+				t.Errorf("cadena = %s", cadena)
+			}
+			if cuf != tt.cuf {
+				t.Errorf("cuf = %s, se esperaba %s", cuf, tt.cuf)
+			}
+		})
+	}
+}
+
+func TestVerificarCUF(t *testing.T) {
+	datos := datosEjemplo()
+	cuf, err := GenerarCUF(datos, "A19E95B0C3DAB92")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fecha, err := FechaCUF(cuf, datos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fecha.Equal(datos.FechaHora) {
+		t.Errorf("FechaCUF = %v, se esperaba %v", fecha, datos.FechaHora)
+	}
+
+	otraFecha := datos
+	otraFecha.FechaHora = otraFecha.FechaHora.Add(3 * time.Hour)
+	otroNumero := datos
+	otroNumero.Numero = 153
+	otraSucursal := datos
+	otraSucursal.Sucursal = 1
+	fueraDeLinea := datos
+	fueraDeLinea.TipoEmision = EmisionFueraDeLinea
+
+	tests := []struct {
+		nombre string
+		datos  DatosCUF
+		valido bool
+	}{
+		{nombre: "mismos datos", datos: datos, valido: true},
+		{nombre: "la fecha no se compara", datos: otraFecha, valido: true},
+		{nombre: "otro número", datos: otroNumero},
+		{nombre: "otra sucursal", datos: otraSucursal},
+		{nombre: "otro tipo de emisión", datos: fueraDeLinea},
+	}
+	for _, tt := range tests {
+		err := VerificarCUF(cuf, tt.datos)
+		if tt.valido && err != nil {
+			t.Errorf("%s: %v", tt.nombre, err)
+		}
+		if !tt.valido && err == nil {
+			t.Errorf("%s: se aceptó el cuf %s", tt.nombre, cuf)
+		}
+	}
+}
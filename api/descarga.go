@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// FormatoPdf es el formato de la representación gráfica que genera el
+// backend.
+type FormatoPdf int
+
+const (
+	FormatoRollo FormatoPdf = 1
+	FormatoCarta FormatoPdf = 4
+)
+
+// ParseFormatoPdf acepta "rollo", "carta" o el código numérico.
+func ParseFormatoPdf(s string) (FormatoPdf, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "rollo":
+		return FormatoRollo, nil
+	case "carta":
+		return FormatoCarta, nil
+	}
+	codigo, err := strconv.Atoi(s)
+	if err != nil || codigo <= 0 {
+		return 0, fmt.Errorf("formato inválido: %q (use rollo o carta)", s)
+	}
+	return FormatoPdf(codigo), nil
+}
+
+func (f FormatoPdf) String() string {
+	switch f {
+	case FormatoRollo:
+		return "rollo"
+	case FormatoCarta:
+		return "carta"
+	}
+	return strconv.Itoa(int(f))
+}
+
+// DescargarPDF devuelve la representación gráfica de la factura.
+func (fe *FacturacionElectronica) DescargarPDF(ctx context.Context, cuf string, formato FormatoPdf) ([]byte, error) {
+	query := url.Values{"cuf": {cuf}, "formato": {strconv.Itoa(int(formato))}}
+	return fe.getFile(ctx, "/api/v1/invoice-utils/pdf?"+query.Encode())
+}
+
+// DescargarXML devuelve el XML firmado de la factura.
+func (fe *FacturacionElectronica) DescargarXML(ctx context.Context, cuf string) ([]byte, error) {
+	query := url.Values{"cuf": {cuf}}
+	return fe.getFile(ctx, "/api/v1/invoice-utils/xml?"+query.Encode())
+}
+
+func (fe *FacturacionElectronica) getFile(ctx context.Context, path string) ([]byte, error) {
+	return fe.get(ctx, path, "")
+}
+
+// getJSON consulta path y decodifica la respuesta 200 en result.
+func (fe *FacturacionElectronica) getJSON(ctx context.Context, path string, result interface{}) error {
+	body, err := fe.get(ctx, path, "application/json")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("error al interpretar respuesta de %s: %v", path, err)
+	}
+	return nil
+}
+
+func (fe *FacturacionElectronica) get(ctx context.Context, path, accept string) ([]byte, error) {
+	return fe.doWithRetry(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", fe.baseUrl+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("api_key", fe.apiKey)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		return req, nil
+	}, http.StatusOK)
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TipoDocumento corresponde al catálogo "TIPO DOCUMENTO IDENTIDAD" de SIN.
+type TipoDocumento int
+
+const (
+	DocumentoCI        TipoDocumento = 1
+	DocumentoCEX       TipoDocumento = 2
+	DocumentoPasaporte TipoDocumento = 3
+	DocumentoOtro      TipoDocumento = 4
+	DocumentoNIT       TipoDocumento = 5
+)
+
+// Documento es el documento de identidad del cliente ya clasificado.
+type Documento struct {
+	Tipo        TipoDocumento
+	Numero      string
+	Complemento string
+}
+
+// departamentos son los sufijos de expedición que se suelen cargar junto
+// al CI ("1234567 LP") y que SIN no acepta en numeroDocumento.
+var departamentos = []string{"LP", "CB", "SC", "OR", "PT", "CH", "TJ", "BN", "PD", "BE", "PA"}
+
+// ClasificarDocumento interpreta el documento tal como está en CLIENTE.Nit:
+//
+//	1234567, 1234567 LP       CI
+//	1234567-1A                CI con complemento
+//	E-1234567                 carnet de extranjero
+//	AB123456                  pasaporte
+//	1023807025                NIT (9 dígitos o más)
+//
+// Sin documento ("", "0") se usa el código de cliente como otro documento.
+func ClasificarDocumento(documento, codigoCliente string) Documento {
+	s := strings.ToUpper(strings.TrimSpace(documento))
+	s = strings.NewReplacer(" ", "", ".", "").Replace(s)
+	if strings.Trim(s, "0") == "" {
+		return Documento{Tipo: DocumentoOtro, Numero: codigoCliente}
+	}
+
+	if rest, ok := strings.CutPrefix(s, "E-"); ok && soloDigitos(rest) {
+		return Documento{Tipo: DocumentoCEX, Numero: s}
+	}
+	if s[0] == 'E' && soloDigitos(s[1:]) {
+		return Documento{Tipo: DocumentoCEX, Numero: "E-" + s[1:]}
+	}
+
+	numero, complemento, conComplemento := strings.Cut(s, "-")
+	for _, dep := range departamentos {
+		if n, ok := strings.CutSuffix(numero, dep); ok && soloDigitos(n) {
+			numero = n
+			break
+		}
+	}
+
+	if soloDigitos(numero) {
+		switch {
+		case conComplemento:
+			if len(complemento) >= 1 && len(complemento) <= 2 && alfanumerico(complemento) && len(numero) <= 8 {
+				return Documento{Tipo: DocumentoCI, Numero: numero, Complemento: complemento}
+			}
+		case len(numero) >= 9:
+			return Documento{Tipo: DocumentoNIT, Numero: numero}
+		case len(numero) >= 4:
+			return Documento{Tipo: DocumentoCI, Numero: numero}
+		}
+		return Documento{Tipo: DocumentoOtro, Numero: s}
+	}
+
+	// pasaporte: letras seguidas de dígitos
+	letras := strings.IndexFunc(s, func(r rune) bool { return r >= '0' && r <= '9' })
+	if letras > 0 && soloDigitos(s[letras:]) && len(s) >= 6 && len(s) <= 12 && alfanumerico(s[:letras]) {
+		return Documento{Tipo: DocumentoPasaporte, Numero: s}
+	}
+	return Documento{Tipo: DocumentoOtro, Numero: s}
+}
+
+func soloDigitos(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func alfanumerico(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Tablas del algoritmo de Verhoeff, que SIN usa para el dígito verificador
+// del NIT.
+var (
+	verhoeffD = [10][10]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		{1, 2, 3, 4, 0, 6, 7, 8, 9, 5},
+		{2, 3, 4, 0, 1, 7, 8, 9, 5, 6},
+		{3, 4, 0, 1, 2, 8, 9, 5, 6, 7},
+		{4, 0, 1, 2, 3, 9, 5, 6, 7, 8},
+		{5, 9, 8, 7, 6, 0, 4, 3, 2, 1},
+		{6, 5, 9, 8, 7, 1, 0, 4, 3, 2},
+		{7, 6, 5, 9, 8, 2, 1, 0, 4, 3},
+		{8, 7, 6, 5, 9, 3, 2, 1, 0, 4},
+		{9, 8, 7, 6, 5, 4, 3, 2, 1, 0},
+	}
+	verhoeffP = [8][10]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		{1, 5, 7, 6, 2, 8, 3, 0, 9, 4},
+		{5, 8, 0, 3, 7, 9, 6, 1, 4, 2},
+		{8, 9, 1, 6, 0, 4, 3, 5, 2, 7},
+		{9, 4, 5, 3, 1, 2, 7, 6, 8, 0},
+		{4, 2, 8, 6, 5, 7, 3, 9, 0, 1},
+		{2, 7, 9, 3, 8, 0, 6, 4, 1, 5},
+		{7, 0, 4, 6, 9, 1, 3, 2, 5, 8},
+	}
+)
+
+// ValidarNIT comprueba el dígito verificador (el último) del NIT.
+func ValidarNIT(nit string) bool {
+	if len(nit) < 2 || !soloDigitos(nit) {
+		return false
+	}
+	c := 0
+	for i := 0; i < len(nit); i++ {
+		digito := int(nit[len(nit)-1-i] - '0')
+		c = verhoeffD[c][verhoeffP[i%8][digito]]
+	}
+	return c == 0
+}
+
+// codigoExcepcion es 1 cuando el NIT no se puede validar y hay que pedir a
+// SIN que acepte la factura igual; los demás documentos no lo necesitan.
+func codigoExcepcion(doc Documento) int {
+	if doc.Tipo == DocumentoNIT && !ValidarNIT(doc.Numero) {
+		return 1
+	}
+	return 0
+}
+
+// VerificacionNit es la respuesta de la consulta de NIT del backend.
+type VerificacionNit struct {
+	Nit         string `json:"nit"`
+	Valido      bool   `json:"valido"`
+	Codigo      int    `json:"codigo"`
+	Descripcion string `json:"descripcion"`
+}
+
+// VerificarNit consulta a SIN, a través del backend, si el NIT existe y
+// está activo.
+func (fe *FacturacionElectronica) VerificarNit(ctx context.Context, nit string) (*VerificacionNit, error) {
+	query := url.Values{"nit": {nit}}
+	var result VerificacionNit
+	if err := fe.getJSON(ctx, "/api/v1/invoice-utils/nit?"+query.Encode(), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// VerificadorNit consulta los NIT en el backend y guarda las respuestas
+// para no repetir la consulta de un cliente con varias facturas.
+type VerificadorNit struct {
+	fe    InvoiceClient
+	ttl   time.Duration
+	mu    sync.Mutex
+	cache map[string]nitCacheado
+}
+
+type nitCacheado struct {
+	valido bool
+	hasta  time.Time
+}
+
+func NewVerificadorNit(fe InvoiceClient, ttl time.Duration) *VerificadorNit {
+	return &VerificadorNit{fe: fe, ttl: ttl, cache: map[string]nitCacheado{}}
+}
+
+// Valido indica si SIN reconoce el NIT como activo. Los errores de consulta
+// no se guardan, para volver a intentar con la siguiente factura.
+func (v *VerificadorNit) Valido(ctx context.Context, nit string) (bool, error) {
+	v.mu.Lock()
+	entrada, ok := v.cache[nit]
+	v.mu.Unlock()
+	if ok && time.Now().Before(entrada.hasta) {
+		return entrada.valido, nil
+	}
+
+	result, err := v.fe.VerificarNit(ctx, nit)
+	if err != nil {
+		return false, err
+	}
+
+	v.mu.Lock()
+	v.cache[nit] = nitCacheado{valido: result.Valido, hasta: time.Now().Add(v.ttl)}
+	v.mu.Unlock()
+	return result.Valido, nil
+}
+
+// AplicarExcepcion verifica el NIT de la solicitud y marca CodigoExcepcion
+// solo si SIN no lo reconoce o no se pudo verificar. Los documentos que no
+// son NIT no se consultan.
+func (v *VerificadorNit) AplicarExcepcion(ctx context.Context, req *FacturaRequest) error {
+	c := &req.Cabecera
+	if TipoDocumento(c.CodigoTipoDocumentoIdentidad) != DocumentoNIT || c.CodigoExcepcion == 1 {
+		return nil
+	}
+	valido, err := v.Valido(ctx, c.NumeroDocumento)
+	if err != nil {
+		c.CodigoExcepcion = 1
+		return err
+	}
+	if !valido {
+		c.CodigoExcepcion = 1
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// EmisorProfile agrupa los datos del emisor que se repiten en cada factura.
+// Se carga desde un archivo JSON y puede sobreescribirse con variables de
+// entorno (EMISOR_*) o flags de línea de comandos.
+type EmisorProfile struct {
+	Nit              int64  `json:"nit"`
+	RazonSocial      string `json:"razonSocial"`
+	Municipio        string `json:"municipio"`
+	Ciudad           string `json:"ciudad"`
+	Telefono         string `json:"telefono"`
+	Direccion        string `json:"direccion"`
+	CodigoSucursal   int    `json:"codigoSucursal"`
+	CodigoPuntoVenta int    `json:"codigoPuntoVenta"`
+	CodigoAmbiente   int    `json:"codigoAmbiente"`
+	CodigoModalidad  int    `json:"codigoModalidad"`
+	CodigoActividad  int    `json:"codigoActividad"`
+	Usuario          string `json:"usuario"`
+	Leyenda          string `json:"leyenda"`
+}
+
+// DefaultEmisorProfile devuelve el perfil de EMPSAAT usado hasta ahora.
+func DefaultEmisorProfile() EmisorProfile {
+	return EmisorProfile{
+		Nit:              1023807025,
+		RazonSocial:      "EMPSAAT",
+		Municipio:        "TUPIZA",
+		Ciudad:           "Tupiza",
+		Telefono:         "(2) 6944636",
+		Direccion:        "Calle Bolivar S/N Zona central",
+		CodigoSucursal:   0,
+		CodigoPuntoVenta: 0,
+		CodigoAmbiente:   1,
+		CodigoModalidad:  1,
+		CodigoActividad:  360000,
+		Usuario:          "Santiago",
+	}
+}
+
+// LoadEmisorProfile lee el perfil desde un archivo JSON. Los campos ausentes
+// conservan el valor de DefaultEmisorProfile.
+func LoadEmisorProfile(path string) (EmisorProfile, error) {
+	profile := DefaultEmisorProfile()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return profile, fmt.Errorf("error al leer perfil del emisor: %v", err)
+	}
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return profile, fmt.Errorf("error al interpretar perfil del emisor %s: %v", path, err)
+	}
+	return profile, nil
+}
+
+// ApplyEnv sobreescribe los campos del perfil con las variables de entorno
+// EMISOR_* que estén definidas.
+func (p *EmisorProfile) ApplyEnv() error {
+	textos := map[string]*string{
+		"EMISOR_RAZON_SOCIAL": &p.RazonSocial,
+		"EMISOR_MUNICIPIO":    &p.Municipio,
+		"EMISOR_CIUDAD":       &p.Ciudad,
+		"EMISOR_TELEFONO":     &p.Telefono,
+		"EMISOR_DIRECCION":    &p.Direccion,
+		"EMISOR_USUARIO":      &p.Usuario,
+		"EMISOR_LEYENDA":      &p.Leyenda,
+	}
+	for key, field := range textos {
+		if value, ok := os.LookupEnv(key); ok {
+			*field = value
+		}
+	}
+
+	enteros := map[string]*int{
+		"EMISOR_CODIGO_SUCURSAL":    &p.CodigoSucursal,
+		"EMISOR_CODIGO_PUNTO_VENTA": &p.CodigoPuntoVenta,
+		"EMISOR_CODIGO_AMBIENTE":    &p.CodigoAmbiente,
+		"EMISOR_CODIGO_MODALIDAD":   &p.CodigoModalidad,
+		"EMISOR_CODIGO_ACTIVIDAD":   &p.CodigoActividad,
+	}
+	for key, field := range enteros {
+		if value, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("valor inválido para %s: %v", key, err)
+			}
+			*field = n
+		}
+	}
+
+	if value, ok := os.LookupEnv("EMISOR_NIT"); ok {
+		nit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("valor inválido para EMISOR_NIT: %v", err)
+		}
+		p.Nit = nit
+	}
+	return nil
+}
+
+// NitString devuelve el NIT del emisor en el formato que espera la solicitud.
+func (p EmisorProfile) NitString() string {
+	return strconv.FormatInt(p.Nit, 10)
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrorKind clasifica la causa de un APIError.
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	// KindValidation: el backend rechazó los datos de la factura (400, 409, 422).
+	KindValidation
+	// KindAuth: api_key inválida o sin permisos (401, 403).
+	KindAuth
+	// KindServer: el backend o SIN no están disponibles (5xx).
+	KindServer
+)
+
+func (k ErrorKind) String() string {
+	return [...]string{"Unknown", "Validation", "Auth", "Server"}[k]
+}
+
+// APIError es el error devuelto cuando el backend responde con un estado
+// inesperado. Se puede inspeccionar con errors.As.
+type APIError struct {
+	StatusCode int
+	// Code es el código de error del backend (campo "error").
+	Code string
+	// Messages contiene los mensajes del backend; "message" puede venir
+	// como texto o como lista.
+	Messages []string
+	// PayloadID identifica la solicitud enviada (cabecera X-Request-Id).
+	PayloadID string
+	// Body es la respuesta sin interpretar, para diagnóstico.
+	Body string
+	// RetryAfter es la espera pedida por el backend (cabecera Retry-After).
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := strings.Join(e.Messages, "; ")
+	if msg == "" {
+		msg = e.Body
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("API error %d (%s): %s", e.StatusCode, e.Code, msg)
+	}
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, msg)
+}
+
+// Kind clasifica el error según el estado HTTP.
+func (e *APIError) Kind() ErrorKind {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return KindAuth
+	case e.StatusCode == http.StatusBadRequest ||
+		e.StatusCode == http.StatusConflict ||
+		e.StatusCode == http.StatusUnprocessableEntity:
+		return KindValidation
+	case e.StatusCode >= 500:
+		return KindServer
+	}
+	return KindUnknown
+}
+
+func (e *APIError) IsValidation() bool { return e.Kind() == KindValidation }
+func (e *APIError) IsAuth() bool       { return e.Kind() == KindAuth }
+func (e *APIError) IsServer() bool     { return e.Kind() == KindServer }
+
+// newAPIError construye un APIError a partir del cuerpo de la respuesta,
+// que normalmente tiene la forma {"statusCode", "message", "error"}.
+func newAPIError(statusCode int, body []byte, payloadID string) *APIError {
+	apiErr := &APIError{
+		StatusCode: statusCode,
+		PayloadID:  payloadID,
+		Body:       string(body),
+	}
+
+	var parsed struct {
+		Message json.RawMessage `json:"message"`
+		Error   string          `json:"error"`
+		Code    string          `json:"code"`
+		Errors  []string        `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return apiErr
+	}
+
+	apiErr.Code = parsed.Code
+	if apiErr.Code == "" {
+		apiErr.Code = parsed.Error
+	}
+
+	var single string
+	var list []string
+	if json.Unmarshal(parsed.Message, &single) == nil && single != "" {
+		apiErr.Messages = append(apiErr.Messages, single)
+	} else if json.Unmarshal(parsed.Message, &list) == nil {
+		apiErr.Messages = append(apiErr.Messages, list...)
+	}
+	apiErr.Messages = append(apiErr.Messages, parsed.Errors...)
+
+	return apiErr
+}
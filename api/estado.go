@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// EstadoFactura es el estado de una factura en el backend/SIN.
+type EstadoFactura string
+
+const (
+	EstadoValidada  EstadoFactura = "VALIDADA"
+	EstadoObservada EstadoFactura = "OBSERVADA"
+	EstadoRechazada EstadoFactura = "RECHAZADA"
+	EstadoPendiente EstadoFactura = "PENDIENTE"
+	EstadoAnulada   EstadoFactura = "ANULADA"
+)
+
+// EstadoFacturaResponse es la respuesta de invoice-utils/status.
+type EstadoFacturaResponse struct {
+	Cuf           string `json:"cuf"`
+	NumeroFactura int    `json:"numeroFactura"`
+	// RequestID es el X-Request-Id con que se creó la factura, si se
+	// consultó por requestId.
+	RequestID     string        `json:"requestId,omitempty"`
+	CodigoCliente string        `json:"codigoCliente"`
+	Estado        EstadoFactura `json:"estado"`
+	Fecha         string        `json:"fecha"`
+	Observaciones []string      `json:"observaciones"`
+}
+
+// ConsultarEstado devuelve el estado de la factura identificada por cuf.
+func (fe *FacturacionElectronica) ConsultarEstado(ctx context.Context, cuf string) (*EstadoFacturaResponse, error) {
+	query := url.Values{"cuf": {cuf}}
+	var result EstadoFacturaResponse
+	if err := fe.getJSON(ctx, "/api/v1/invoice-utils/status?"+query.Encode(), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// BusquedaFactura identifica una factura enviada cuya respuesta se perdió:
+// por el X-Request-Id del envío o, si lo tiene, por su número dentro de la
+// sucursal, el punto de venta y el documento sector.
+type BusquedaFactura struct {
+	RequestID             string
+	NumeroFactura         int
+	CodigoSucursal        int
+	CodigoPuntoVenta      int
+	CodigoDocumentoSector int
+	// CodigoCliente descarta una factura con el mismo número de otro
+	// cliente, que es un conflicto real.
+	CodigoCliente string
+}
+
+// buscarRegistrada consulta invoice-utils/status por X-Request-Id y luego
+// por número. Devuelve un *APIError 404 si el backend no tiene la factura.
+//
+// La consulta sin cuf es la que implementa mockapi: requestId, o
+// numeroFactura con la sucursal, el punto de venta y el documento sector.
+// Una respuesta que no repite el requestId o el número consultado se toma
+// como un backend que ignora esos filtros y devuelve un error que no es
+// 404, para que crear no la acepte ni reenvíe la factura.
+func (fe *FacturacionElectronica) buscarRegistrada(ctx context.Context, b BusquedaFactura) (*EstadoFacturaResponse, error) {
+	consultas := []url.Values{{"requestId": {b.RequestID}}}
+	if b.NumeroFactura > 0 {
+		consultas = append(consultas, url.Values{
+			"numeroFactura":         {strconv.Itoa(b.NumeroFactura)},
+			"codigoSucursal":        {strconv.Itoa(b.CodigoSucursal)},
+			"codigoPuntoVenta":      {strconv.Itoa(b.CodigoPuntoVenta)},
+			"codigoDocumentoSector": {strconv.Itoa(b.CodigoDocumentoSector)},
+		})
+	}
+
+	var lastErr error
+	for _, query := range consultas {
+		var result EstadoFacturaResponse
+		err := fe.getJSON(ctx, "/api/v1/invoice-utils/status?"+query.Encode(), &result)
+		if err != nil {
+			lastErr = err
+			if esNoEncontrada(err) {
+				continue
+			}
+			return nil, err
+		}
+		if query.Has("requestId") && result.RequestID != b.RequestID {
+			return nil, fmt.Errorf("invoice-utils/status devolvió la solicitud %q al consultar %q", result.RequestID, b.RequestID)
+		}
+		if query.Has("numeroFactura") && result.NumeroFactura != b.NumeroFactura {
+			return nil, fmt.Errorf("invoice-utils/status devolvió la factura %d al consultar la %d", result.NumeroFactura, b.NumeroFactura)
+		}
+		if result.Cuf == "" {
+			return nil, fmt.Errorf("invoice-utils/status devolvió la factura %d sin cuf", result.NumeroFactura)
+		}
+		if b.CodigoCliente != "" && result.CodigoCliente != "" && result.CodigoCliente != b.CodigoCliente {
+			lastErr = &APIError{StatusCode: http.StatusNotFound, Messages: []string{
+				fmt.Sprintf("la factura %d registrada es del cliente %s", result.NumeroFactura, result.CodigoCliente)}}
+			continue
+		}
+		return &result, nil
+	}
+	return nil, lastErr
+}
+
+func esNoEncontrada(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
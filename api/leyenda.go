@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Leyenda es una entrada del catálogo "LEYENDAS FACTURA" de SIN.
+type Leyenda struct {
+	CodigoActividad    int    `json:"codigoActividad"`
+	DescripcionLeyenda string `json:"descripcionLeyenda"`
+}
+
+// leyendasPorDefecto son las leyendas de la actividad 360000 según el
+// catálogo de SIN, para cuando todavía no se sincronizó el archivo.
+//
+//go:embed leyendas.json
+var leyendasPorDefecto []byte
+
+// CatalogoLeyendas elige al azar una leyenda de la actividad de cada
+// factura, como exige SIN.
+type CatalogoLeyendas struct {
+	mu           sync.Mutex
+	rand         *rand.Rand
+	leyendas     []Leyenda
+	porActividad map[int][]string
+}
+
+func NewCatalogoLeyendas(leyendas []Leyenda) *CatalogoLeyendas {
+	c := &CatalogoLeyendas{
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		leyendas:     leyendas,
+		porActividad: map[int][]string{},
+	}
+	for _, l := range leyendas {
+		if l.DescripcionLeyenda != "" {
+			c.porActividad[l.CodigoActividad] = append(c.porActividad[l.CodigoActividad], l.DescripcionLeyenda)
+		}
+	}
+	return c
+}
+
+// DefaultCatalogoLeyendas devuelve el catálogo incluido en el programa.
+func DefaultCatalogoLeyendas() *CatalogoLeyendas {
+	var leyendas []Leyenda
+	if err := json.Unmarshal(leyendasPorDefecto, &leyendas); err != nil {
+		panic(fmt.Sprintf("leyendas.json inválido: %v", err))
+	}
+	return NewCatalogoLeyendas(leyendas)
+}
+
+// LoadCatalogoLeyendas lee un catálogo guardado con Save.
+func LoadCatalogoLeyendas(path string) (*CatalogoLeyendas, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var leyendas []Leyenda
+	if err := json.Unmarshal(data, &leyendas); err != nil {
+		return nil, fmt.Errorf("error al leer el catálogo de leyendas %s: %v", path, err)
+	}
+	return NewCatalogoLeyendas(leyendas), nil
+}
+
+// Save guarda el catálogo como JSON.
+func (c *CatalogoLeyendas) Save(path string) error {
+	data, err := json.MarshalIndent(c.leyendas, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Leyendas devuelve todas las entradas del catálogo.
+func (c *CatalogoLeyendas) Leyendas() []Leyenda {
+	return append([]Leyenda(nil), c.leyendas...)
+}
+
+// Cantidad devuelve cuántas leyendas hay para la actividad.
+func (c *CatalogoLeyendas) Cantidad(actividad int) int {
+	return len(c.porActividad[actividad])
+}
+
+// Elegir devuelve una leyenda al azar de la actividad.
+func (c *CatalogoLeyendas) Elegir(actividad int) (string, error) {
+	opciones := c.porActividad[actividad]
+	if len(opciones) == 0 {
+		return "", fmt.Errorf("no hay leyendas para la actividad %d; sincronice el catálogo", actividad)
+	}
+	c.mu.Lock()
+	i := c.rand.Intn(len(opciones))
+	c.mu.Unlock()
+	return opciones[i], nil
+}
+
+// ListarLeyendas descarga el catálogo de leyendas de la actividad desde el
+// backend.
+func (fe *FacturacionElectronica) ListarLeyendas(ctx context.Context, actividad int) ([]Leyenda, error) {
+	query := url.Values{"codigoActividad": {strconv.Itoa(actividad)}}
+	var result []Leyenda
+	if err := fe.getJSON(ctx, "/api/v1/invoice-utils/leyendas?"+query.Encode(), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"app/money"
+	"context"
+	"fmt"
+	"time"
+)
+
+// SectorNotaCreditoDebito es el documento sector de la nota de
+// crédito-débito.
+const SectorNotaCreditoDebito = 24
+
+// Códigos de detalle de transacción de la nota: la línea de la factura
+// original y la línea devuelta.
+const (
+	TransaccionOriginal   = 1
+	TransaccionDevolucion = 2
+)
+
+// porcentajeCreditoFiscal es la alícuota del IVA que se aplica al monto
+// devuelto para obtener montoEfectivoCreditoDebito.
+const porcentajeCreditoFiscal = 13
+
+// NotaCreditoDebitoRequest es la solicitud de sector 24.
+type NotaCreditoDebitoRequest struct {
+	Solicitud SolicitudModel     `json:"solicitud"`
+	Cabecera  CabeceraNotaModel  `json:"cabecera"`
+	Detalle   []DetalleNotaModel `json:"detalle"`
+	ExtraInfo []ExtraInfoModel   `json:"extraInfo"`
+}
+
+type CabeceraNotaModel struct {
+	NitEmisor                    int64                 `json:"nitEmisor"`
+	RazonSocialEmisor            string                `json:"razonSocialEmisor"`
+	Municipio                    string                `json:"municipio"`
+	Telefono                     string                `json:"telefono"`
+	NumeroNotaCreditoDebito      int                   `json:"numeroNotaCreditoDebito"`
+	Cuf                          string                `json:"cuf"`
+	Cufd                         string                `json:"cufd"`
+	CodigoSucursal               int                   `json:"codigoSucursal"`
+	Direccion                    string                `json:"direccion"`
+	CodigoPuntoVenta             int                   `json:"codigoPuntoVenta"`
+	FechaEmision                 string                `json:"fechaEmision"`
+	NombreRazonSocial            string                `json:"nombreRazonSocial"`
+	CodigoTipoDocumentoIdentidad int                   `json:"codigoTipoDocumentoIdentidad"`
+	NumeroDocumento              string                `json:"numeroDocumento"`
+	Complemento                  string                `json:"complemento"`
+	CodigoCliente                string                `json:"codigoCliente"`
+	NumeroFactura                int                   `json:"numeroFactura"`
+	NumeroAutorizacionCuf        string                `json:"numeroAutorizacionCuf"`
+	FechaEmisionFactura          string                `json:"fechaEmisionFactura"`
+	MontoTotalOriginal           money.Money           `json:"montoTotalOriginal"`
+	MontoTotalDevuelto           money.Money           `json:"montoTotalDevuelto"`
+	MontoDescuentoCreditoDebito  money.Money           `json:"montoDescuentoCreditoDebito"`
+	MontoEfectivoCreditoDebito   money.Money           `json:"montoEfectivoCreditoDebito"`
+	CodigoExcepcion              int                   `json:"codigoExcepcion"`
+	Leyenda                      string                `json:"leyenda"`
+	Usuario                      string                `json:"usuario"`
+	CodigoDocumentoSector        int                   `json:"codigoDocumentoSector"`
+	CamposAdicionales            []CampoAdicionalModel `json:"camposAdicionales"`
+}
+
+type DetalleNotaModel struct {
+	ActividadEconomica       int         `json:"actividadEconomica"`
+	CodigoProductoSin        int         `json:"codigoProductoSin"`
+	CodigoProducto           string      `json:"codigoProducto"`
+	Descripcion              string      `json:"descripcion"`
+	Cantidad                 int         `json:"cantidad"`
+	UnidadMedida             int         `json:"unidadMedida"`
+	PrecioUnitario           money.Money `json:"precioUnitario"`
+	MontoDescuento           money.Money `json:"montoDescuento"`
+	SubTotal                 money.Money `json:"subTotal"`
+	CodigoDetalleTransaccion int         `json:"codigoDetalleTransaccion"`
+}
+
+// NotaCreditoDebito emite una nota que devuelve montoDevuelto de la factura
+// original, identificada por su cuf, número y fecha de emisión.
+func (fe *FacturacionElectronica) NotaCreditoDebito(
+	ctx context.Context,
+	original FacturaRequest,
+	cuf, fechaEmisionFactura string,
+	montoDevuelto money.Money,
+	numero int,
+) (*FacturaResponse, error) {
+	notaRequest, err := fe.BuildNotaCreditoDebito(original, cuf, fechaEmisionFactura, montoDevuelto, numero)
+	if err != nil {
+		return nil, err
+	}
+	return fe.EnviarNotaCreditoDebito(ctx, notaRequest)
+}
+
+// BuildNotaCreditoDebito arma la solicitud de sector 24 a partir de la
+// solicitud de la factura original, leída de su XML con LeerFacturaXML
+// para usar los montos emitidos. Cada línea original se repite con
+// codigoDetalleTransaccion 1 y el monto devuelto va en una línea con 2.
+func (fe *FacturacionElectronica) BuildNotaCreditoDebito(
+	original FacturaRequest,
+	cuf, fechaEmisionFactura string,
+	montoDevuelto money.Money,
+	numero int,
+) (NotaCreditoDebitoRequest, error) {
+	if cuf == "" {
+		return NotaCreditoDebitoRequest{}, fmt.Errorf("la factura original no tiene cuf")
+	}
+	if len(original.Detalle) == 0 {
+		return NotaCreditoDebitoRequest{}, fmt.Errorf("la factura original no tiene detalle")
+	}
+	if montoDevuelto <= 0 || montoDevuelto > original.Cabecera.MontoTotal {
+		return NotaCreditoDebitoRequest{}, fmt.Errorf("el monto devuelto %s debe ser mayor a cero y no superar el total original %s", montoDevuelto, original.Cabecera.MontoTotal)
+	}
+
+	leyenda, err := fe.elegirLeyenda()
+	if err != nil {
+		return NotaCreditoDebitoRequest{}, err
+	}
+
+	fechaHora := time.Now().Format("2006-01-02T15:04:05.000")
+
+	solicitud := original.Solicitud
+	solicitud.CodigoEmision = EmisionEnLinea
+	solicitud.CodigoDocumentoSector = SectorNotaCreditoDebito
+	solicitud.CodigoTipoEvento = 0
+	solicitud.FechaEmision = fechaHora
+	solicitud.NumeroFactura = numero
+	solicitud.Leyenda = leyenda
+
+	factura := original.Cabecera
+	cabecera := CabeceraNotaModel{
+		NitEmisor:                    fe.emisor.Nit,
+		RazonSocialEmisor:            fe.emisor.RazonSocial,
+		Municipio:                    fe.emisor.Municipio,
+		Telefono:                     fe.emisor.Telefono,
+		NumeroNotaCreditoDebito:      numero,
+		CodigoSucursal:               fe.emisor.CodigoSucursal,
+		Direccion:                    fe.emisor.Direccion,
+		CodigoPuntoVenta:             fe.emisor.CodigoPuntoVenta,
+		FechaEmision:                 fechaHora,
+		NombreRazonSocial:            factura.NombreRazonSocial,
+		CodigoTipoDocumentoIdentidad: factura.CodigoTipoDocumentoIdentidad,
+		NumeroDocumento:              factura.NumeroDocumento,
+		Complemento:                  factura.Complemento,
+		CodigoCliente:                factura.CodigoCliente,
+		NumeroFactura:                factura.NumeroFactura,
+		NumeroAutorizacionCuf:        cuf,
+		FechaEmisionFactura:          fechaEmisionFactura,
+		MontoTotalOriginal:           factura.MontoTotal,
+		MontoTotalDevuelto:           montoDevuelto,
+		MontoDescuentoCreditoDebito:  0,
+		MontoEfectivoCreditoDebito:   montoDevuelto.Porcentaje(porcentajeCreditoFiscal),
+		CodigoExcepcion:              factura.CodigoExcepcion,
+		Leyenda:                      leyenda,
+		Usuario:                      fe.emisor.Usuario,
+		CodigoDocumentoSector:        SectorNotaCreditoDebito,
+		CamposAdicionales:            []CampoAdicionalModel{},
+	}
+
+	detalle := []DetalleNotaModel{}
+	for _, item := range original.Detalle {
+		detalle = append(detalle, detalleNota(item, TransaccionOriginal))
+	}
+	devolucion := detalleNota(original.Detalle[0], TransaccionDevolucion)
+	devolucion.Descripcion = "DEVOLUCION " + devolucion.Descripcion
+	devolucion.Cantidad = 1
+	devolucion.PrecioUnitario = montoDevuelto
+	devolucion.MontoDescuento = 0
+	devolucion.SubTotal = montoDevuelto
+	detalle = append(detalle, devolucion)
+
+	return NotaCreditoDebitoRequest{
+		Solicitud: solicitud,
+		Cabecera:  cabecera,
+		Detalle:   detalle,
+		ExtraInfo: []ExtraInfoModel{},
+	}, nil
+}
+
+// EnviarNotaCreditoDebito envía una nota armada con BuildNotaCreditoDebito.
+func (fe *FacturacionElectronica) EnviarNotaCreditoDebito(ctx context.Context, notaRequest NotaCreditoDebitoRequest) (*FacturaResponse, error) {
+	c := notaRequest.Cabecera
+	busqueda := BusquedaFactura{CodigoCliente: c.CodigoCliente}
+	// sin número lo asigna el backend y solo se busca por X-Request-Id
+	if c.NumeroNotaCreditoDebito > 0 {
+		busqueda.NumeroFactura = c.NumeroNotaCreditoDebito
+		busqueda.CodigoSucursal = c.CodigoSucursal
+		busqueda.CodigoPuntoVenta = c.CodigoPuntoVenta
+		busqueda.CodigoDocumentoSector = c.CodigoDocumentoSector
+	}
+	return fe.crear(ctx, notaRequest, busqueda)
+}
+
+func detalleNota(item DetalleModel, transaccion int) DetalleNotaModel {
+	return DetalleNotaModel{
+		ActividadEconomica:       item.ActividadEconomica,
+		CodigoProductoSin:        item.CodigoProductoSin,
+		CodigoProducto:           item.CodigoProducto,
+		Descripcion:              item.Descripcion,
+		Cantidad:                 item.Cantidad,
+		UnidadMedida:             item.UnidadMedida,
+		PrecioUnitario:           item.PrecioUnitario,
+		MontoDescuento:           item.MontoDescuento,
+		SubTotal:                 item.SubTotal,
+		CodigoDetalleTransaccion: transaccion,
+	}
+}
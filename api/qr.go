@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"net/url"
+
+	"rsc.io/qr"
+)
+
+// urlConsultaQR es la consulta pública de facturas de SIN.
+const urlConsultaQR = "https://siat.impuestos.gob.bo/consulta/QR"
+
+// tamanoQR es el parámetro t de la consulta: 1 para rollo y 2 para carta.
+func tamanoQR(formato FormatoPdf) int {
+	if formato == FormatoRollo {
+		return 1
+	}
+	return 2
+}
+
+// URLVerificacion es la dirección de la consulta de SIN que lleva el QR de
+// la factura: NIT del emisor, CUF, número y tamaño de la representación
+// gráfica.
+func URLVerificacion(cabecera CabeceraModel, cuf string, formato FormatoPdf) string {
+	return fmt.Sprintf("%s?nit=%d&cuf=%s&numero=%d&t=%d", urlConsultaQR,
+		cabecera.NitEmisor, url.QueryEscape(cuf), cabecera.NumeroFactura, tamanoQR(formato))
+}
+
+// CodigoQR es el QR de verificación de una factura.
+type CodigoQR struct {
+	URL    string
+	codigo *qr.Code
+}
+
+// GenerarQR arma el QR de verificación de la factura con su cuf.
+func GenerarQR(cabecera CabeceraModel, cuf string, formato FormatoPdf) (*CodigoQR, error) {
+	if cuf == "" {
+		return nil, fmt.Errorf("la factura no tiene cuf para el QR")
+	}
+	if cabecera.NitEmisor == 0 || cabecera.NumeroFactura == 0 {
+		return nil, fmt.Errorf("el QR necesita el NIT del emisor y el número de factura")
+	}
+	direccion := URLVerificacion(cabecera, cuf, formato)
+	codigo, err := qr.Encode(direccion, qr.M)
+	if err != nil {
+		return nil, fmt.Errorf("error al generar el QR: %v", err)
+	}
+	return &CodigoQR{URL: direccion, codigo: codigo}, nil
+}
+
+// Modulos es la cantidad de módulos por lado, sin el margen.
+func (q *CodigoQR) Modulos() int {
+	return q.codigo.Size
+}
+
+// Negro indica si el módulo x, y es oscuro.
+func (q *CodigoQR) Negro(x, y int) bool {
+	return q.codigo.Black(x, y)
+}
+
+// Image devuelve el QR con un margen de 4 módulos y escala píxeles por
+// módulo.
+func (q *CodigoQR) Image(escala int) image.Image {
+	c := *q.codigo
+	c.Scale = max(escala, 1)
+	return c.Image()
+}
+
+// PNG es el QR como imagen PNG, con escala píxeles por módulo.
+func (q *CodigoQR) PNG(escala int) []byte {
+	c := *q.codigo
+	c.Scale = max(escala, 1)
+	return c.PNG()
+}
+
+// SVG es el QR como imagen vectorial de lado módulos más el margen; el
+// tamaño final lo fija quien la incluye.
+func (q *CodigoQR) SVG() []byte {
+	lado := q.codigo.Size + 8
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n", lado, lado)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", lado, lado)
+	b.WriteString(`<path fill="#000" d="`)
+	for y := 0; y < q.codigo.Size; y++ {
+		for x := 0; x < q.codigo.Size; x++ {
+			if !q.codigo.Black(x, y) {
+				continue
+			}
+			// une los módulos oscuros consecutivos de la fila
+			inicio := x
+			for x+1 < q.codigo.Size && q.codigo.Black(x+1, y) {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", inicio+4, y+4, x-inicio+1, x-inicio+1)
+		}
+	}
+	b.WriteString("\"/>\n</svg>\n")
+	return b.Bytes()
+}
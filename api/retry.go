@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controla los reintentos de las llamadas a la API.
+type RetryPolicy struct {
+	// MaxAttempts es el total de intentos, incluido el primero.
+	MaxAttempts int
+	// BaseDelay es la espera antes del primer reintento; se duplica en
+	// cada intento hasta MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+	}
+}
+
+// backoff devuelve la espera antes del intento attempt (1 es el primer
+// reintento), con jitter entre la mitad y el total del valor exponencial.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if attempt < 32 {
+		delay = p.BaseDelay << (attempt - 1)
+	}
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// IsRetryable indica si vale la pena reintentar una llamada que falló con
+// err: errores de red, 429 y 5xx se reintentan; los rechazos 4xx no.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// IsOutage indica que err se debe a que el backend o SIN no están
+// disponibles (red caída o 5xx), el caso en que corresponde emitir en
+// contingencia.
+func IsOutage(err error) bool {
+	if !IsRetryable(err) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.IsServer()
+	}
+	return true
+}
+
+// PudoRegistrarse indica si una solicitud que falló con err pudo haber
+// llegado al backend y haberse procesado: timeouts, conexiones cortadas
+// después de enviar, 5xx y 409. Las conexiones rechazadas, los 429 y los
+// demás 4xx no se procesaron.
+func PudoRegistrarse(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusConflict || apiErr.StatusCode >= 500
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return false
+	}
+	return IsRetryable(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// ErrorEnvioIncierto indica que un POST no idempotente falló de una forma
+// en que pudo quedar registrado sin que se pudiera confirmar. En
+// third-party-create la consulta posterior tampoco lo encontró ni descartó;
+// eventos, paquetes y anulaciones no se consultan y Consulta queda en nil.
+// No es una caída: la factura no se debe emitir en contingencia ni
+// reenviar con otro número hasta saber qué pasó.
+type ErrorEnvioIncierto struct {
+	// Err es la falla del envío y Consulta la de la búsqueda posterior.
+	Err      error
+	Consulta error
+}
+
+func (e *ErrorEnvioIncierto) Error() string {
+	if e.Consulta == nil {
+		return fmt.Sprintf("no se sabe si la solicitud quedó registrada: %v", e.Err)
+	}
+	return fmt.Sprintf("no se sabe si la factura quedó registrada: %v (al consultarla: %v)", e.Err, e.Consulta)
+}
+
+// IsIncierto indica si err es un envío que pudo quedar registrado sin
+// confirmación.
+func IsIncierto(err error) bool {
+	var incierto *ErrorEnvioIncierto
+	return errors.As(err, &incierto)
+}
+
+// parseRetryAfter interpreta la cabecera Retry-After, en segundos o como
+// fecha HTTP.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// politica devuelve la política de reintentos configurada o la de
+// omisión.
+func (fe *FacturacionElectronica) politica() RetryPolicy {
+	if fe.retry.MaxAttempts <= 0 {
+		return DefaultRetryPolicy()
+	}
+	return fe.retry
+}
+
+// esperar aguarda antes del intento attempt, respetando el Retry-After de
+// lastErr si pide más tiempo.
+func (p RetryPolicy) esperar(ctx context.Context, attempt int, lastErr error) error {
+	delay := p.backoff(attempt - 1)
+	var apiErr *APIError
+	if errors.As(lastErr, &apiErr) && apiErr.RetryAfter > delay {
+		delay = apiErr.RetryAfter
+	}
+	log.Printf("Reintentando (%d/%d) en %v: %v", attempt, p.MaxAttempts, delay, lastErr)
+	timer := time.NewTimer(delay)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// doWithRetry ejecuta la solicitud que construye newRequest hasta que el
+// backend responde con el estado esperado, se agota la política o el error
+// es permanente. Devuelve el cuerpo completo de la respuesta exitosa.
+//
+// Cada reintento repite la solicitud completa, así que solo se usa para
+// consultas y para operaciones cuyo duplicado el backend rechaza sin
+// efecto. third-party-create y los POST de postJSON no la usan: ver crear.
+func (fe *FacturacionElectronica) doWithRetry(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error), expected int) ([]byte, error) {
+	policy := fe.politica()
+
+	var lastErr error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			if err := policy.esperar(ctx, attempt, lastErr); err != nil {
+				return nil, err
+			}
+		}
+
+		body, err := fe.doOnce(ctx, newRequest, expected)
+		if err == nil {
+			return body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+		if !IsRetryable(err) {
+			return nil, err
+		}
+	}
+	return nil, lastErr
+}
+
+// doOnce hace un intento, limitado por el timeout por solicitud si está
+// configurado.
+func (fe *FacturacionElectronica) doOnce(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error), expected int) ([]byte, error) {
+	if fe.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, fe.requestTimeout)
+		defer cancel()
+	}
+
+	req, err := newRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	solicitud := requestBody(req)
+
+	inicio := time.Now()
+	resp, err := fe.client.Do(req)
+	if err != nil {
+		fe.auditar(ctx, req, solicitud, inicio, 0, "", nil, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	contentType := resp.Header.Get("Content-Type")
+	if err != nil {
+		fe.auditar(ctx, req, solicitud, inicio, resp.StatusCode, contentType, body, err)
+		return nil, err
+	}
+
+	if resp.StatusCode != expected {
+		apiErr := newAPIError(resp.StatusCode, body, req.Header.Get("X-Request-Id"))
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		fe.auditar(ctx, req, solicitud, inicio, resp.StatusCode, contentType, body, apiErr)
+		return nil, apiErr
+	}
+	fe.auditar(ctx, req, solicitud, inicio, resp.StatusCode, contentType, body, nil)
+	return body, nil
+}
+
+// requestBody copia el cuerpo de la solicitud para la auditoría sin
+// consumir el original.
+func requestBody(req *http.Request) []byte {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	return data
+}
@@ -0,0 +1,395 @@
+package api
+
+import (
+	"app/money"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// escritorXML arma el XML de SIN campo por campo, en el orden del XSD.
+type escritorXML struct {
+	buf   bytes.Buffer
+	nivel int
+}
+
+func (e *escritorXML) sangria() {
+	for i := 0; i < e.nivel; i++ {
+		e.buf.WriteString("    ")
+	}
+}
+
+func (e *escritorXML) abrir(nombre string, atributos ...string) {
+	e.sangria()
+	e.buf.WriteString("<" + nombre)
+	for i := 0; i+1 < len(atributos); i += 2 {
+		e.buf.WriteString(" " + atributos[i] + `="`)
+		xml.EscapeText(&e.buf, []byte(atributos[i+1]))
+		e.buf.WriteString(`"`)
+	}
+	e.buf.WriteString(">\n")
+	e.nivel++
+}
+
+func (e *escritorXML) cerrar(nombre string) {
+	e.nivel--
+	e.sangria()
+	e.buf.WriteString("</" + nombre + ">\n")
+}
+
+func (e *escritorXML) campo(nombre, valor string) {
+	e.sangria()
+	e.buf.WriteString("<" + nombre + ">")
+	xml.EscapeText(&e.buf, []byte(valor))
+	e.buf.WriteString("</" + nombre + ">\n")
+}
+
+// opcional escribe el campo o, si está vacío, lo marca como nulo.
+func (e *escritorXML) opcional(nombre, valor string) {
+	if valor == "" {
+		e.sangria()
+		e.buf.WriteString("<" + nombre + ` xsi:nil="true"/>` + "\n")
+		return
+	}
+	e.campo(nombre, valor)
+}
+
+func entero(n int) string {
+	return strconv.Itoa(n)
+}
+
+// enteroOpcional trata el cero como campo nulo.
+func enteroOpcional(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// GenerarXML arma el XML de SIN de una factura de servicios básicos (sector
+// 13) o de compra venta (sector 1). Los campos del sector 13 que no están
+// en la cabecera se toman de los campos adicionales.
+func GenerarXML(req FacturaRequest) ([]byte, error) {
+	var raiz string
+	switch req.Cabecera.CodigoDocumentoSector {
+	case SectorServiciosBasicos:
+		raiz = "facturaElectronicaServicioBasico"
+	case SectorCompraVenta:
+		raiz = "facturaElectronicaCompraVenta"
+	default:
+		return nil, fmt.Errorf("no se puede generar el XML del documento sector %d", req.Cabecera.CodigoDocumentoSector)
+	}
+
+	c := req.Cabecera
+	adicional := func(clave string) string {
+		for _, campo := range c.CamposAdicionales {
+			if campo.Clave == clave {
+				return campo.Valor
+			}
+		}
+		return ""
+	}
+	servicios := c.CodigoDocumentoSector == SectorServiciosBasicos
+
+	e := &escritorXML{}
+	e.buf.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	e.abrir(raiz, "xmlns:xsi", xsiNamespace, "xsi:noNamespaceSchemaLocation", raiz+".xsd")
+
+	e.abrir("cabecera")
+	e.campo("nitEmisor", strconv.FormatInt(c.NitEmisor, 10))
+	e.campo("razonSocialEmisor", c.RazonSocialEmisor)
+	e.campo("municipio", c.Municipio)
+	e.opcional("telefono", c.Telefono)
+	e.campo("numeroFactura", entero(c.NumeroFactura))
+	e.campo("cuf", c.Cuf)
+	e.campo("cufd", c.Cufd)
+	e.campo("codigoSucursal", entero(c.CodigoSucursal))
+	e.campo("direccion", c.Direccion)
+	e.opcional("codigoPuntoVenta", entero(c.CodigoPuntoVenta))
+	if servicios {
+		e.opcional("mes", adicional("mes"))
+		e.opcional("gestion", adicional("gestion"))
+		e.opcional("ciudad", adicional("ciudad"))
+		e.opcional("zona", adicional("zona"))
+		e.campo("numeroMedidor", adicional("numeroMedidor"))
+	}
+	e.campo("fechaEmision", c.FechaEmision)
+	e.opcional("nombreRazonSocial", c.NombreRazonSocial)
+	if servicios {
+		e.opcional("domicilioCliente", adicional("domicilioCliente"))
+	}
+	e.campo("codigoTipoDocumentoIdentidad", entero(c.CodigoTipoDocumentoIdentidad))
+	e.campo("numeroDocumento", c.NumeroDocumento)
+	e.opcional("complemento", c.Complemento)
+	e.campo("codigoCliente", c.CodigoCliente)
+	e.campo("codigoMetodoPago", entero(c.CodigoMetodoPago))
+	e.opcional("numeroTarjeta", enteroOpcional(c.NumeroTarjeta))
+	e.campo("montoTotal", c.MontoTotal.String())
+	e.campo("montoTotalSujetoIva", c.MontoTotalSujetoIva.String())
+	if servicios {
+		for _, clave := range []string{
+			"consumoPeriodo", "beneficiarioLey1886", "montoDescuentoLey1886",
+			"montoDescuentoTarifaDignidad", "tasaAseo", "tasaAlumbrado",
+			"ajusteNoSujetoIva", "detalleAjusteNoSujetoIva",
+			"ajusteSujetoIva", "detalleAjusteSujetoIva",
+			"otrosPagosNoSujetoIva", "detalleOtrosPagosNoSujetoIva", "otrasTasas",
+		} {
+			e.opcional(clave, adicional(clave))
+		}
+	}
+	e.campo("codigoMoneda", entero(c.CodigoMoneda))
+	e.campo("tipoCambio", strconv.FormatFloat(c.TipoCambio, 'f', -1, 64))
+	e.campo("montoTotalMoneda", c.MontoTotalMoneda.String())
+	if !servicios {
+		e.opcional("montoGiftCard", montoOpcional(c.MontoGiftCard.String()))
+	}
+	e.opcional("descuentoAdicional", montoOpcional(c.DescuentoAdicional.String()))
+	e.opcional("codigoExcepcion", enteroOpcional(c.CodigoExcepcion))
+	e.opcional("cafc", c.Cafc)
+	e.campo("leyenda", c.Leyenda)
+	e.campo("usuario", c.Usuario)
+	e.campo("codigoDocumentoSector", entero(c.CodigoDocumentoSector))
+	e.cerrar("cabecera")
+
+	for _, d := range req.Detalle {
+		e.abrir("detalle")
+		e.campo("actividadEconomica", entero(d.ActividadEconomica))
+		e.campo("codigoProductoSin", entero(d.CodigoProductoSin))
+		e.campo("codigoProducto", d.CodigoProducto)
+		e.campo("descripcion", d.Descripcion)
+		e.campo("cantidad", entero(d.Cantidad))
+		e.campo("unidadMedida", entero(d.UnidadMedida))
+		e.campo("precioUnitario", d.PrecioUnitario.String())
+		e.opcional("montoDescuento", montoOpcional(d.MontoDescuento.String()))
+		e.campo("subTotal", d.SubTotal.String())
+		if !servicios {
+			e.opcional("numeroSerie", "")
+			e.opcional("numeroImei", "")
+		}
+		e.cerrar("detalle")
+	}
+
+	e.cerrar(raiz)
+	return e.buf.Bytes(), nil
+}
+
+// montoOpcional trata el monto cero como campo nulo.
+func montoOpcional(monto string) string {
+	if monto == "0.00" {
+		return ""
+	}
+	return monto
+}
+
+// ValidarFacturaXML genera el XML de la factura y lo valida contra el XSD.
+// Antes del envío la factura todavía no tiene cuf ni cufd, y puede no tener
+// número; los asigna el backend, así que esos campos vacíos no se informan
+// como error.
+func ValidarFacturaXML(req FacturaRequest) error {
+	doc, err := GenerarXML(req)
+	if err != nil {
+		return err
+	}
+	err = ValidarXML(doc)
+	esqErr, ok := err.(*ErrorEsquema)
+	if !ok {
+		return err
+	}
+
+	asignados := map[string]bool{
+		"cabecera/cuf":           req.Cabecera.Cuf == "",
+		"cabecera/cufd":          req.Cabecera.Cufd == "",
+		"cabecera/numeroFactura": req.Cabecera.NumeroFactura == 0,
+	}
+	errores := esqErr.Errores[:0]
+	for _, campo := range esqErr.Errores {
+		if !asignados[campo.Campo] {
+			errores = append(errores, campo)
+		}
+	}
+	if len(errores) == 0 {
+		return nil
+	}
+	esqErr.Errores = errores
+	return esqErr
+}
+
+// camposAdicionalesXML son los campos del sector 13 que GenerarXML toma de
+// los campos adicionales, en el orden del XSD.
+var camposAdicionalesXML = []string{
+	"mes", "gestion", "ciudad", "zona", "numeroMedidor", "domicilioCliente",
+	"consumoPeriodo", "beneficiarioLey1886", "montoDescuentoLey1886",
+	"montoDescuentoTarifaDignidad", "tasaAseo", "tasaAlumbrado",
+	"ajusteNoSujetoIva", "detalleAjusteNoSujetoIva",
+	"ajusteSujetoIva", "detalleAjusteSujetoIva",
+	"otrosPagosNoSujetoIva", "detalleOtrosPagosNoSujetoIva", "otrasTasas",
+}
+
+// lectorXML lee los campos de un elemento del XML y guarda el primer valor
+// que no se pudo convertir.
+type lectorXML struct {
+	campos map[string]string
+	err    error
+}
+
+func nuevoLectorXML(n *nodoXML) *lectorXML {
+	l := &lectorXML{campos: map[string]string{}}
+	for _, hijo := range n.hijos {
+		if !hijo.nulo {
+			l.campos[hijo.nombre] = strings.TrimSpace(hijo.texto)
+		}
+	}
+	return l
+}
+
+func (l *lectorXML) texto(nombre string) string {
+	return l.campos[nombre]
+}
+
+func (l *lectorXML) numero(nombre string) int64 {
+	valor := l.campos[nombre]
+	if valor == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(valor, 10, 64)
+	if err != nil && l.err == nil {
+		l.err = fmt.Errorf("%s inválido: %q", nombre, valor)
+	}
+	return n
+}
+
+func (l *lectorXML) entero(nombre string) int {
+	return int(l.numero(nombre))
+}
+
+func (l *lectorXML) monto(nombre string) money.Money {
+	valor := l.campos[nombre]
+	if valor == "" {
+		return 0
+	}
+	m, err := money.Parse(valor)
+	if err != nil && l.err == nil {
+		l.err = fmt.Errorf("%s: %v", nombre, err)
+	}
+	return m
+}
+
+// LeerFacturaXML arma la solicitud de una factura emitida a partir de su
+// XML de SIN, el que devuelve DescargarXML, para trabajar con lo que se
+// emitió y no con los datos actuales de la base de datos. Los campos del
+// sector 13 que no están en la cabecera quedan en los campos adicionales,
+// como los deja BuildFacturaServicios, y el tipo de emisión se lee del CUF.
+func (fe *FacturacionElectronica) LeerFacturaXML(doc []byte) (FacturaRequest, error) {
+	raiz, err := leerXML(doc)
+	if err != nil {
+		return FacturaRequest{}, fmt.Errorf("XML de la factura inválido: %v", err)
+	}
+
+	var cabecera *lectorXML
+	var detalle []*lectorXML
+	for _, hijo := range raiz.hijos {
+		switch hijo.nombre {
+		case "cabecera":
+			cabecera = nuevoLectorXML(hijo)
+		case "detalle":
+			detalle = append(detalle, nuevoLectorXML(hijo))
+		}
+	}
+	if cabecera == nil {
+		return FacturaRequest{}, fmt.Errorf("el XML %s no tiene cabecera", raiz.nombre)
+	}
+
+	l := cabecera
+	c := CabeceraModel{
+		NitEmisor:                    l.numero("nitEmisor"),
+		RazonSocialEmisor:            l.texto("razonSocialEmisor"),
+		Municipio:                    l.texto("municipio"),
+		Telefono:                     l.texto("telefono"),
+		NumeroFactura:                l.entero("numeroFactura"),
+		Cuf:                          l.texto("cuf"),
+		Cufd:                         l.texto("cufd"),
+		CodigoSucursal:               l.entero("codigoSucursal"),
+		Direccion:                    l.texto("direccion"),
+		CodigoPuntoVenta:             l.entero("codigoPuntoVenta"),
+		FechaEmision:                 l.texto("fechaEmision"),
+		NombreRazonSocial:            l.texto("nombreRazonSocial"),
+		CodigoTipoDocumentoIdentidad: l.entero("codigoTipoDocumentoIdentidad"),
+		NumeroDocumento:              l.texto("numeroDocumento"),
+		Complemento:                  l.texto("complemento"),
+		CodigoCliente:                l.texto("codigoCliente"),
+		CodigoMetodoPago:             l.entero("codigoMetodoPago"),
+		NumeroTarjeta:                l.entero("numeroTarjeta"),
+		MontoTotal:                   l.monto("montoTotal"),
+		MontoTotalSujetoIva:          l.monto("montoTotalSujetoIva"),
+		CodigoMoneda:                 l.entero("codigoMoneda"),
+		MontoTotalMoneda:             l.monto("montoTotalMoneda"),
+		MontoGiftCard:                l.monto("montoGiftCard"),
+		DescuentoAdicional:           l.monto("descuentoAdicional"),
+		CodigoExcepcion:              l.entero("codigoExcepcion"),
+		Cafc:                         l.texto("cafc"),
+		Leyenda:                      l.texto("leyenda"),
+		Usuario:                      l.texto("usuario"),
+		CodigoDocumentoSector:        l.entero("codigoDocumentoSector"),
+		CamposAdicionales:            []CampoAdicionalModel{},
+	}
+	c.TipoCambio, err = strconv.ParseFloat(ifEmpty(l.texto("tipoCambio"), "1"), 64)
+	if err != nil {
+		return FacturaRequest{}, fmt.Errorf("tipoCambio inválido: %q", l.texto("tipoCambio"))
+	}
+	if c.CodigoDocumentoSector == SectorServiciosBasicos {
+		for _, clave := range camposAdicionalesXML {
+			if valor := l.texto(clave); valor != "" {
+				c.CamposAdicionales = append(c.CamposAdicionales, CampoAdicionalModel{Clave: clave, Valor: valor})
+			}
+		}
+	}
+	if l.err != nil {
+		return FacturaRequest{}, fmt.Errorf("cabecera del XML: %v", l.err)
+	}
+
+	req := FacturaRequest{
+		Solicitud: SolicitudModel{
+			CodigoModalidad:       fe.emisor.CodigoModalidad,
+			CodigoEmision:         EmisionEnLinea,
+			CodigoDocumentoSector: c.CodigoDocumentoSector,
+			CodigoSucursal:        c.CodigoSucursal,
+			CodigoAmbiente:        fe.emisor.CodigoAmbiente,
+			CodigoPuntoVenta:      c.CodigoPuntoVenta,
+			CodigoActividad:       fe.emisor.CodigoActividad,
+			NitEmisor:             strconv.FormatInt(c.NitEmisor, 10),
+			FechaEmision:          c.FechaEmision,
+			NumeroFactura:         c.NumeroFactura,
+			FormatoPdf:            1,
+		},
+		Cabecera:  c,
+		ExtraInfo: []ExtraInfoModel{},
+	}
+	for _, d := range detalle {
+		req.Detalle = append(req.Detalle, DetalleModel{
+			ActividadEconomica: d.entero("actividadEconomica"),
+			CodigoProductoSin:  d.entero("codigoProductoSin"),
+			CodigoProducto:     d.texto("codigoProducto"),
+			Descripcion:        d.texto("descripcion"),
+			Cantidad:           d.entero("cantidad"),
+			UnidadMedida:       d.entero("unidadMedida"),
+			PrecioUnitario:     d.monto("precioUnitario"),
+			MontoDescuento:     d.monto("montoDescuento"),
+			SubTotal:           d.monto("subTotal"),
+			CamposAdicionales:  []CampoAdicionalModel{},
+		})
+		if d.err != nil {
+			return FacturaRequest{}, fmt.Errorf("detalle del XML: %v", d.err)
+		}
+	}
+
+	// el XML no dice si la factura se emitió fuera de línea; lo dice el CUF
+	if datos, err := DatosCUFDeFactura(req); err == nil {
+		datos.TipoEmision = EmisionFueraDeLinea
+		if VerificarCUF(c.Cuf, datos) == nil {
+			req.Solicitud.CodigoEmision = EmisionFueraDeLinea
+		}
+	}
+	return req, nil
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLeerFacturaXML(t *testing.T) {
+	fe := NewFacturacionElectronica(ApiConfig{}, DefaultEmisorProfile())
+	fe.SetCufd(&Cufd{Codigo: "CUFDPRUEBA", CodigoControl: "A19E95B0C3DAB92"})
+
+	for _, fueraDeLinea := range []bool{false, true} {
+		req, err := fe.BuildFacturaServicios(
+			time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local),
+			12.5, 5000, 1000, 200, 6500, 0, 50,
+			CargosServicios{NumeroMedidor: "M-77", TasaAseo: 300},
+			"PEREZ JUAN", "1001", "4512345", "CENTRAL", "BOLIVAR 12", "",
+			152,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fueraDeLinea {
+			FueraDeLinea(&req, EventoCorteInternet)
+		}
+		if _, err := fe.PreasignarCUF(&req); err != nil {
+			t.Fatal(err)
+		}
+		doc, err := GenerarXML(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		leida, err := fe.LeerFacturaXML(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leida.Solicitud.CodigoEmision != req.Solicitud.CodigoEmision {
+			t.Errorf("codigoEmision = %d, se esperaba %d", leida.Solicitud.CodigoEmision, req.Solicitud.CodigoEmision)
+		}
+		if !reflect.DeepEqual(leida.Detalle, req.Detalle) {
+			t.Errorf("detalle = %+v\nse esperaba %+v", leida.Detalle, req.Detalle)
+		}
+
+		adicionales := func(c CabeceraModel) map[string]string {
+			m := map[string]string{}
+			for _, campo := range c.CamposAdicionales {
+				m[campo.Clave] = campo.Valor
+			}
+			return m
+		}
+		if got, want := adicionales(leida.Cabecera), adicionales(req.Cabecera); !reflect.DeepEqual(got, want) {
+			t.Errorf("campos adicionales = %v\nse esperaban %v", got, want)
+		}
+		got, want := leida.Cabecera, req.Cabecera
+		got.CamposAdicionales, want.CamposAdicionales = nil, nil
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("cabecera = %+v\nse esperaba %+v", got, want)
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// esquemasXSD son los esquemas de SIN de los documentos que se generan
+// localmente. El validador entiende solo lo que usan: secuencias de
+// elementos, minOccurs/maxOccurs, nillable y restricciones simples.
+//
+//go:embed xsd/*.xsd
+var esquemasXSD embed.FS
+
+const xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+// ErrorCampo es un error de validación de un campo del XML, con la ruta
+// del campo, p. ej. "cabecera/numeroDocumento" o "detalle[2]/cantidad".
+type ErrorCampo struct {
+	Campo   string
+	Mensaje string
+}
+
+func (e ErrorCampo) String() string {
+	return e.Campo + ": " + e.Mensaje
+}
+
+// ErrorEsquema lista los campos del XML que no cumplen el XSD de SIN.
+type ErrorEsquema struct {
+	Documento string
+	Errores   []ErrorCampo
+}
+
+func (e *ErrorEsquema) Error() string {
+	errores := make([]string, len(e.Errores))
+	for i, campo := range e.Errores {
+		errores[i] = campo.String()
+	}
+	return fmt.Sprintf("%s no cumple el XSD: %s", e.Documento, strings.Join(errores, "; "))
+}
+
+// IsEsquema indica si err es un rechazo local por el XSD.
+func IsEsquema(err error) bool {
+	var esqErr *ErrorEsquema
+	return errors.As(err, &esqErr)
+}
+
+// Estructura del XSD tal como se lee del archivo.
+type xsdSchema struct {
+	Elementos    []xsdElemento   `xml:"element"`
+	TiposSimples []xsdTipoSimple `xml:"simpleType"`
+}
+
+type xsdElemento struct {
+	Nombre    string         `xml:"name,attr"`
+	Tipo      string         `xml:"type,attr"`
+	MinOccurs string         `xml:"minOccurs,attr"`
+	MaxOccurs string         `xml:"maxOccurs,attr"`
+	Nillable  bool           `xml:"nillable,attr"`
+	Complejo  *xsdComplejo   `xml:"complexType"`
+	Simple    *xsdTipoSimple `xml:"simpleType"`
+}
+
+type xsdComplejo struct {
+	Secuencia []xsdElemento `xml:"sequence>element"`
+}
+
+type xsdTipoSimple struct {
+	Nombre      string `xml:"name,attr"`
+	Restriccion struct {
+		Base           string      `xml:"base,attr"`
+		MinLength      *xsdFaceta  `xml:"minLength"`
+		MaxLength      *xsdFaceta  `xml:"maxLength"`
+		MinInclusive   *xsdFaceta  `xml:"minInclusive"`
+		MaxInclusive   *xsdFaceta  `xml:"maxInclusive"`
+		TotalDigits    *xsdFaceta  `xml:"totalDigits"`
+		FractionDigits *xsdFaceta  `xml:"fractionDigits"`
+		Patrones       []xsdFaceta `xml:"pattern"`
+		Enumeracion    []xsdFaceta `xml:"enumeration"`
+	} `xml:"restriction"`
+}
+
+type xsdFaceta struct {
+	Valor string `xml:"value,attr"`
+}
+
+// elementoXSD y tipoXSD son el esquema ya compilado para validar.
+type elementoXSD struct {
+	nombre   string
+	min, max int // max -1: sin límite
+	nillable bool
+	hijos    []*elementoXSD
+	tipo     *tipoXSD
+}
+
+type tipoXSD struct {
+	base           string
+	minLength      int
+	maxLength      int // -1: sin límite
+	minInclusive   *big.Rat
+	maxInclusive   *big.Rat
+	minTexto       string
+	maxTexto       string
+	totalDigits    int // 0: sin límite
+	fractionDigits int // -1: sin límite
+	patrones       []*regexp.Regexp
+	enumeracion    []string
+}
+
+var (
+	cargarEsquemas sync.Once
+	esquemas       map[string]*elementoXSD
+	esquemasErr    error
+)
+
+// esquema devuelve el esquema del elemento raíz raiz.
+func esquema(raiz string) (*elementoXSD, error) {
+	cargarEsquemas.Do(func() {
+		esquemas = map[string]*elementoXSD{}
+		archivos, err := esquemasXSD.ReadDir("xsd")
+		if err != nil {
+			esquemasErr = err
+			return
+		}
+		for _, archivo := range archivos {
+			data, err := esquemasXSD.ReadFile("xsd/" + archivo.Name())
+			if err != nil {
+				esquemasErr = err
+				return
+			}
+			raices, err := compilarXSD(data)
+			if err != nil {
+				esquemasErr = fmt.Errorf("%s: %v", archivo.Name(), err)
+				return
+			}
+			for _, r := range raices {
+				esquemas[r.nombre] = r
+			}
+		}
+	})
+	if esquemasErr != nil {
+		return nil, esquemasErr
+	}
+	e, ok := esquemas[raiz]
+	if !ok {
+		return nil, fmt.Errorf("no hay XSD para %s", raiz)
+	}
+	return e, nil
+}
+
+func compilarXSD(data []byte) ([]*elementoXSD, error) {
+	var schema xsdSchema
+	if err := xml.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	tipos := map[string]*tipoXSD{}
+	for _, ts := range schema.TiposSimples {
+		t, err := compilarTipo(ts)
+		if err != nil {
+			return nil, fmt.Errorf("tipo %s: %v", ts.Nombre, err)
+		}
+		tipos[ts.Nombre] = t
+	}
+
+	var raices []*elementoXSD
+	for _, el := range schema.Elementos {
+		e, err := compilarElemento(el, tipos)
+		if err != nil {
+			return nil, err
+		}
+		raices = append(raices, e)
+	}
+	return raices, nil
+}
+
+func compilarElemento(el xsdElemento, tipos map[string]*tipoXSD) (*elementoXSD, error) {
+	e := &elementoXSD{nombre: el.Nombre, min: 1, max: 1, nillable: el.Nillable}
+	if el.MinOccurs != "" {
+		n, err := strconv.Atoi(el.MinOccurs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: minOccurs inválido", el.Nombre)
+		}
+		e.min = n
+	}
+	switch el.MaxOccurs {
+	case "":
+	case "unbounded":
+		e.max = -1
+	default:
+		n, err := strconv.Atoi(el.MaxOccurs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: maxOccurs inválido", el.Nombre)
+		}
+		e.max = n
+	}
+
+	switch {
+	case el.Complejo != nil:
+		for _, hijo := range el.Complejo.Secuencia {
+			h, err := compilarElemento(hijo, tipos)
+			if err != nil {
+				return nil, err
+			}
+			e.hijos = append(e.hijos, h)
+		}
+	case el.Simple != nil:
+		t, err := compilarTipo(*el.Simple)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", el.Nombre, err)
+		}
+		e.tipo = t
+	case strings.HasPrefix(el.Tipo, "xs:"):
+		e.tipo = &tipoXSD{base: el.Tipo, maxLength: -1, fractionDigits: -1}
+	default:
+		t, ok := tipos[el.Tipo]
+		if !ok {
+			return nil, fmt.Errorf("%s: tipo desconocido %q", el.Nombre, el.Tipo)
+		}
+		e.tipo = t
+	}
+	return e, nil
+}
+
+func compilarTipo(ts xsdTipoSimple) (*tipoXSD, error) {
+	r := ts.Restriccion
+	t := &tipoXSD{base: r.Base, maxLength: -1, fractionDigits: -1}
+	entero := func(f *xsdFaceta, destino *int) error {
+		if f == nil {
+			return nil
+		}
+		n, err := strconv.Atoi(f.Valor)
+		if err != nil {
+			return fmt.Errorf("faceta inválida %q", f.Valor)
+		}
+		*destino = n
+		return nil
+	}
+	racional := func(f *xsdFaceta) (*big.Rat, error) {
+		if f == nil {
+			return nil, nil
+		}
+		v, ok := new(big.Rat).SetString(f.Valor)
+		if !ok {
+			return nil, fmt.Errorf("faceta inválida %q", f.Valor)
+		}
+		return v, nil
+	}
+
+	for _, err := range []error{
+		entero(r.MinLength, &t.minLength),
+		entero(r.MaxLength, &t.maxLength),
+		entero(r.TotalDigits, &t.totalDigits),
+		entero(r.FractionDigits, &t.fractionDigits),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if t.minInclusive, err = racional(r.MinInclusive); err != nil {
+		return nil, err
+	} else if t.minInclusive != nil {
+		t.minTexto = r.MinInclusive.Valor
+	}
+	if t.maxInclusive, err = racional(r.MaxInclusive); err != nil {
+		return nil, err
+	} else if t.maxInclusive != nil {
+		t.maxTexto = r.MaxInclusive.Valor
+	}
+	for _, p := range r.Patrones {
+		re, err := regexp.Compile("^(?:" + p.Valor + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("patrón inválido %q: %v", p.Valor, err)
+		}
+		t.patrones = append(t.patrones, re)
+	}
+	for _, e := range r.Enumeracion {
+		t.enumeracion = append(t.enumeracion, e.Valor)
+	}
+	return t, nil
+}
+
+// nodoXML es un elemento del documento a validar.
+type nodoXML struct {
+	nombre string
+	texto  string
+	nulo   bool
+	hijos  []*nodoXML
+}
+
+func leerXML(doc []byte) (*nodoXML, error) {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	var pila []*nodoXML
+	var raiz *nodoXML
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &nodoXML{nombre: t.Name.Local}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == xsiNamespace && attr.Name.Local == "nil" {
+					n.nulo = attr.Value == "true" || attr.Value == "1"
+				}
+			}
+			if len(pila) > 0 {
+				padre := pila[len(pila)-1]
+				padre.hijos = append(padre.hijos, n)
+			} else {
+				raiz = n
+			}
+			pila = append(pila, n)
+		case xml.EndElement:
+			pila = pila[:len(pila)-1]
+		case xml.CharData:
+			if len(pila) > 0 {
+				pila[len(pila)-1].texto += string(t)
+			}
+		}
+	}
+	if raiz == nil {
+		return nil, fmt.Errorf("documento XML vacío")
+	}
+	return raiz, nil
+}
+
+// ValidarXML valida doc contra el XSD de su elemento raíz y devuelve un
+// *ErrorEsquema con todos los campos que no lo cumplen.
+func ValidarXML(doc []byte) error {
+	raiz, err := leerXML(doc)
+	if err != nil {
+		return fmt.Errorf("XML inválido: %v", err)
+	}
+	e, err := esquema(raiz.nombre)
+	if err != nil {
+		return err
+	}
+	var errores []ErrorCampo
+	validarNodo(e, raiz, "", &errores)
+	if len(errores) > 0 {
+		return &ErrorEsquema{Documento: raiz.nombre, Errores: errores}
+	}
+	return nil
+}
+
+func validarNodo(e *elementoXSD, n *nodoXML, ruta string, errores *[]ErrorCampo) {
+	agregar := func(campo, formato string, args ...interface{}) {
+		*errores = append(*errores, ErrorCampo{Campo: campo, Mensaje: fmt.Sprintf(formato, args...)})
+	}
+	campoRuta := func(nombre string) string {
+		if ruta == "" {
+			return nombre
+		}
+		return ruta + "/" + nombre
+	}
+
+	if n.nulo {
+		if !e.nillable {
+			agregar(ruta, "no puede ser nulo")
+		} else if strings.TrimSpace(n.texto) != "" || len(n.hijos) > 0 {
+			agregar(ruta, "un campo nulo debe estar vacío")
+		}
+		return
+	}
+
+	if e.tipo != nil {
+		if len(n.hijos) > 0 {
+			agregar(ruta, "no debe tener elementos")
+			return
+		}
+		if msg := e.tipo.validar(n.texto); msg != "" {
+			agregar(ruta, "%s", msg)
+		}
+		return
+	}
+
+	i := 0
+	for _, hijo := range e.hijos {
+		inicio := i
+		for i < len(n.hijos) && n.hijos[i].nombre == hijo.nombre && (hijo.max < 0 || i-inicio < hijo.max) {
+			i++
+		}
+		cantidad := i - inicio
+		if cantidad < hijo.min {
+			agregar(campoRuta(hijo.nombre), "falta el campo")
+		}
+		for k := inicio; k < i; k++ {
+			nombre := hijo.nombre
+			if hijo.max != 1 {
+				nombre = fmt.Sprintf("%s[%d]", hijo.nombre, k-inicio+1)
+			}
+			validarNodo(hijo, n.hijos[k], campoRuta(nombre), errores)
+		}
+	}
+	for ; i < len(n.hijos); i++ {
+		agregar(campoRuta(n.hijos[i].nombre), "campo inesperado o fuera de orden")
+	}
+}
+
+var (
+	reEntero   = regexp.MustCompile(`^[+-]?[0-9]+$`)
+	reDecimal  = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+	reDateTime = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?(Z|[+-][0-9]{2}:[0-9]{2})?$`)
+)
+
+// validar devuelve un mensaje si valor no cumple el tipo, o "".
+func (t *tipoXSD) validar(valor string) string {
+	switch t.base {
+	case "xs:string":
+	case "xs:integer", "xs:int", "xs:long":
+		valor = strings.TrimSpace(valor)
+		if !reEntero.MatchString(valor) {
+			return fmt.Sprintf("%q no es un entero", valor)
+		}
+		if t.base != "xs:integer" {
+			bits := 64
+			if t.base == "xs:int" {
+				bits = 32
+			}
+			if _, err := strconv.ParseInt(valor, 10, bits); err != nil {
+				return fmt.Sprintf("%q está fuera del rango de %s", valor, t.base)
+			}
+		}
+	case "xs:decimal":
+		valor = strings.TrimSpace(valor)
+		if !reDecimal.MatchString(valor) {
+			return fmt.Sprintf("%q no es un decimal", valor)
+		}
+	case "xs:dateTime":
+		valor = strings.TrimSpace(valor)
+		if !reDateTime.MatchString(valor) {
+			return fmt.Sprintf("%q no es una fecha y hora", valor)
+		}
+	default:
+		return fmt.Sprintf("tipo base no soportado %s", t.base)
+	}
+
+	if largo := utf8.RuneCountInString(valor); largo < t.minLength {
+		if largo == 0 {
+			return "no puede estar vacío"
+		}
+		return fmt.Sprintf("largo %d menor al mínimo %d", largo, t.minLength)
+	} else if t.maxLength >= 0 && largo > t.maxLength {
+		return fmt.Sprintf("largo %d mayor al máximo %d", largo, t.maxLength)
+	}
+	for _, re := range t.patrones {
+		if !re.MatchString(valor) {
+			return fmt.Sprintf("%q no cumple el patrón %s", valor, strings.TrimSuffix(strings.TrimPrefix(re.String(), "^(?:"), ")$"))
+		}
+	}
+	if len(t.enumeracion) > 0 {
+		permitido := false
+		for _, v := range t.enumeracion {
+			permitido = permitido || v == valor
+		}
+		if !permitido {
+			return fmt.Sprintf("%q no es un valor permitido (%s)", valor, strings.Join(t.enumeracion, ", "))
+		}
+	}
+
+	if t.base == "xs:string" || t.base == "xs:dateTime" {
+		return ""
+	}
+	numero, ok := new(big.Rat).SetString(valor)
+	if !ok {
+		return fmt.Sprintf("%q no es un número", valor)
+	}
+	if t.minInclusive != nil && numero.Cmp(t.minInclusive) < 0 {
+		return fmt.Sprintf("%s menor al mínimo %s", valor, t.minTexto)
+	}
+	if t.maxInclusive != nil && numero.Cmp(t.maxInclusive) > 0 {
+		return fmt.Sprintf("%s mayor al máximo %s", valor, t.maxTexto)
+	}
+	entero, decimales, _ := strings.Cut(strings.TrimLeft(valor, "+-"), ".")
+	decimales = strings.TrimRight(decimales, "0")
+	if t.fractionDigits >= 0 && len(decimales) > t.fractionDigits {
+		return fmt.Sprintf("%s tiene más de %d decimales", valor, t.fractionDigits)
+	}
+	if t.totalDigits > 0 && len(strings.TrimLeft(entero, "0"))+len(decimales) > t.totalDigits {
+		return fmt.Sprintf("%s tiene más de %d dígitos", valor, t.totalDigits)
+	}
+	return ""
+}
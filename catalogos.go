@@ -0,0 +1,67 @@
+package main
+
+import (
+	"app/api"
+	"context"
+	"flag"
+	"fmt"
+)
+
+// runCatalogos syncs the SIN parametric catalogs from the backend into the
+// file loaded at startup (-catalogos), imports them from CSV files exported
+// from the SIN portal, or lists one:
+//
+//	facturacion.exe catalogos
+//	facturacion.exe catalogos -importar unidades-medida.csv monedas.csv
+//	facturacion.exe catalogos -listar unidades-medida
+func runCatalogos(ctx context.Context, builder *api.FacturacionElectronica, fe api.InvoiceClient, path string, args []string) error {
+	fs := flag.NewFlagSet("catalogos", flag.ExitOnError)
+	importar := fs.Bool("importar", false, "Importar los archivos CSV indicados (codigo;descripcion[;codigoActividad]) en lugar de sincronizar")
+	listar := fs.String("listar", "", "Mostrar un catálogo")
+	fs.Parse(args)
+
+	catalogos := builder.Catalogos()
+
+	if *listar != "" {
+		for _, item := range catalogos.Items(api.TipoCatalogo(*listar)) {
+			if item.CodigoActividad != 0 {
+				fmt.Printf("%8d  %s (actividad %d)\n", item.Codigo, item.Descripcion, item.CodigoActividad)
+			} else {
+				fmt.Printf("%8d  %s\n", item.Codigo, item.Descripcion)
+			}
+		}
+		return nil
+	}
+
+	if *importar {
+		if fs.NArg() == 0 {
+			return fmt.Errorf("indique los archivos a importar")
+		}
+		for _, archivo := range fs.Args() {
+			tipo, items, err := api.ImportarCatalogo(archivo)
+			if err != nil {
+				return err
+			}
+			catalogos = catalogos.Con(tipo, items)
+			fmt.Printf("%s: %d códigos\n", tipo, len(items))
+		}
+	} else {
+		for _, tipo := range api.TiposCatalogo {
+			items, err := fe.ListarCatalogo(ctx, tipo)
+			if err != nil {
+				return fmt.Errorf("error al descargar el catálogo %s: %v", tipo, err)
+			}
+			if len(items) == 0 {
+				return fmt.Errorf("el backend no devolvió códigos para el catálogo %s", tipo)
+			}
+			catalogos = catalogos.Con(tipo, items)
+			fmt.Printf("%s: %d códigos\n", tipo, len(items))
+		}
+	}
+
+	if err := catalogos.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("Catálogos guardados en %s\n", path)
+	return nil
+}
@@ -0,0 +1,163 @@
+// mockserver runs the simulated invoicing backend so new staff can practice
+// full monthly runs from the GUI without touching production. The runs
+// write to the database, so facturacion.exe must point to a practice copy;
+// it detects this server through /mock/opciones and refuses the production
+// database:
+//
+//	mockserver -addr :3001 -datos mock.json -caidas 0.02
+//	facturacion.exe -apiUrl http://localhost:3001 -apiKey prueba -connString "server=localhost;database=EMPSAAT_PRACTICA;..."
+package main
+
+import (
+	"app/mockapi"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", ":3001", "Dirección en la que escucha el servidor")
+	datos := flag.String("datos", "", "Archivo JSON donde se guardan las facturas (vacío: solo en memoria)")
+	opts := mockapi.Options{}
+	flag.StringVar(&opts.ApiKey, "apiKey", "", "api_key exigida (vacío: cualquiera)")
+	flag.DurationVar(&opts.Latency, "latencia", 0, "Latencia de cada llamada")
+	flag.DurationVar(&opts.LatencyJitter, "jitter", 0, "Latencia adicional aleatoria máxima")
+	flag.Float64Var(&opts.ValidationErrorRate, "rechazos", 0, "Fracción de facturas rechazadas por validación (0-1)")
+	flag.Float64Var(&opts.OutageRate, "caidas", 0, "Fracción de llamadas que fallan con 503 (0-1)")
+	flag.BoolVar(&opts.Offline, "sinRed", false, "Cortar todas las conexiones, como si no hubiera red")
+	flag.Int64Var(&opts.Seed, "seed", 0, "Semilla para las fallas aleatorias (0: aleatoria)")
+	flag.Parse()
+
+	backend := mockapi.NewBackend(opts)
+	if *datos != "" {
+		if err := load(backend, *datos); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", logRequests(mockapi.NewHandler(backend)))
+	mux.HandleFunc("GET /mock/facturas", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		backend.Save(w)
+	})
+	// Las opciones se pueden cambiar durante una práctica, por ejemplo
+	// para simular una caída y su recuperación:
+	//	curl -X POST localhost:3001/mock/opciones -d '{"Offline": true}'
+	mux.HandleFunc("GET /mock/opciones", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backend.Options())
+	})
+	mux.HandleFunc("POST /mock/opciones", func(w http.ResponseWriter, r *http.Request) {
+		nuevas := backend.Options()
+		if err := json.NewDecoder(r.Body).Decode(&nuevas); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		backend.SetOptions(nuevas)
+		log.Printf("Opciones: %+v", nuevas)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(nuevas)
+	})
+
+	server := &http.Server{Addr: *addr, Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *datos != "" {
+		go autosave(ctx, backend, *datos)
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Backend simulado escuchando en %s", *addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+
+	if *datos != "" {
+		if err := save(backend, *datos); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Facturas guardadas en %s", *datos)
+	}
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s %v", r.Method, r.URL.Path, time.Since(start))
+	})
+}
+
+// autosave guarda el backend cada pocos segundos si hubo cambios.
+func autosave(ctx context.Context, backend *mockapi.Backend, path string) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	guardados := backend.Cambios()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cambios := backend.Cambios()
+		if cambios == guardados {
+			continue
+		}
+		if err := save(backend, path); err != nil {
+			log.Println("Error saving datos:", err)
+			continue
+		}
+		guardados = cambios
+	}
+}
+
+func load(backend *mockapi.Backend, path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := backend.Load(f); err != nil {
+		return err
+	}
+	log.Printf("%d facturas cargadas de %s", len(backend.Facturas()), path)
+	return nil
+}
+
+func save(backend *mockapi.Backend, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := backend.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error al guardar %s: %v", path, err)
+	}
+	return nil
+}
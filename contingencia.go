@@ -0,0 +1,53 @@
+package main
+
+import (
+	"app/api"
+	"app/contingencia"
+	"app/db"
+	"context"
+	"flag"
+	"fmt"
+)
+
+// runContingencia lists the invoices emitted offline and, with -enviar,
+// registers the event and submits them:
+//
+//	facturacion.exe contingencia [-enviar]
+func runContingencia(ctx context.Context, fe api.InvoiceClient, cola *contingencia.Cola, args []string) error {
+	fs := flag.NewFlagSet("contingencia", flag.ExitOnError)
+	enviar := fs.Bool("enviar", false, "Registrar el evento y enviar las facturas pendientes")
+	fs.Parse(args)
+
+	evento, err := cola.Evento()
+	if err != nil {
+		return err
+	}
+	pendientes, err := cola.Pendientes()
+	if err != nil {
+		return err
+	}
+
+	if evento != nil {
+		fmt.Printf("Evento abierto desde %s: %d %s\n", evento.Inicio.Format("2006-01-02 15:04:05"), evento.Motivo, evento.Motivo)
+		if evento.Descripcion != "" {
+			fmt.Printf("  %s\n", evento.Descripcion)
+		}
+	}
+	fmt.Printf("%d facturas pendientes\n", len(pendientes))
+	for _, p := range pendientes {
+		fmt.Printf("  abonado %s  factura %d  %s\n", p.Abonado, p.Request.Cabecera.NumeroFactura, p.Request.Cabecera.FechaEmision)
+	}
+
+	if !*enviar || len(pendientes) == 0 {
+		return nil
+	}
+
+	enviadas, err := cola.Sincronizar(ctx, fe, func(p contingencia.Pendiente, cuf string) error {
+		if err := db.UpdateFacturaCodigoControl(p.FacturaID, cuf); err != nil {
+			return err
+		}
+		return db.UpdateFacturaLeyenda(p.FacturaID, p.Request.Cabecera.Leyenda)
+	})
+	fmt.Printf("%d de %d facturas enviadas\n", enviadas, len(pendientes))
+	return err
+}
@@ -0,0 +1,359 @@
+package contingencia
+
+import (
+	"app/api"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPaquete es la cantidad máxima de facturas por paquete que acepta SIN.
+const maxPaquete = 500
+
+// Evento es el evento significativo abierto mientras se emite fuera de línea.
+// Una vez registrado ya no recibe facturas: SIN rechaza las emitidas después
+// de su fechaHoraFinEvento, así que sus pendientes quedan con su
+// CodigoRecepcion y la siguiente factura fuera de línea abre otro evento.
+type Evento struct {
+	Motivo      api.EventoSignificativo `json:"motivo"`
+	Descripcion string                  `json:"descripcion"`
+	Inicio      time.Time               `json:"inicio"`
+	Fin         time.Time               `json:"fin,omitempty"`
+	// CodigoRecepcion se completa cuando el evento se registra en SIN.
+	CodigoRecepcion string `json:"codigoRecepcion,omitempty"`
+}
+
+// Pendiente es una factura armada fuera de línea que aún no fue enviada.
+type Pendiente struct {
+	FacturaID int                `json:"facturaId"`
+	Abonado   string             `json:"abonado"`
+	Request   api.FacturaRequest `json:"request"`
+	// CodigoEvento es el CodigoRecepcion del evento registrado con que se
+	// envía; está vacío mientras su evento sigue abierto.
+	CodigoEvento string `json:"codigoEvento,omitempty"`
+}
+
+// Cola guarda en disco el evento abierto y las facturas pendientes, una
+// por archivo, para que sobrevivan a un cierre de la aplicación:
+//
+//	<dir>/evento.json
+//	<dir>/facturas/<facturaID>.json
+type Cola struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewCola(dir string) *Cola {
+	return &Cola{dir: dir}
+}
+
+func (c *Cola) eventoPath() string {
+	return filepath.Join(c.dir, "evento.json")
+}
+
+func (c *Cola) facturaPath(facturaID int) string {
+	return filepath.Join(c.dir, "facturas", fmt.Sprintf("%d.json", facturaID))
+}
+
+// Evento devuelve el evento abierto o nil si no hay ninguno. Un evento ya
+// registrado no está abierto.
+func (c *Cola) Evento() (*Evento, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	evento, err := c.leerEvento()
+	if err != nil || evento == nil || evento.CodigoRecepcion != "" {
+		return nil, err
+	}
+	return evento, nil
+}
+
+func (c *Cola) leerEvento() (*Evento, error) {
+	data, err := os.ReadFile(c.eventoPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var evento Evento
+	if err := json.Unmarshal(data, &evento); err != nil {
+		return nil, fmt.Errorf("error al leer evento de contingencia: %v", err)
+	}
+	return &evento, nil
+}
+
+func (c *Cola) guardarEvento(evento *Evento) error {
+	return writeJSON(c.eventoPath(), evento)
+}
+
+// IniciarEvento abre un evento de contingencia si no hay uno abierto y lo
+// devuelve.
+func (c *Cola) IniciarEvento(motivo api.EventoSignificativo, descripcion string, inicio time.Time) (*Evento, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	evento, err := c.leerEvento()
+	if err != nil {
+		return nil, err
+	}
+	if evento != nil && evento.CodigoRecepcion == "" {
+		return evento, nil
+	}
+	if evento != nil {
+		if err := c.cerrarEvento(evento); err != nil {
+			return nil, err
+		}
+	}
+	evento = &Evento{
+		Motivo:      motivo,
+		Descripcion: descripcion,
+		Inicio:      inicio,
+	}
+	return evento, c.guardarEvento(evento)
+}
+
+// Agregar guarda una factura armada fuera de línea.
+func (c *Cola) Agregar(p Pendiente) error {
+	return writeJSON(c.facturaPath(p.FacturaID), p)
+}
+
+// Contiene indica si la factura ya está en la cola.
+func (c *Cola) Contiene(facturaID int) bool {
+	_, err := os.Stat(c.facturaPath(facturaID))
+	return err == nil
+}
+
+// Pendientes devuelve las facturas en cola ordenadas por número de factura.
+func (c *Cola) Pendientes() ([]Pendiente, error) {
+	files, err := filepath.Glob(filepath.Join(c.dir, "facturas", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pendientes []Pendiente
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var p Pendiente
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("error al leer %s: %v", file, err)
+		}
+		pendientes = append(pendientes, p)
+	}
+	sort.Slice(pendientes, func(i, j int) bool {
+		return pendientes[i].Request.Cabecera.NumeroFactura < pendientes[j].Request.Cabecera.NumeroFactura
+	})
+	return pendientes, nil
+}
+
+func (c *Cola) Quitar(facturaID int) error {
+	return os.Remove(c.facturaPath(facturaID))
+}
+
+// cerrarEvento asigna las pendientes que no tienen evento al evento ya
+// registrado y borra evento.json.
+func (c *Cola) cerrarEvento(evento *Evento) error {
+	pendientes, err := c.Pendientes()
+	if err != nil {
+		return err
+	}
+	for _, p := range pendientes {
+		if p.CodigoEvento != "" {
+			continue
+		}
+		p.CodigoEvento = evento.CodigoRecepcion
+		if err := c.Agregar(p); err != nil {
+			return err
+		}
+	}
+	return os.Remove(c.eventoPath())
+}
+
+// Sincronizar registra y cierra el evento abierto y envía las facturas
+// pendientes en paquetes, cada una con el evento bajo el que se emitió. Por
+// cada factura aceptada se llama a registrar con su CUF y, si no devuelve
+// error, se quita de la cola. Las pendientes de un evento registrado cuyo
+// paquete falló se reenvían con ese mismo evento; como el paquete pudo
+// llegar, antes se consultan por su CUF preasignado y las que el backend ya
+// tiene se registran sin reenviarlas.
+func (c *Cola) Sincronizar(ctx context.Context, fe api.InvoiceClient, registrar func(p Pendiente, cuf string) error) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pendientes, err := c.Pendientes()
+	if err != nil {
+		return 0, err
+	}
+	evento, err := c.leerEvento()
+	if err != nil {
+		return 0, err
+	}
+	if len(pendientes) == 0 {
+		if evento != nil {
+			return 0, os.Remove(c.eventoPath())
+		}
+		return 0, nil
+	}
+
+	sinEvento := 0
+	reenvios := map[int]bool{}
+	for _, p := range pendientes {
+		if p.CodigoEvento == "" {
+			sinEvento++
+		} else {
+			reenvios[p.FacturaID] = true
+		}
+	}
+	if sinEvento > 0 && evento == nil {
+		return 0, fmt.Errorf("hay %d facturas en contingencia sin evento abierto", sinEvento)
+	}
+	if evento != nil {
+		if evento.CodigoRecepcion == "" {
+			evento.Fin = time.Now()
+			resp, err := fe.RegistrarEvento(ctx, evento.Motivo, evento.Descripcion, evento.Inicio, evento.Fin)
+			if err != nil {
+				return 0, fmt.Errorf("error al registrar evento de contingencia: %w", err)
+			}
+			evento.CodigoRecepcion = resp.CodigoRecepcionEvento
+			if err := c.guardarEvento(evento); err != nil {
+				return 0, err
+			}
+		}
+		if err := c.cerrarEvento(evento); err != nil {
+			return 0, err
+		}
+		if pendientes, err = c.Pendientes(); err != nil {
+			return 0, err
+		}
+	}
+
+	enviadas := 0
+	var fallos []string
+	aceptar := func(p Pendiente, cuf string) error {
+		if err := registrar(p, cuf); err != nil {
+			fallos = append(fallos, fmt.Sprintf("abonado %s: %v", p.Abonado, err))
+			return nil
+		}
+		if err := c.Quitar(p.FacturaID); err != nil {
+			return err
+		}
+		enviadas++
+		return nil
+	}
+
+	var paquetes [][]Pendiente
+	porEvento := map[string][]Pendiente{}
+	var codigos []string
+	for _, p := range pendientes {
+		if cuf := p.Request.Cabecera.Cuf; reenvios[p.FacturaID] && cuf != "" {
+			estado, err := fe.ConsultarEstado(ctx, cuf)
+			var apiErr *api.APIError
+			if err != nil && !(errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound) {
+				fallos = append(fallos, fmt.Sprintf("abonado %s: no se pudo consultar si llegó en el paquete anterior: %v", p.Abonado, err))
+				continue
+			}
+			if err == nil && estado.Estado != api.EstadoRechazada {
+				if err := aceptar(p, estado.Cuf); err != nil {
+					return enviadas, err
+				}
+				continue
+			}
+		}
+		if _, ok := porEvento[p.CodigoEvento]; !ok {
+			codigos = append(codigos, p.CodigoEvento)
+		}
+		porEvento[p.CodigoEvento] = append(porEvento[p.CodigoEvento], p)
+	}
+	for _, codigo := range codigos {
+		delEvento := porEvento[codigo]
+		for start := 0; start < len(delEvento); start += maxPaquete {
+			end := start + maxPaquete
+			if end > len(delEvento) {
+				end = len(delEvento)
+			}
+			paquetes = append(paquetes, delEvento[start:end])
+		}
+	}
+
+	for _, paquete := range paquetes {
+		requests := make([]api.FacturaRequest, len(paquete))
+		porNumero := map[int]Pendiente{}
+		for i, p := range paquete {
+			requests[i] = p.Request
+			porNumero[p.Request.Cabecera.NumeroFactura] = p
+		}
+
+		resp, err := fe.EnviarPaquete(ctx, paquete[0].CodigoEvento, requests)
+		if err != nil {
+			return enviadas, fmt.Errorf("error al enviar paquete de contingencia: %w", err)
+		}
+
+		for i, factura := range resp.Facturas {
+			p, ok := porNumero[factura.NumeroFactura]
+			if factura.NumeroFactura == 0 && i < len(paquete) {
+				// sin número, el backend responde en el orden del paquete
+				p, ok = paquete[i], true
+			}
+			if !ok || factura.Cuf == "" {
+				fallos = append(fallos, fmt.Sprintf("factura %d: %s %s", factura.NumeroFactura, factura.Estado, strings.Join(factura.Observaciones, "; ")))
+				continue
+			}
+			if err := verificarCuf(p, factura.Cuf, factura.NumeroFactura); err != nil {
+				log.Println("Error checking CUF:", err)
+			}
+			if err := aceptar(p, factura.Cuf); err != nil {
+				return enviadas, err
+			}
+		}
+	}
+
+	if len(fallos) > 0 {
+		return enviadas, fmt.Errorf("%d facturas de contingencia no se registraron: %s", len(fallos), strings.Join(fallos, ", "))
+	}
+	return enviadas, nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// verificarCuf compara el cuf devuelto por el backend con el preasignado al
+// encolar la factura o, si no tiene, con sus datos.
+func verificarCuf(p Pendiente, cuf string, numero int) error {
+	if preasignado := p.Request.Cabecera.Cuf; preasignado != "" {
+		if preasignado != cuf {
+			return fmt.Errorf("factura %d: el backend devolvió el cuf %s y se había preasignado %s", numero, cuf, preasignado)
+		}
+		return nil
+	}
+	datos, err := api.DatosCUFDeFactura(p.Request)
+	if err != nil {
+		return err
+	}
+	if datos.Numero == 0 {
+		datos.Numero = numero
+	}
+	return api.VerificarCUF(cuf, datos)
+}
@@ -0,0 +1,92 @@
+package contingencia
+
+import (
+	"app/api"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// backendPaquetes registra eventos y paquetes. Con sinRespuesta registra
+// el paquete pero responde con un error, como una respuesta perdida.
+type backendPaquetes struct {
+	api.InvoiceClient
+	sinRespuesta bool
+	eventos      int
+	paquetes     map[string][]int
+	recibidas    map[string]int
+}
+
+func (b *backendPaquetes) RegistrarEvento(ctx context.Context, motivo api.EventoSignificativo, descripcion string, inicio, fin time.Time) (*api.EventoResponse, error) {
+	b.eventos++
+	return &api.EventoResponse{CodigoRecepcionEvento: fmt.Sprintf("EV%d", b.eventos)}, nil
+}
+
+func (b *backendPaquetes) EnviarPaquete(ctx context.Context, codigoEvento string, facturas []api.FacturaRequest) (*api.PaqueteResponse, error) {
+	resp := &api.PaqueteResponse{}
+	for _, f := range facturas {
+		b.paquetes[codigoEvento] = append(b.paquetes[codigoEvento], f.Cabecera.NumeroFactura)
+		b.recibidas[f.Cabecera.Cuf] = f.Cabecera.NumeroFactura
+		resp.Facturas = append(resp.Facturas, api.FacturaResponse{Cuf: f.Cabecera.Cuf, NumeroFactura: f.Cabecera.NumeroFactura})
+	}
+	if b.sinRespuesta {
+		return nil, &api.ErrorEnvioIncierto{Err: errors.New("paquete sin respuesta")}
+	}
+	return resp, nil
+}
+
+func (b *backendPaquetes) ConsultarEstado(ctx context.Context, cuf string) (*api.EstadoFacturaResponse, error) {
+	numero, ok := b.recibidas[cuf]
+	if !ok {
+		return nil, &api.APIError{StatusCode: http.StatusNotFound}
+	}
+	return &api.EstadoFacturaResponse{Cuf: cuf, NumeroFactura: numero, Estado: api.EstadoValidada}, nil
+}
+
+func TestEventoRegistradoNoRecibeFacturas(t *testing.T) {
+	cola := NewCola(t.TempDir())
+	agregar := func(numero int) {
+		t.Helper()
+		if _, err := cola.IniciarEvento(api.EventoCorteInternet, "", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		cabecera := api.CabeceraModel{NumeroFactura: numero, Cuf: fmt.Sprintf("CUF%d", numero)}
+		if err := cola.Agregar(Pendiente{FacturaID: numero, Request: api.FacturaRequest{Cabecera: cabecera}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registrados := map[int]string{}
+	registrar := func(p Pendiente, cuf string) error {
+		registrados[p.FacturaID] = cuf
+		return nil
+	}
+	backend := &backendPaquetes{sinRespuesta: true, paquetes: map[string][]int{}, recibidas: map[string]int{}}
+
+	agregar(1)
+	if _, err := cola.Sincronizar(context.Background(), backend, registrar); !api.IsIncierto(err) {
+		t.Fatalf("err = %v, se esperaba el envío incierto del paquete", err)
+	}
+	if evento, err := cola.Evento(); err != nil || evento != nil {
+		t.Fatalf("evento = %+v, %v; el evento registrado no debe quedar abierto", evento, err)
+	}
+
+	agregar(2)
+	backend.sinRespuesta = false
+	enviadas, err := cola.Sincronizar(context.Background(), backend, registrar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enviadas != 2 || backend.eventos != 2 {
+		t.Fatalf("enviadas = %d con %d eventos, se esperaban 2 y 2", enviadas, backend.eventos)
+	}
+	// la factura 1 ya llegó en el paquete sin respuesta y no se reenvía
+	if fmt.Sprint(backend.paquetes["EV1"]) != "[1]" || fmt.Sprint(backend.paquetes["EV2"]) != "[2]" {
+		t.Errorf("paquetes = %v, cada factura debe ir una vez y con su evento", backend.paquetes)
+	}
+	if registrados[1] != "CUF1" || registrados[2] != "CUF2" {
+		t.Errorf("registrados = %v", registrados)
+	}
+}
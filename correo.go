@@ -0,0 +1,80 @@
+package main
+
+import (
+	"app/correo"
+	"app/db"
+	"context"
+	"flag"
+	"fmt"
+)
+
+// runCorreo sends the emitted invoices of an emission to the clients that
+// have an email and were not reached yet, according to the delivery log:
+//
+//	facturacion.exe -smtpHost localhost -smtpPuerto 1025 correo [-emision 2024-07-01] [-reenviar] [abonado...]
+func runCorreo(ctx context.Context, enviador *correo.Enviador, args []string) error {
+	fs := flag.NewFlagSet("correo", flag.ExitOnError)
+	emision := fs.String("emision", "", "Fecha de emisión (por defecto la emisión actual de Factores)")
+	reenviar := fs.Bool("reenviar", false, "Enviar también a quienes ya recibieron la factura")
+	fs.Parse(args)
+
+	if enviador == nil {
+		return fmt.Errorf("indique el servidor de correo con -smtpHost")
+	}
+	if *emision == "" {
+		var err error
+		*emision, err = db.GetEmisionActual()
+		if err != nil {
+			return err
+		}
+	}
+
+	facturas, err := db.GetFacturasParaImpresion(*emision)
+	if err != nil {
+		return err
+	}
+	entregados, err := enviador.Bitacora().Entregados()
+	if err != nil {
+		return err
+	}
+	abonados := map[string]bool{}
+	for _, abonado := range fs.Args() {
+		abonados[abonado] = true
+	}
+
+	var envios []correo.Envio
+	for _, f := range facturas {
+		if f.Email == "" || (len(abonados) > 0 && !abonados[f.Abonado]) {
+			continue
+		}
+		if entregados[f.CodigoControl] && !*reenviar {
+			continue
+		}
+		envios = append(envios, correo.Envio{
+			Abonado:       f.Abonado,
+			Razon:         f.Razon,
+			Correo:        f.Email,
+			Cuf:           f.CodigoControl,
+			NumeroFactura: f.NumFactura,
+		})
+	}
+	if len(envios) == 0 {
+		fmt.Println("No hay correos pendientes")
+		return nil
+	}
+
+	fmt.Printf("Enviando %d correos...\n", len(envios))
+	despacho := enviador.Iniciar(ctx, 4, len(envios))
+	for _, envio := range envios {
+		despacho.Encolar(envio)
+	}
+	enviados, fallidos := despacho.Cerrar()
+	fmt.Printf("Correos: %d enviados, %d fallidos\n", enviados, fallidos)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if fallidos > 0 {
+		return fmt.Errorf("%d correos fallaron; vuelva a ejecutar el comando para reintentarlos", fallidos)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package correo
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	EstadoEnviado = "ENVIADO"
+	EstadoFallido = "FALLIDO"
+)
+
+// Registro es una línea de la bitácora de entregas.
+type Registro struct {
+	Fecha    time.Time `json:"fecha"`
+	Abonado  string    `json:"abonado"`
+	Cuf      string    `json:"cuf"`
+	Correo   string    `json:"correo"`
+	Estado   string    `json:"estado"`
+	Intentos int       `json:"intentos"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Bitacora guarda un registro JSON por línea en un archivo que solo crece.
+type Bitacora struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewBitacora(path string) *Bitacora {
+	return &Bitacora{path: path}
+}
+
+func (b *Bitacora) Registrar(r Registro) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(b.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Entregados devuelve los CUF que ya tienen un envío exitoso.
+func (b *Bitacora) Entregados() (map[string]bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entregados := map[string]bool{}
+	f, err := os.Open(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entregados, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Registro
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// una línea cortada por un cierre abrupto no invalida el resto
+			continue
+		}
+		if r.Estado == EstadoEnviado {
+			entregados[r.Cuf] = true
+		}
+	}
+	return entregados, scanner.Err()
+}
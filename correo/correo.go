@@ -0,0 +1,268 @@
+// Package correo envía a cada cliente el PDF y el XML de su factura por
+// SMTP, con reintentos y una bitácora de entregas.
+package correo
+
+import (
+	"app/api"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config son los datos del servidor SMTP. Para pruebas locales alcanza con
+// un servidor tipo MailHog sin usuario: Host "localhost", Port 1025.
+type Config struct {
+	Host     string
+	Port     int
+	Usuario  string
+	Password string
+	// Remitente es la dirección del From.
+	Remitente string
+	// Reintentos es la cantidad de intentos por correo.
+	Reintentos int
+	// Espera es la pausa antes del segundo intento; se duplica en cada uno.
+	Espera time.Duration
+}
+
+// Envio es una factura emitida que hay que mandar al cliente.
+type Envio struct {
+	Abonado       string
+	Razon         string
+	Correo        string
+	Cuf           string
+	NumeroFactura int
+}
+
+// Enviador arma y envía los correos.
+type Enviador struct {
+	cfg      Config
+	emisor   string
+	fe       api.InvoiceClient
+	bitacora *Bitacora
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewEnviador(cfg Config, emisor string, fe api.InvoiceClient, bitacora *Bitacora) *Enviador {
+	if cfg.Port == 0 {
+		cfg.Port = 25
+	}
+	if cfg.Reintentos <= 0 {
+		cfg.Reintentos = 3
+	}
+	if cfg.Espera <= 0 {
+		cfg.Espera = 5 * time.Second
+	}
+	return &Enviador{cfg: cfg, emisor: emisor, fe: fe, bitacora: bitacora, sendMail: smtp.SendMail}
+}
+
+// Bitacora devuelve la bitácora donde se registran las entregas.
+func (e *Enviador) Bitacora() *Bitacora {
+	return e.bitacora
+}
+
+// Enviar descarga el PDF y el XML de la factura y los manda al correo del
+// cliente, reintentando si el servidor SMTP falla. El resultado final queda
+// en la bitácora.
+func (e *Enviador) Enviar(ctx context.Context, envio Envio) error {
+	intentos, err := e.enviar(ctx, envio)
+	registro := Registro{
+		Fecha:    time.Now(),
+		Abonado:  envio.Abonado,
+		Cuf:      envio.Cuf,
+		Correo:   envio.Correo,
+		Estado:   EstadoEnviado,
+		Intentos: intentos,
+	}
+	if err != nil {
+		registro.Estado = EstadoFallido
+		registro.Error = err.Error()
+	}
+	if logErr := e.bitacora.Registrar(registro); logErr != nil && err == nil {
+		err = logErr
+	}
+	return err
+}
+
+func (e *Enviador) enviar(ctx context.Context, envio Envio) (int, error) {
+	ctx = api.ConAbonado(ctx, envio.Abonado)
+	if !strings.Contains(envio.Correo, "@") {
+		return 0, fmt.Errorf("correo inválido: %q", envio.Correo)
+	}
+
+	pdf, err := e.fe.DescargarPDF(ctx, envio.Cuf, api.FormatoCarta)
+	if err != nil {
+		return 0, fmt.Errorf("error al descargar el PDF: %v", err)
+	}
+	xml, err := e.fe.DescargarXML(ctx, envio.Cuf)
+	if err != nil {
+		return 0, fmt.Errorf("error al descargar el XML: %v", err)
+	}
+	msg, err := e.mensaje(envio, pdf, xml)
+	if err != nil {
+		return 0, err
+	}
+
+	var auth smtp.Auth
+	if e.cfg.Usuario != "" {
+		auth = smtp.PlainAuth("", e.cfg.Usuario, e.cfg.Password, e.cfg.Host)
+	}
+	addr := net.JoinHostPort(e.cfg.Host, strconv.Itoa(e.cfg.Port))
+
+	espera := e.cfg.Espera
+	for intento := 1; ; intento++ {
+		err = e.sendMail(addr, auth, e.cfg.Remitente, []string{envio.Correo}, msg)
+		if err == nil || intento >= e.cfg.Reintentos || !reintentable(err) {
+			return intento, err
+		}
+		timer := time.NewTimer(espera)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return intento, ctx.Err()
+		case <-timer.C:
+		}
+		espera *= 2
+	}
+}
+
+// reintentable descarta los rechazos permanentes del servidor (códigos 5xx),
+// por ejemplo una dirección inexistente.
+func reintentable(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code < 500
+	}
+	return true
+}
+
+// mensaje arma el correo MIME con el PDF y el XML adjuntos.
+func (e *Enviador) mensaje(envio Envio, pdf, xml []byte) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	texto := quotedprintable.NewWriter(part)
+	fmt.Fprintf(texto, "Estimado(a) %s:\r\n\r\nAdjuntamos su factura N. %d (abonado %s).\r\nCUF: %s\r\n\r\n%s\r\n",
+		envio.Razon, envio.NumeroFactura, envio.Abonado, envio.Cuf, e.emisor)
+	if err := texto.Close(); err != nil {
+		return nil, err
+	}
+
+	nombre := fmt.Sprintf("factura_%d_%s", envio.NumeroFactura, envio.Abonado)
+	for _, adjunto := range []struct {
+		nombre, tipo string
+		data         []byte
+	}{
+		{nombre + ".pdf", "application/pdf", pdf},
+		{nombre + ".xml", "application/xml", xml},
+	} {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {fmt.Sprintf("%s; name=%q", adjunto.tipo, adjunto.nombre)},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", adjunto.nombre)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(part, adjunto.data)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.cfg.Remitente)
+	fmt.Fprintf(&msg, "To: %s\r\n", envio.Correo)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", fmt.Sprintf("Factura N. %d - %s", envio.NumeroFactura, e.emisor)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", messageID(), e.cfg.Host)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+func messageID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Despacho envía correos en segundo plano mientras continúa la facturación.
+type Despacho struct {
+	envios    chan Envio
+	wg        sync.WaitGroup
+	enviados  atomic.Int64
+	fallidos  atomic.Int64
+	cerrarUna sync.Once
+}
+
+// Iniciar arranca trabajadores que envían lo que llega por Encolar hasta
+// que se llama a Cerrar o se cancela ctx.
+func (e *Enviador) Iniciar(ctx context.Context, trabajadores, capacidad int) *Despacho {
+	d := &Despacho{envios: make(chan Envio, capacidad)}
+	for i := 0; i < trabajadores; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for envio := range d.envios {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := e.Enviar(ctx, envio); err != nil {
+					log.Printf("Error sending correo to abonado %s: %v", envio.Abonado, err)
+					d.fallidos.Add(1)
+					continue
+				}
+				d.enviados.Add(1)
+			}
+		}()
+	}
+	return d
+}
+
+func (d *Despacho) Encolar(envio Envio) {
+	d.envios <- envio
+}
+
+// Cerrar espera a que terminen los envíos pendientes y devuelve cuántos
+// salieron y cuántos fallaron.
+func (d *Despacho) Cerrar() (enviados, fallidos int) {
+	d.cerrarUna.Do(func() { close(d.envios) })
+	d.wg.Wait()
+	return int(d.enviados.Load()), int(d.fallidos.Load())
+}
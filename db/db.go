@@ -1,9 +1,12 @@
 package db
 
 import (
+	"app/money"
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	_ "github.com/denisenkom/go-mssqldb"
 )
@@ -15,14 +18,14 @@ type Factura struct {
     Lectura     int
     ConM3       float64
     LecEstimada bool // int
-    ImpFijo     float64
-    ImpAdic     float64
-    ImpTotal    float64
-    ImpAlcanta  float64
-    ImpRep      float64
-    ImpRecargo  float64
-    ImpFactura  float64
-    ImpLey1886  float64
+    ImpFijo     money.Money
+    ImpAdic     money.Money
+    ImpTotal    money.Money
+    ImpAlcanta  money.Money
+    ImpRep      money.Money
+    ImpRecargo  money.Money
+    ImpFactura  money.Money
+    ImpLey1886  money.Money
     FecPago     *string 
     FacturaID   int
     NumFactura  int
@@ -34,6 +37,63 @@ type Factura struct {
     Nit         string
     Razon       string
     Liberacion  string
+    Email       string
+    // Cargos are the sector 13 amounts read from the Num_Medidor and
+    // Imp_*/Det_* columns, see cargosSelect
+    Cargos CargosServicios
+}
+
+// CargosServicios has the same fields as api.CargosServicios, so callers
+// convert it with api.CargosServicios(f.Cargos).
+type CargosServicios struct {
+	NumeroMedidor                string
+	DescuentoTarifaDignidad      money.Money
+	TasaAseo                     money.Money
+	TasaAlumbrado                money.Money
+	OtrasTasas                   money.Money
+	AjusteNoSujetoIva            money.Money
+	DetalleAjusteNoSujetoIva     string
+	OtrosPagosNoSujetoIva        money.Money
+	DetalleOtrosPagosNoSujetoIva string
+}
+
+// columnasMigradas are the Tabla.Columna added by the ALTER TABLE
+// statements in the readme. The queries use them directly.
+var columnasMigradas = []string{
+	"Facturas.Leyenda", "Facturas.Cuf_Anulado",
+	"Facturas.Num_Medidor", "Facturas.Imp_TarifaDignidad", "Facturas.Imp_Aseo", "Facturas.Imp_Alumbrado", "Facturas.Imp_OtrasTasas",
+	"Facturas.Imp_AjusteNoIva", "Facturas.Det_AjusteNoIva", "Facturas.Imp_OtrosPagos", "Facturas.Det_OtrosPagos",
+	"CLIENTE.EMAIL",
+}
+
+// ColumnasFaltantes returns the migrated columns, as Tabla.Columna, that
+// the database does not have yet.
+func ColumnasFaltantes() ([]string, error) {
+	rows, err := DB.Query(`SELECT TABLE_NAME, COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_NAME IN ('Facturas', 'CLIENTE')`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existentes := map[string]bool{}
+	for rows.Next() {
+		var tabla, columna string
+		if err := rows.Scan(&tabla, &columna); err != nil {
+			return nil, err
+		}
+		existentes[strings.ToLower(tabla+"."+columna)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var faltantes []string
+	for _, columna := range columnasMigradas {
+		if !existentes[strings.ToLower(columna)] {
+			faltantes = append(faltantes, columna)
+		}
+	}
+	return faltantes, nil
 }
 
 func InitDB(connString string) {
@@ -185,7 +245,8 @@ func GetFacturasParaFacturacion(emision string) ([]Factura, error) {
         Usuarios.ley1886,
         CLIENTE.Nit,
         CLIENTE.RAZON,
-        Usuarios.Liberacion
+        Usuarios.Liberacion,
+        COALESCE(CLIENTE.EMAIL, ''),` + cargosSelect + `
     FROM facturas
     LEFT JOIN Usuarios ON Usuarios.Abonado = facturas.abonado  
     LEFT JOIN CLIENTE ON CLIENTE.CLIENTE = Usuarios.NODOC
@@ -193,6 +254,7 @@ func GetFacturasParaFacturacion(emision string) ([]Factura, error) {
     AND facturas.servicio = 1 
     AND facturas.imp_factura > 0 
     AND facturas.Codigo_control IS NULL 
+    AND facturas.Cuf_Anulado IS NULL
     ORDER BY facturas.num_factura ASC`
 
     rows, err := DB.Query(query, emision)
@@ -211,7 +273,11 @@ func GetFacturasParaFacturacion(emision string) ([]Factura, error) {
             &f.ImpRep, &f.ImpRecargo, &f.ImpFactura, &f.ImpLey1886,
             &fecPago, &f.FacturaID, &f.NumFactura, &f.NODOC,
             &f.Categoria, &f.Zona, &f.Calle, &f.Ley1886,
-            &f.Nit, &f.Razon, &f.Liberacion,
+            &f.Nit, &f.Razon, &f.Liberacion, &f.Email,
+            &f.Cargos.NumeroMedidor, &f.Cargos.DescuentoTarifaDignidad,
+            &f.Cargos.TasaAseo, &f.Cargos.TasaAlumbrado, &f.Cargos.OtrasTasas,
+            &f.Cargos.AjusteNoSujetoIva, &f.Cargos.DetalleAjusteNoSujetoIva,
+            &f.Cargos.OtrosPagosNoSujetoIva, &f.Cargos.DetalleOtrosPagosNoSujetoIva,
         )
         if err != nil {
             return nil, err
@@ -234,4 +300,223 @@ func UpdateFacturaCodigoControl(factura int, codigoControl string) error {
 	`, codigoControl, factura)
 	_, err := DB.Exec(query)
 	return err
-}
\ No newline at end of file
+}
+
+// FacturaEmitida is an invoice that already has a Codigo_Control (CUF).
+type FacturaEmitida struct {
+	FacturaID     int
+	NumFactura    int
+	Abonado       string
+	Zona          string
+	CodigoControl string
+}
+
+func GetEmisionActual() (string, error) {
+	factores, err := GetFactores()
+	if err != nil {
+		return "", err
+	}
+	if len(factores) == 0 {
+		return "", fmt.Errorf("no se encontraron factores")
+	}
+	emision, ok := factores[0]["Emision"].(time.Time)
+	if !ok {
+		return "", fmt.Errorf("emisión inválida en factores: %v", factores[0]["Emision"])
+	}
+	return emision.Format("2006-01-02"), nil
+}
+
+func GetFacturaEmitida(emision, abonado string) (*FacturaEmitida, error) {
+	query := `SELECT Facturas.Factura, Facturas.Num_Factura, Facturas.Abonado, COALESCE(Usuarios.zona, ''), Facturas.Codigo_Control
+	FROM Facturas
+	LEFT JOIN Usuarios ON Usuarios.Abonado = Facturas.Abonado
+	WHERE CONVERT(date, Facturas.Emision) = @p1
+	AND Facturas.Abonado = @p2
+	AND Facturas.Servicio = 1
+	AND Facturas.Codigo_Control IS NOT NULL`
+
+	var f FacturaEmitida
+	err := DB.QueryRow(query, emision, abonado).Scan(&f.FacturaID, &f.NumFactura, &f.Abonado, &f.Zona, &f.CodigoControl)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("el abonado %s no tiene factura emitida en %s", abonado, emision)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// AnularFacturaCodigoControl moves the CUF of an annulled invoice to
+// Cuf_Anulado. The row stops counting as emitted, and the emission query
+// skips it because its Num_Factura was already used: a corrected invoice
+// needs a new Facturas row.
+func AnularFacturaCodigoControl(factura int, codigoControl string) error {
+	query := `UPDATE Facturas
+	SET Cuf_Anulado = Codigo_Control, Codigo_Control = NULL
+	WHERE Factura = @p1 AND Codigo_Control = @p2`
+	result, err := DB.Exec(query, factura, codigoControl)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("la factura %d no tiene el código de control %s", factura, codigoControl)
+	}
+	return nil
+}
+
+func GetFacturasEmitidas(emision string) ([]FacturaEmitida, error) {
+	query := `SELECT Facturas.Factura, Facturas.Num_Factura, Facturas.Abonado, COALESCE(Usuarios.zona, ''), Facturas.Codigo_Control
+	FROM Facturas
+	LEFT JOIN Usuarios ON Usuarios.Abonado = Facturas.Abonado
+	WHERE CONVERT(date, Facturas.Emision) = @p1
+	AND Facturas.Servicio = 1
+	AND Facturas.Codigo_Control IS NOT NULL
+	ORDER BY Facturas.Num_Factura ASC`
+
+	rows, err := DB.Query(query, emision)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var facturas []FacturaEmitida
+	for rows.Next() {
+		var f FacturaEmitida
+		if err := rows.Scan(&f.FacturaID, &f.NumFactura, &f.Abonado, &f.Zona, &f.CodigoControl); err != nil {
+			return nil, err
+		}
+		facturas = append(facturas, f)
+	}
+	return facturas, rows.Err()
+}
+
+// FacturaOriginal is an emitted invoice with the data needed to issue a
+// nota de crédito-débito against it.
+type FacturaOriginal struct {
+	Factura
+	CodigoControl string
+}
+
+// cargosSelect selects the sector 13 amounts scanned into Factura.Cargos.
+const cargosSelect = `
+		COALESCE(facturas.Num_Medidor, ''),
+		COALESCE(facturas.Imp_TarifaDignidad, 0),
+		COALESCE(facturas.Imp_Aseo, 0),
+		COALESCE(facturas.Imp_Alumbrado, 0),
+		COALESCE(facturas.Imp_OtrasTasas, 0),
+		COALESCE(facturas.Imp_AjusteNoIva, 0),
+		COALESCE(facturas.Det_AjusteNoIva, ''),
+		COALESCE(facturas.Imp_OtrosPagos, 0),
+		COALESCE(facturas.Det_OtrosPagos, '')`
+
+// facturaOriginalSelect selects the columns read by scanFacturaOriginal.
+const facturaOriginalSelect = `SELECT
+		facturas.abonado,
+		facturas.lectura,
+		facturas.con_m3,
+		facturas.lec_estimada,
+		facturas.Imp_Fijo,
+		facturas.Imp_Adic,
+		facturas.Imp_Total,
+		facturas.Imp_Alcanta,
+		facturas.Imp_Rep,
+		facturas.Imp_Recargo,
+		facturas.Imp_Factura,
+		COALESCE(facturas.imp_ley1886_1 + facturas.imp_ley1886_2, 0) as imp_ley1886,
+		facturas.Fec_Pago,
+		facturas.Factura,
+		facturas.Num_Factura,
+		COALESCE(Usuarios.NODOC, ''),
+		COALESCE(Usuarios.Categoria, ''),
+		COALESCE(Usuarios.zona, ''),
+		COALESCE(Usuarios.calle, ''),
+		COALESCE(Usuarios.ley1886, ''),
+		COALESCE(CLIENTE.Nit, ''),
+		COALESCE(CLIENTE.RAZON, ''),
+		COALESCE(Usuarios.Liberacion, ''),
+		COALESCE(CLIENTE.EMAIL, ''),
+		facturas.Codigo_Control,` + cargosSelect + `
+	FROM facturas
+	LEFT JOIN Usuarios ON Usuarios.Abonado = facturas.abonado
+	LEFT JOIN CLIENTE ON CLIENTE.CLIENTE = Usuarios.NODOC`
+
+func scanFacturaOriginal(row interface{ Scan(...interface{}) error }) (*FacturaOriginal, error) {
+	var f FacturaOriginal
+	var fecPago sql.NullString
+	err := row.Scan(
+		&f.Abonado, &f.Lectura, &f.ConM3, &f.LecEstimada,
+		&f.ImpFijo, &f.ImpAdic, &f.ImpTotal, &f.ImpAlcanta,
+		&f.ImpRep, &f.ImpRecargo, &f.ImpFactura, &f.ImpLey1886,
+		&fecPago, &f.FacturaID, &f.NumFactura, &f.NODOC,
+		&f.Categoria, &f.Zona, &f.Calle, &f.Ley1886,
+		&f.Nit, &f.Razon, &f.Liberacion, &f.Email,
+		&f.CodigoControl,
+		&f.Cargos.NumeroMedidor, &f.Cargos.DescuentoTarifaDignidad,
+		&f.Cargos.TasaAseo, &f.Cargos.TasaAlumbrado, &f.Cargos.OtrasTasas,
+		&f.Cargos.AjusteNoSujetoIva, &f.Cargos.DetalleAjusteNoSujetoIva,
+		&f.Cargos.OtrosPagosNoSujetoIva, &f.Cargos.DetalleOtrosPagosNoSujetoIva,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if fecPago.Valid {
+		f.FecPago = &fecPago.String
+	}
+	return &f, nil
+}
+
+// GetFacturaOriginal looks up an emitted invoice by Factura or, when factura
+// is 0, by Codigo_Control.
+func GetFacturaOriginal(factura int, codigoControl string) (*FacturaOriginal, error) {
+	query := facturaOriginalSelect + `
+	WHERE (facturas.Factura = @p1 OR (@p1 = 0 AND facturas.Codigo_Control = @p2))
+	AND facturas.Codigo_Control IS NOT NULL`
+
+	f, err := scanFacturaOriginal(DB.QueryRow(query, factura, codigoControl))
+	if err == sql.ErrNoRows {
+		if factura != 0 {
+			return nil, fmt.Errorf("la factura %d no existe o no fue emitida", factura)
+		}
+		return nil, fmt.Errorf("no hay factura emitida con el código de control %s", codigoControl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetFacturasParaImpresion returns the emitted invoices of an emission
+// ordered by zona, calle and abonado, as they are delivered.
+func GetFacturasParaImpresion(emision string) ([]FacturaOriginal, error) {
+	query := facturaOriginalSelect + `
+	WHERE CONVERT(date, facturas.emision) = @p1
+	AND facturas.servicio = 1
+	AND facturas.Codigo_Control IS NOT NULL
+	ORDER BY Usuarios.zona, Usuarios.calle, facturas.abonado`
+
+	rows, err := DB.Query(query, emision)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var facturas []FacturaOriginal
+	for rows.Next() {
+		f, err := scanFacturaOriginal(rows)
+		if err != nil {
+			return nil, err
+		}
+		facturas = append(facturas, *f)
+	}
+	return facturas, rows.Err()
+}
+
+// UpdateFacturaLeyenda records the leyenda printed on an emitted invoice.
+func UpdateFacturaLeyenda(factura int, leyenda string) error {
+	_, err := DB.Exec(`UPDATE Facturas SET Leyenda = @p1 WHERE Factura = @p2`, leyenda, factura)
+	return err
+}
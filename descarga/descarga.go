@@ -0,0 +1,167 @@
+// Package descarga baja los PDF y XML de una emisión completa a una
+// carpeta ordenada por gestión, mes y zona.
+package descarga
+
+import (
+	"app/api"
+	"app/db"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultConcurrencia = 8
+
+// Opciones controla qué se descarga y dónde.
+type Opciones struct {
+	// Dir es la carpeta raíz; los archivos quedan en
+	// <Dir>/<gestion>/<mes>/<zona>/<abonado>.pdf.
+	Dir     string
+	Formato api.FormatoPdf
+	// XML descarga también el XML junto a cada PDF.
+	XML          bool
+	Concurrencia int
+}
+
+// Fallo es una factura que no se pudo descargar.
+type Fallo struct {
+	Abonado string
+	Cuf     string
+	Err     error
+}
+
+// Resumen es el resultado de una descarga.
+type Resumen struct {
+	Total       int
+	Descargados int
+	Existentes  int
+	Fallos      []Fallo
+}
+
+func (r *Resumen) String() string {
+	return fmt.Sprintf("Facturas %d: descargadas = %d, ya existentes = %d, errores = %d",
+		r.Total, r.Descargados, r.Existentes, len(r.Fallos))
+}
+
+// Ruta devuelve el archivo de una factura sin extensión.
+func Ruta(dir string, emision time.Time, zona, abonado string) string {
+	return filepath.Join(dir, emision.Format("2006"), emision.Format("01"), NombreArchivo(zona, "sin_zona"), NombreArchivo(abonado, "sin_abonado"))
+}
+
+// Descargar baja los documentos de cada factura emitida, con hasta
+// opts.Concurrencia descargas simultáneas. Los archivos que ya existen no
+// se vuelven a pedir. progress se llama después de cada factura.
+func Descargar(ctx context.Context, fe api.InvoiceClient, emision time.Time, facturas []db.FacturaEmitida, opts Opciones, progress func(done int)) (*Resumen, error) {
+	concurrencia := opts.Concurrencia
+	if concurrencia <= 0 {
+		concurrencia = defaultConcurrencia
+	}
+	if opts.Formato == 0 {
+		opts.Formato = api.FormatoCarta
+	}
+
+	resumen := &Resumen{Total: len(facturas)}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrencia)
+	done := 0
+
+	for _, factura := range facturas {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(factura db.FacturaEmitida) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			ctx := api.ConAbonado(ctx, factura.Abonado)
+			ruta := Ruta(opts.Dir, emision, factura.Zona, factura.Abonado)
+			nuevo, err := descargarArchivo(ruta+".pdf", func() ([]byte, error) {
+				return fe.DescargarPDF(ctx, factura.CodigoControl, opts.Formato)
+			})
+			if err == nil && opts.XML {
+				var nuevoXML bool
+				nuevoXML, err = descargarArchivo(ruta+".xml", func() ([]byte, error) {
+					return fe.DescargarXML(ctx, factura.CodigoControl)
+				})
+				nuevo = nuevo || nuevoXML
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if progress != nil {
+				progress(done)
+			}
+			switch {
+			case err != nil:
+				resumen.Fallos = append(resumen.Fallos, Fallo{Abonado: factura.Abonado, Cuf: factura.CodigoControl, Err: err})
+			case nuevo:
+				resumen.Descargados++
+			default:
+				resumen.Existentes++
+			}
+		}(factura)
+	}
+
+	wg.Wait()
+	return resumen, ctx.Err()
+}
+
+// descargarArchivo escribe el resultado de fetch en path si el archivo no
+// existe todavía. Devuelve true si lo descargó.
+func descargarArchivo(path string, fetch func() ([]byte, error)) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	data, err := fetch()
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	// se escribe a un temporal para que una descarga cortada no quede
+	// como archivo existente
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NombreArchivo quita los caracteres que no se pueden usar en un nombre de
+// archivo de Windows.
+func NombreArchivo(s, fallback string) string {
+	s = strings.TrimSpace(s)
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(s, ". ")
+	if s == "" {
+		return fallback
+	}
+	return s
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"app/api"
+	"app/db"
+	"app/descarga"
+	"context"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// runDescargar downloads the PDF (and optionally XML) of every emitted CUF:
+//
+//	facturacion.exe descargar [-emision 2024-07-01] [-dir facturas] [-formato rollo] [-xml]
+func runDescargar(ctx context.Context, fe api.InvoiceClient, args []string) error {
+	fs := flag.NewFlagSet("descargar", flag.ExitOnError)
+	emision := fs.String("emision", "", "Fecha de emisión (por defecto la emisión actual de Factores)")
+	dir := fs.String("dir", "facturas", "Carpeta raíz; los archivos quedan en <dir>/<gestion>/<mes>/<zona>/<abonado>.pdf")
+	formato := fs.String("formato", "carta", "Formato del PDF: rollo o carta")
+	xml := fs.Bool("xml", false, "Descargar también el XML")
+	concurrencia := fs.Int("concurrencia", 8, "Descargas simultáneas")
+	fs.Parse(args)
+
+	formatoPdf, err := api.ParseFormatoPdf(*formato)
+	if err != nil {
+		return err
+	}
+
+	if *emision == "" {
+		*emision, err = db.GetEmisionActual()
+		if err != nil {
+			return err
+		}
+	}
+	fecha, err := time.Parse("2006-01-02", *emision)
+	if err != nil {
+		return fmt.Errorf("emisión inválida: %v", err)
+	}
+
+	emitidas, err := db.GetFacturasEmitidas(*emision)
+	if err != nil {
+		return err
+	}
+
+	resumen, err := descarga.Descargar(ctx, fe, fecha, emitidas, descarga.Opciones{
+		Dir:          *dir,
+		Formato:      formatoPdf,
+		XML:          *xml,
+		Concurrencia: *concurrencia,
+	}, func(done int) {
+		fmt.Printf("\rDescargando factura %d/%d", done, len(emitidas))
+	})
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(resumen)
+	for _, f := range resumen.Fallos {
+		fmt.Printf("  abonado %s  cuf %s  %v\n", f.Abonado, f.Cuf, f.Err)
+	}
+	if len(resumen.Fallos) > 0 {
+		return fmt.Errorf("%d de %d descargas fallaron; vuelva a ejecutar el comando para reintentarlas", len(resumen.Fallos), resumen.Total)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+// Package emision envía las facturas de una emisión al backend al ritmo que
+// permite flujo y las deja en la cola de contingencia cuando el backend no
+// responde.
+package emision
+
+import (
+	"app/api"
+	"app/contingencia"
+	"app/correo"
+	"app/db"
+	"app/flujo"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config reúne lo que necesita una facturación masiva.
+type Config struct {
+	// Periodo es la fecha de la emisión, de la que salen el mes y la
+	// gestión de cada factura; es el mismo con que se concilian los montos
+	Periodo time.Time
+	// Builder arma las solicitudes; se envían por Client
+	Builder        *api.FacturacionElectronica
+	Client         api.InvoiceClient
+	Contingencia   *contingencia.Cola
+	VerificadorNit *api.VerificadorNit
+	Flujo          flujo.Opciones
+	// Correos recibe cada factura aceptada con correo; nil no envía
+	Correos *correo.Despacho
+	// Registrar guarda el CUF y la leyenda de una factura aceptada
+	Registrar func(factura db.Factura, cuf, leyenda string) error
+}
+
+// Resultado clasifica las facturas procesadas en una corrida.
+type Resultado struct {
+	Procesados []db.Factura
+	Exitos     []db.Factura
+	Fallos     []db.Factura
+	EnCola     []db.Factura
+}
+
+// Progreso es el avance de la corrida después de cada factura.
+type Progreso struct {
+	Procesados int
+	Exitos     int
+	Fallos     int
+	EnCola     int
+	Flujo      flujo.Estado
+}
+
+// Masiva emite las facturas y devuelve cómo terminó cada una. Las que ya
+// están en la cola de contingencia se saltan. progress se llama después de
+// cada factura, de a una por vez.
+func Masiva(ctx context.Context, facturas []db.Factura, config Config, progress func(Progreso)) Resultado {
+	fe := config.Builder
+	control := flujo.New(config.Flujo)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var resultado Resultado
+
+	// Si quedó un evento abierto (el backend sigue sin responder) se emite
+	// directamente fuera de línea
+	var enContingencia atomic.Bool
+	if evento, err := config.Contingencia.Evento(); err == nil && evento != nil {
+		enContingencia.Store(true)
+	}
+
+	terminar := func(factura db.Factura, lista *[]db.Factura) {
+		mu.Lock()
+		defer mu.Unlock()
+		resultado.Procesados = append(resultado.Procesados, factura)
+		*lista = append(*lista, factura)
+		if progress != nil {
+			progress(Progreso{
+				Procesados: len(resultado.Procesados),
+				Exitos:     len(resultado.Exitos),
+				Fallos:     len(resultado.Fallos),
+				EnCola:     len(resultado.EnCola),
+				Flujo:      control.Estado(),
+			})
+		}
+	}
+
+	for _, factura := range facturas {
+		if config.Contingencia.Contiene(factura.FacturaID) {
+			continue
+		}
+		if err := control.Adquirir(ctx); err != nil {
+			log.Println("Facturación detenida:", err)
+			break
+		}
+		wg.Add(1)
+		go func(factura db.Factura) {
+			defer func() {
+				control.Liberar()
+				wg.Done()
+			}()
+
+			request, err := fe.BuildFacturaServicios(
+				config.Periodo,
+				factura.ConM3, factura.ImpTotal, factura.ImpAlcanta,
+				factura.ImpRep, factura.ImpFactura, factura.ImpRecargo,
+				factura.ImpLey1886, api.CargosServicios(factura.Cargos), factura.Razon, factura.Abonado,
+				factura.Nit, factura.Zona, factura.Calle, factura.Email,
+				factura.NumFactura,
+			)
+
+			if err == nil && config.VerificadorNit != nil && !enContingencia.Load() {
+				if verr := config.VerificadorNit.AplicarExcepcion(api.ConAbonado(ctx, factura.Abonado), &request); verr != nil {
+					log.Printf("Error verifying NIT of %s, sending with codigoExcepcion: %s", factura.Abonado, DescribirError(verr))
+				}
+			}
+
+			var result *api.FacturaResponse
+			if err == nil && !enContingencia.Load() {
+				inicio := time.Now()
+				result, err = config.Client.EnviarFactura(api.ConAbonado(ctx, factura.Abonado), request)
+				// caídas, 429 y timeouts indican que el backend está saturado
+				control.Registrar(time.Since(inicio), api.IsRetryable(err))
+			}
+
+			if result == nil && (err == nil || api.IsOutage(err)) {
+				err = encolarContingencia(config.Contingencia, fe, factura, request, err)
+				if err == nil {
+					enContingencia.Store(true)
+					terminar(factura, &resultado.EnCola)
+					return
+				}
+			}
+
+			if err == nil {
+				err = config.Registrar(factura, result.Cuf, request.Cabecera.Leyenda)
+				if err != nil {
+					log.Println("Error updating factura codigo control:", err)
+				} else if config.Correos != nil && factura.Email != "" {
+					config.Correos.Encolar(correo.Envio{
+						Abonado:       factura.Abonado,
+						Razon:         factura.Razon,
+						Correo:        factura.Email,
+						Cuf:           result.Cuf,
+						NumeroFactura: factura.NumFactura,
+					})
+				}
+			} else {
+				log.Printf("Error generating factura %s: %s", factura.Abonado, DescribirError(err))
+			}
+
+			if err != nil {
+				terminar(factura, &resultado.Fallos)
+				return
+			}
+			terminar(factura, &resultado.Exitos)
+		}(factura)
+	}
+
+	wg.Wait()
+	return resultado
+}
+
+// encolarContingencia guarda la factura para enviarla fuera de línea,
+// abriendo el evento significativo si todavía no hay uno. Con un CUFD
+// cargado la factura se guarda en la cola ya con su CUF; Codigo_Control se
+// escribe recién cuando el backend acepta el paquete.
+func encolarContingencia(cola *contingencia.Cola, fe *api.FacturacionElectronica, factura db.Factura, request api.FacturaRequest, sendErr error) error {
+	motivo := api.EventoCorteInternet
+	var apiErr *api.APIError
+	if errors.As(sendErr, &apiErr) {
+		motivo = api.EventoInaccesibilidadSIN
+	}
+	descripcion := ""
+	if sendErr != nil {
+		descripcion = DescribirError(sendErr)
+	}
+
+	evento, err := cola.IniciarEvento(motivo, descripcion, time.Now())
+	if err != nil {
+		return err
+	}
+	api.FueraDeLinea(&request, evento.Motivo)
+	if _, err := fe.PreasignarCUF(&request); err != nil {
+		log.Printf("Error generating CUF of %s: %v", factura.Abonado, err)
+	}
+	return cola.Agregar(contingencia.Pendiente{
+		FacturaID: factura.FacturaID,
+		Abonado:   factura.Abonado,
+		Request:   request,
+	})
+}
+
+// DescribirError distingue rechazos de validación, fallas de autenticación y
+// caídas del servidor para el registro de errores.
+func DescribirError(err error) string {
+	if api.IsCatalogo(err) {
+		return fmt.Sprintf("códigos fuera de los catálogos de SIN: %v", err)
+	}
+	if api.IsEsquema(err) {
+		return fmt.Sprintf("XML inválido: %v", err)
+	}
+	if api.IsIncierto(err) {
+		return fmt.Sprintf("envío sin confirmar, se revisará en la siguiente corrida: %v", err)
+	}
+	if api.IsAritmetico(err) {
+		return fmt.Sprintf("montos que no cumplen las reglas de SIN: %v", err)
+	}
+	var apiErr *api.APIError
+	if !errors.As(err, &apiErr) {
+		return fmt.Sprintf("error de conexión: %v", err)
+	}
+	switch apiErr.Kind() {
+	case api.KindValidation:
+		return fmt.Sprintf("rechazada por validación: %v", apiErr)
+	case api.KindAuth:
+		return fmt.Sprintf("api_key inválida o sin permisos: %v", apiErr)
+	case api.KindServer:
+		return fmt.Sprintf("servidor no disponible: %v", apiErr)
+	}
+	return apiErr.Error()
+}
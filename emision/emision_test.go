@@ -0,0 +1,165 @@
+package emision
+
+import (
+	"app/api"
+	"app/contingencia"
+	"app/db"
+	"app/flujo"
+	"app/mockapi"
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func facturasDePrueba(n int) []db.Factura {
+	facturas := make([]db.Factura, n)
+	for i := range facturas {
+		facturas[i] = db.Factura{
+			Abonado:    strconv.Itoa(1001 + i),
+			FacturaID:  500 + i,
+			NumFactura: 1 + i,
+			ConM3:      12,
+			ImpTotal:   5000,
+			ImpAlcanta: 1000,
+			ImpRep:     200,
+			ImpFactura: 6200,
+			Nit:        "0",
+			Razon:      "PEREZ JUAN",
+			Zona:       "CENTRAL",
+			Calle:      "BOLIVAR",
+		}
+	}
+	return facturas
+}
+
+// periodo es una emisión de otro mes, como una que se termina después de
+// fin de mes.
+var periodo = time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local)
+
+func TestMasiva(t *testing.T) {
+	tests := []struct {
+		nombre  string
+		opts    mockapi.Options
+		cerrado bool
+		exitos  int
+		fallos  int
+		enCola  int
+		emitida int
+	}{
+		{nombre: "todas aceptadas", exitos: 5, emitida: 5},
+		{nombre: "rechazadas por validación", opts: mockapi.Options{ValidationErrorRate: 1, Seed: 1}, fallos: 5},
+		{nombre: "conexión cortada sin respuesta", opts: mockapi.Options{Offline: true}, fallos: 5},
+		{nombre: "backend inaccesible", cerrado: true, enCola: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nombre, func(t *testing.T) {
+			server, backend := mockapi.NewServer(tt.opts)
+			defer server.Close()
+			if tt.cerrado {
+				server.Close()
+			}
+
+			fe := api.NewFacturacionElectronica(api.ApiConfig{
+				Url:   server.URL,
+				Retry: api.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			}, api.DefaultEmisorProfile())
+			fe.SetCufd(&mockapi.CufdSimulado)
+			cola := contingencia.NewCola(t.TempDir())
+
+			var mu sync.Mutex
+			registrados := map[int]string{}
+			var ultimo Progreso
+			resultado := Masiva(context.Background(), facturasDePrueba(5), Config{
+				Periodo:      periodo,
+				Builder:      fe,
+				Client:       fe,
+				Contingencia: cola,
+				Flujo:        flujo.Opciones{Min: 1, Max: 4, Inicial: 2},
+				Registrar: func(factura db.Factura, cuf, leyenda string) error {
+					mu.Lock()
+					defer mu.Unlock()
+					registrados[factura.FacturaID] = cuf
+					return nil
+				},
+			}, func(p Progreso) {
+				ultimo = p
+			})
+
+			if len(resultado.Exitos) != tt.exitos || len(resultado.Fallos) != tt.fallos || len(resultado.EnCola) != tt.enCola {
+				t.Fatalf("exitos = %d, fallos = %d, en cola = %d; se esperaban %d, %d, %d",
+					len(resultado.Exitos), len(resultado.Fallos), len(resultado.EnCola), tt.exitos, tt.fallos, tt.enCola)
+			}
+			if ultimo.Procesados != 5 {
+				t.Errorf("último progreso con %d procesadas", ultimo.Procesados)
+			}
+			if len(registrados) != tt.exitos {
+				t.Errorf("%d CUF registrados, se esperaban %d", len(registrados), tt.exitos)
+			}
+
+			pendientes, err := cola.Pendientes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pendientes) != tt.enCola {
+				t.Errorf("%d facturas en la cola, se esperaban %d", len(pendientes), tt.enCola)
+			}
+			for _, p := range pendientes {
+				// el CUF preasignado queda en la cola, no en la base de datos
+				if p.Request.Cabecera.Cuf == "" {
+					t.Errorf("abonado %s encolado sin CUF preasignado", p.Abonado)
+				}
+			}
+
+			emitidas := backend.Facturas()
+			if len(emitidas) != tt.emitida {
+				t.Fatalf("%d facturas en el backend, se esperaban %d", len(emitidas), tt.emitida)
+			}
+			for _, f := range resultado.Exitos {
+				estado, err := backend.Estado(registrados[f.FacturaID])
+				if err != nil {
+					t.Fatalf("abonado %s: %v", f.Abonado, err)
+				}
+				if estado.NumeroFactura != f.NumFactura {
+					t.Errorf("abonado %s registrado con la factura %d del backend", f.Abonado, estado.NumeroFactura)
+				}
+			}
+
+			// lo que se envía es lo que concilia verificacion.ConciliarMontos
+			porAbonado := map[string]db.Factura{}
+			for _, f := range facturasDePrueba(5) {
+				porAbonado[f.Abonado] = f
+			}
+			for _, emitida := range emitidas {
+				enviada := emitida.Request
+				f := porAbonado[enviada.Cabecera.CodigoCliente]
+				conciliada, err := fe.ArmarFacturaServicios(
+					periodo,
+					f.ConM3, f.ImpTotal, f.ImpAlcanta, f.ImpRep, f.ImpFactura, f.ImpRecargo, f.ImpLey1886,
+					api.CargosServicios(f.Cargos),
+					f.Razon, f.Abonado, f.Nit, f.Zona, f.Calle, f.Email,
+					f.NumFactura,
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := camposAdicionales(enviada), camposAdicionales(conciliada); !reflect.DeepEqual(got, want) {
+					t.Errorf("abonado %s enviado con %v, conciliado con %v", f.Abonado, got, want)
+				}
+				if !reflect.DeepEqual(enviada.Detalle, conciliada.Detalle) || enviada.Cabecera.MontoTotal != conciliada.Cabecera.MontoTotal {
+					t.Errorf("abonado %s enviado con montos distintos de los conciliados", f.Abonado)
+				}
+			}
+		})
+	}
+}
+
+func camposAdicionales(req api.FacturaRequest) map[string]string {
+	campos := map[string]string{}
+	for _, c := range req.Cabecera.CamposAdicionales {
+		campos[c.Clave] = c.Valor
+	}
+	return campos
+}
@@ -0,0 +1,242 @@
+// Package flujo controla el ritmo de las llamadas al backend durante la
+// facturación masiva: un límite de solicitudes por segundo y una
+// concurrencia que baja cuando el backend se satura y sube cuando responde
+// bien.
+package flujo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ventana es el período sobre el que se calcula el ritmo actual.
+const ventana = 10 * time.Second
+
+// toleranciaLatencia es cuántas veces la latencia de referencia se acepta
+// antes de considerar que el backend está saturado.
+const toleranciaLatencia = 2
+
+// reduccion es el factor por el que se multiplica la concurrencia al
+// detectar saturación.
+const reduccion = 0.7
+
+// Opciones configura el control. Con PorSegundo 0 no se limita el ritmo.
+type Opciones struct {
+	PorSegundo float64
+	// Min, Max e Inicial acotan las solicitudes simultáneas.
+	Min     int
+	Max     int
+	Inicial int
+}
+
+func DefaultOpciones() Opciones {
+	return Opciones{PorSegundo: 20, Min: 1, Max: 32, Inicial: 4}
+}
+
+// Estado es una foto del control para mostrar en pantalla.
+type Estado struct {
+	// Limite es la concurrencia permitida en este momento.
+	Limite  int
+	EnCurso int
+	// PorSegundo son las solicitudes completadas por segundo en los
+	// últimos 10 segundos.
+	PorSegundo float64
+	Latencia   time.Duration
+}
+
+// Control reparte los turnos para llamar al backend. Cada Adquirir exitoso
+// se corresponde con un Liberar; Registrar informa cómo respondió el
+// backend para ajustar la concurrencia.
+type Control struct {
+	opts Opciones
+	tasa *limitador
+
+	mu              sync.Mutex
+	cambio          chan struct{}
+	limite          float64
+	enCurso         int
+	latencia        time.Duration
+	referencia      time.Duration
+	ultimaReduccion time.Time
+	inicio          time.Time
+	completadas     []time.Time
+}
+
+func New(opts Opciones) *Control {
+	if opts.Min <= 0 {
+		opts.Min = 1
+	}
+	if opts.Max < opts.Min {
+		opts.Max = opts.Min
+	}
+	if opts.Inicial < opts.Min || opts.Inicial > opts.Max {
+		opts.Inicial = opts.Min
+	}
+	return &Control{
+		opts:   opts,
+		tasa:   newLimitador(opts.PorSegundo, opts.Max),
+		cambio: make(chan struct{}),
+		limite: float64(opts.Inicial),
+		inicio: time.Now(),
+	}
+}
+
+// Adquirir espera un turno libre dentro de la concurrencia actual y del
+// límite por segundo.
+func (c *Control) Adquirir(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.enCurso < int(c.limite) {
+			c.enCurso++
+			c.mu.Unlock()
+			break
+		}
+		cambio := c.cambio
+		c.mu.Unlock()
+
+		select {
+		case <-cambio:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := c.tasa.esperar(ctx); err != nil {
+		c.Liberar()
+		return err
+	}
+	return nil
+}
+
+// Liberar devuelve el turno tomado con Adquirir.
+func (c *Control) Liberar() {
+	c.mu.Lock()
+	c.enCurso--
+	c.avisar()
+	c.mu.Unlock()
+}
+
+// Registrar ajusta la concurrencia con el resultado de una llamada: la
+// reduce si el backend respondió con una caída o sobrecarga (saturado) o si
+// la latencia subió por encima de la tolerancia, y la aumenta de a poco en
+// otro caso.
+func (c *Control) Registrar(latencia time.Duration, saturado bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ahora := time.Now()
+	c.completadas = append(c.completadas, ahora)
+	c.descartarViejas(ahora)
+
+	if !saturado {
+		if c.latencia == 0 {
+			c.latencia = latencia
+		} else {
+			c.latencia = (c.latencia*4 + latencia) / 5
+		}
+		// la referencia es la menor latencia vista, que sube despacio para
+		// no quedar anclada a una respuesta excepcionalmente rápida
+		if c.referencia == 0 || latencia < c.referencia {
+			c.referencia = latencia
+		} else {
+			c.referencia += (latencia - c.referencia) / 100
+		}
+	}
+	lento := c.referencia > 0 && c.latencia > toleranciaLatencia*c.referencia
+
+	if saturado || lento {
+		// una reducción por latencia, para que las respuestas ya en curso
+		// no la repitan
+		if ahora.Sub(c.ultimaReduccion) >= c.latencia {
+			c.limite = max(float64(c.opts.Min), c.limite*reduccion)
+			c.ultimaReduccion = ahora
+		}
+	} else {
+		c.limite = min(float64(c.opts.Max), c.limite+1/c.limite)
+	}
+	c.avisar()
+}
+
+// Estado devuelve la concurrencia y el ritmo actuales.
+func (c *Control) Estado() Estado {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ahora := time.Now()
+	c.descartarViejas(ahora)
+	periodo := min(ventana, ahora.Sub(c.inicio))
+	var porSegundo float64
+	if periodo > 0 {
+		porSegundo = float64(len(c.completadas)) / periodo.Seconds()
+	}
+	return Estado{
+		Limite:     int(c.limite),
+		EnCurso:    c.enCurso,
+		PorSegundo: porSegundo,
+		Latencia:   c.latencia,
+	}
+}
+
+func (c *Control) descartarViejas(ahora time.Time) {
+	i := 0
+	for i < len(c.completadas) && ahora.Sub(c.completadas[i]) > ventana {
+		i++
+	}
+	c.completadas = c.completadas[i:]
+}
+
+// avisar despierta a los que esperan en Adquirir; se llama con mu tomado.
+func (c *Control) avisar() {
+	close(c.cambio)
+	c.cambio = make(chan struct{})
+}
+
+// limitador es un balde de fichas: se recargan porSegundo fichas por
+// segundo hasta rafaga, y cada solicitud consume una.
+type limitador struct {
+	porSegundo float64
+	rafaga     float64
+
+	mu     sync.Mutex
+	fichas float64
+	ultimo time.Time
+}
+
+func newLimitador(porSegundo float64, rafaga int) *limitador {
+	if rafaga < 1 {
+		rafaga = 1
+	}
+	return &limitador{porSegundo: porSegundo, rafaga: float64(rafaga), fichas: 1, ultimo: time.Now()}
+}
+
+func (l *limitador) esperar(ctx context.Context) error {
+	if l.porSegundo <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	ahora := time.Now()
+	l.fichas = min(l.rafaga, l.fichas+ahora.Sub(l.ultimo).Seconds()*l.porSegundo)
+	l.ultimo = ahora
+	// se reserva la ficha aunque haya que esperarla, para que las
+	// solicitudes salgan en orden
+	l.fichas--
+	espera := time.Duration(-l.fichas / l.porSegundo * float64(time.Second))
+	l.mu.Unlock()
+
+	if espera <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(espera)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.fichas++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
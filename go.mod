@@ -5,7 +5,11 @@ go 1.23.0
 require (
 	gioui.org v0.7.1
 	github.com/denisenkom/go-mssqldb v0.12.3
+	github.com/go-pdf/fpdf v0.9.0
+	github.com/goodsign/monday v1.0.2
+	github.com/pdfcpu/pdfcpu v0.8.1
 	golang.org/x/exp/shiny v0.0.0-20240707233637-46b078467d37
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -15,10 +19,15 @@ require (
 	github.com/go-text/typesetting-utils v0.0.0-20240329101916-eee87fb235a3 // indirect
 	github.com/golang-sql/civil v0.0.0-20190719163853-cb61b32ac6fe // indirect
 	github.com/golang-sql/sqlexp v0.1.0 // indirect
-	github.com/goodsign/monday v1.0.2 // indirect
+	github.com/hhrutter/lzw v1.0.0 // indirect
+	github.com/hhrutter/tiff v1.0.1 // indirect
+	github.com/mattn/go-runewidth v0.0.16 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
 	golang.org/x/crypto v0.23.0 // indirect
 	golang.org/x/exp v0.0.0-20240707233637-46b078467d37 // indirect
-	golang.org/x/image v0.18.0 // indirect
+	golang.org/x/image v0.19.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
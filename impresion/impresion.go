@@ -0,0 +1,159 @@
+// Package impresion arma un PDF por zona con las facturas ordenadas por
+// calle y abonado, precedidas de una portada con los totales por ruta.
+package impresion
+
+import (
+	"app/db"
+	"app/money"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// Ruta son las facturas de una calle.
+type Ruta struct {
+	Calle    string
+	Facturas []db.FacturaOriginal
+	Total    money.Money
+}
+
+// Zona agrupa las rutas de una zona en orden de entrega.
+type Zona struct {
+	Nombre string
+	Rutas  []Ruta
+}
+
+// Cantidad devuelve la cantidad de facturas de la zona.
+func (z *Zona) Cantidad() int {
+	n := 0
+	for _, r := range z.Rutas {
+		n += len(r.Facturas)
+	}
+	return n
+}
+
+// Total devuelve el importe facturado en la zona.
+func (z *Zona) Total() money.Money {
+	var total money.Money
+	for _, r := range z.Rutas {
+		total += r.Total
+	}
+	return total
+}
+
+// Agrupar ordena las facturas por zona, calle y abonado y las agrupa.
+func Agrupar(facturas []db.FacturaOriginal) []Zona {
+	ordenadas := make([]db.FacturaOriginal, len(facturas))
+	copy(ordenadas, facturas)
+	sort.SliceStable(ordenadas, func(i, j int) bool {
+		a, b := ordenadas[i], ordenadas[j]
+		if a.Zona != b.Zona {
+			return a.Zona < b.Zona
+		}
+		if a.Calle != b.Calle {
+			return a.Calle < b.Calle
+		}
+		return menorAbonado(a.Abonado, b.Abonado)
+	})
+
+	var zonas []Zona
+	for _, f := range ordenadas {
+		if len(zonas) == 0 || zonas[len(zonas)-1].Nombre != f.Zona {
+			zonas = append(zonas, Zona{Nombre: f.Zona})
+		}
+		zona := &zonas[len(zonas)-1]
+		if len(zona.Rutas) == 0 || zona.Rutas[len(zona.Rutas)-1].Calle != f.Calle {
+			zona.Rutas = append(zona.Rutas, Ruta{Calle: f.Calle})
+		}
+		ruta := &zona.Rutas[len(zona.Rutas)-1]
+		ruta.Facturas = append(ruta.Facturas, f)
+		ruta.Total += f.ImpFactura
+	}
+	return zonas
+}
+
+// menorAbonado compara numéricamente cuando ambos códigos son números, para
+// que 900 quede antes de 1000.
+func menorAbonado(a, b string) bool {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return na < nb
+	}
+	return a < b
+}
+
+// Portada genera la página de resumen de una zona.
+func Portada(zona Zona, emision time.Time) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "Letter", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetMargins(20, 20, 20)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, tr("Zona "+nombreZona(zona.Nombre)), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.CellFormat(0, 7, tr("Emisión "+emision.Format("02/01/2006")), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 7, tr(fmt.Sprintf("%d facturas, total Bs %s", zona.Cantidad(), zona.Total())), "", 1, "L", false, 0, "")
+	pdf.Ln(5)
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(12, 7, "#", "B", 0, "L", false, 0, "")
+	pdf.CellFormat(106, 7, tr("Calle"), "B", 0, "L", false, 0, "")
+	pdf.CellFormat(24, 7, tr("Facturas"), "B", 0, "R", false, 0, "")
+	pdf.CellFormat(34, 7, tr("Total Bs"), "B", 1, "R", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	for i, ruta := range zona.Rutas {
+		pdf.CellFormat(12, 6, strconv.Itoa(i+1), "", 0, "L", false, 0, "")
+		pdf.CellFormat(106, 6, tr(ifEmpty(ruta.Calle, "Sin calle")), "", 0, "L", false, 0, "")
+		pdf.CellFormat(24, 6, strconv.Itoa(len(ruta.Facturas)), "", 0, "R", false, 0, "")
+		pdf.CellFormat(34, 6, ruta.Total.String(), "", 1, "R", false, 0, "")
+	}
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(118, 7, tr("Total"), "T", 0, "L", false, 0, "")
+	pdf.CellFormat(24, 7, strconv.Itoa(zona.Cantidad()), "T", 0, "R", false, 0, "")
+	pdf.CellFormat(34, 7, zona.Total().String(), "T", 1, "R", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("error al generar la portada de la zona %s: %v", zona.Nombre, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Unir escribe en w la portada seguida de los PDF de archivos, en orden.
+func Unir(w io.Writer, portada []byte, archivos []string) error {
+	documentos := []io.ReadSeeker{bytes.NewReader(portada)}
+	for _, archivo := range archivos {
+		f, err := os.Open(archivo)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		documentos = append(documentos, f)
+	}
+	if err := pdfapi.MergeRaw(documentos, w, false, nil); err != nil {
+		return fmt.Errorf("error al unir los PDF: %v", err)
+	}
+	return nil
+}
+
+func nombreZona(zona string) string {
+	return ifEmpty(zona, "sin zona")
+}
+
+func ifEmpty(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"app/api"
+	"app/db"
+	"app/descarga"
+	"app/impresion"
+	"app/representacion"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runImprimir builds one print-ready PDF per zone, with the invoices ordered
+// by calle and abonado after a cover page with the totals per route. With
+// -local the invoices are rendered from their SIN XML instead of downloaded:
+//
+//	facturacion.exe imprimir [-emision 2024-07-01] [-zona Centro] [-salida impresion] [-local]
+func runImprimir(ctx context.Context, builder *api.FacturacionElectronica, fe api.InvoiceClient, args []string) error {
+	fs := flag.NewFlagSet("imprimir", flag.ExitOnError)
+	emision := fs.String("emision", "", "Fecha de emisión (por defecto la emisión actual de Factores)")
+	dir := fs.String("dir", "facturas", "Carpeta de los PDF descargados (ver el comando descargar)")
+	salida := fs.String("salida", "impresion", "Carpeta donde se guarda un PDF por zona")
+	formato := fs.String("formato", "carta", "Formato de los PDF a descargar: rollo o carta")
+	soloZona := fs.String("zona", "", "Armar solo esta zona")
+	local := fs.Bool("local", false, "Generar los PDF localmente a partir del XML de cada factura en lugar de descargarlos")
+	fs.Parse(args)
+
+	formatoPdf, err := api.ParseFormatoPdf(*formato)
+	if err != nil {
+		return err
+	}
+	if *emision == "" {
+		*emision, err = db.GetEmisionActual()
+		if err != nil {
+			return err
+		}
+	}
+	fecha, err := time.Parse("2006-01-02", *emision)
+	if err != nil {
+		return fmt.Errorf("emisión inválida: %v", err)
+	}
+
+	facturas, err := db.GetFacturasParaImpresion(*emision)
+	if err != nil {
+		return err
+	}
+	if *soloZona != "" {
+		var filtradas []db.FacturaOriginal
+		for _, f := range facturas {
+			if f.Zona == *soloZona {
+				filtradas = append(filtradas, f)
+			}
+		}
+		facturas = filtradas
+	}
+	if len(facturas) == 0 {
+		return fmt.Errorf("no hay facturas emitidas para imprimir en %s", *emision)
+	}
+
+	// se descargan solo los PDF que falten
+	emitidas := make([]db.FacturaEmitida, len(facturas))
+	for i, f := range facturas {
+		emitidas[i] = db.FacturaEmitida{
+			FacturaID:     f.FacturaID,
+			NumFactura:    f.NumFactura,
+			Abonado:       f.Abonado,
+			Zona:          f.Zona,
+			CodigoControl: f.CodigoControl,
+		}
+	}
+	var resumen *descarga.Resumen
+	if *local {
+		resumen, err = generarLocal(ctx, builder, fe, fecha, facturas, *dir, formatoPdf)
+	} else {
+		resumen, err = descarga.Descargar(ctx, fe, fecha, emitidas, descarga.Opciones{Dir: *dir, Formato: formatoPdf}, func(done int) {
+			fmt.Printf("\rDescargando factura %d/%d", done, len(emitidas))
+		})
+	}
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+	fmt.Println(resumen)
+	faltantes := map[string]bool{}
+	for _, f := range resumen.Fallos {
+		fmt.Printf("  abonado %s no se incluye: %v\n", f.Abonado, f.Err)
+		faltantes[f.Cuf] = true
+	}
+
+	var incluidas []db.FacturaOriginal
+	for _, f := range facturas {
+		if !faltantes[f.CodigoControl] {
+			incluidas = append(incluidas, f)
+		}
+	}
+
+	carpeta := filepath.Join(*salida, fecha.Format("2006"), fecha.Format("01"))
+	if err := os.MkdirAll(carpeta, 0755); err != nil {
+		return err
+	}
+	for _, zona := range impresion.Agrupar(incluidas) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var archivos []string
+		for _, ruta := range zona.Rutas {
+			for _, f := range ruta.Facturas {
+				archivos = append(archivos, descarga.Ruta(*dir, fecha, f.Zona, f.Abonado)+".pdf")
+			}
+		}
+
+		portada, err := impresion.Portada(zona, fecha)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(carpeta, "zona_"+descarga.NombreArchivo(zona.Nombre, "sin_zona")+".pdf")
+		out, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = impresion.Unir(out, portada, archivos)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("zona %s: %v", zona.Nombre, err)
+		}
+		fmt.Printf("Zona %s: %d facturas en %d rutas, total Bs %s -> %s\n", zona.Nombre, zona.Cantidad(), len(zona.Rutas), zona.Total(), path)
+	}
+
+	if len(resumen.Fallos) > 0 {
+		return fmt.Errorf("%d facturas no se pudieron descargar y faltan en los PDF", len(resumen.Fallos))
+	}
+	return nil
+}
+
+// generarLocal renders each invoice to the same path descarga.Descargar
+// would download it to, from the SIN XML of the emitted invoice.
+func generarLocal(ctx context.Context, builder *api.FacturacionElectronica, fe api.InvoiceClient, emision time.Time, facturas []db.FacturaOriginal, dir string, formato api.FormatoPdf) (*descarga.Resumen, error) {
+	resumen := &descarga.Resumen{Total: len(facturas)}
+	for i, f := range facturas {
+		if err := ctx.Err(); err != nil {
+			return resumen, err
+		}
+		fmt.Printf("\rGenerando factura %d/%d", i+1, len(facturas))
+
+		err := generarFactura(api.ConAbonado(ctx, f.Abonado), builder, fe, emision, f, dir, formato)
+		if err != nil {
+			resumen.Fallos = append(resumen.Fallos, descarga.Fallo{Abonado: f.Abonado, Cuf: f.CodigoControl, Err: err})
+			continue
+		}
+		resumen.Descargados++
+	}
+	return resumen, nil
+}
+
+// generarFactura renders the invoice from the XML saved by descargar -xml
+// or, if there is none, from the one the backend returns, so the PDF shows
+// what was sent and not the current row.
+func generarFactura(ctx context.Context, builder *api.FacturacionElectronica, fe api.InvoiceClient, emision time.Time, f db.FacturaOriginal, dir string, formato api.FormatoPdf) error {
+	ruta := descarga.Ruta(dir, emision, f.Zona, f.Abonado)
+	doc, err := os.ReadFile(ruta + ".xml")
+	if errors.Is(err, os.ErrNotExist) {
+		doc, err = fe.DescargarXML(ctx, f.CodigoControl)
+		if err == nil {
+			err = guardarArchivo(ruta+".xml", doc)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	request, err := builder.LeerFacturaXML(doc)
+	if err != nil {
+		return err
+	}
+	if request.Cabecera.Cuf != f.CodigoControl {
+		return fmt.Errorf("%s.xml es de la factura %s, no de %s", ruta, request.Cabecera.Cuf, f.CodigoControl)
+	}
+	pdf, err := representacion.Generar(request, f.CodigoControl, formato)
+	if err != nil {
+		return err
+	}
+	return guardarArchivo(ruta+".pdf", pdf)
+}
+
+func guardarArchivo(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"app/api"
+	"context"
+	"flag"
+	"fmt"
+)
+
+// runLeyendas downloads the SIN leyendas catalog for the emisor's activity
+// and saves it to the file loaded at startup (-leyendas):
+//
+//	facturacion.exe leyendas
+func runLeyendas(ctx context.Context, builder *api.FacturacionElectronica, fe api.InvoiceClient, path string, args []string) error {
+	fs := flag.NewFlagSet("leyendas", flag.ExitOnError)
+	actividad := fs.Int("actividad", builder.Emisor().CodigoActividad, "Código de actividad económica")
+	fs.Parse(args)
+
+	leyendas, err := fe.ListarLeyendas(ctx, *actividad)
+	if err != nil {
+		return fmt.Errorf("error al descargar las leyendas: %v", err)
+	}
+	if len(leyendas) == 0 {
+		return fmt.Errorf("el backend no devolvió leyendas para la actividad %d", *actividad)
+	}
+
+	catalogo := api.NewCatalogoLeyendas(leyendas)
+	if err := catalogo.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("%d leyendas de la actividad %d guardadas en %s\n", catalogo.Cantidad(*actividad), *actividad, path)
+	return nil
+}
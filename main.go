@@ -1,12 +1,32 @@
 package main
 
 import (
+	"app/api"
+	"app/contingencia"
+	"app/correo"
 	"app/db"
+	"app/flujo"
+	"app/mockapi"
 	"app/ui"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+// produccionApiUrl is the real invoicing backend, the default -apiUrl.
+const produccionApiUrl = "http://192.168.0.102:3001"
+
+const defaultApiKey = "8b6d1b35ea7998191033237d588abd859e24af22895e2ec7574c8748a3be2cdcf5153f9bfca1d617167c6128ecd2880e7225fa0c7ada6461ca55fc52daec0fe4e1acee0d380323fccdb67b8a1cbf40c4d2718988e5bf5f7d95f98733af5152b84f0ceb500359fe385916bc775323a2d154ff0acc694e4ce36d8b696eea07c1498e5c642440022eef8a954eeee90dfd8d0c1d5d935cc5a768e640dd1fc764726fb5f7fca2c8ccb238d381fe03c8cb89a75f61e3fe32e7a984a7e8470b795a4df3637edcd913bdce45304a62ed8bd8485147ce0bd29dcbd8a82276568497146a02f6536288c3bb1f01c5c328ad92fff30568a1781634f8ed6052340d082d04dd81b20ed6ea77a4cecf1ab66d96b6a97107"
+
 func main() {
 	// Define the default connection string
 	defaultConnString := "server=localhost;database=EMPSAAT;user id=sa;password=Anarkia41?!"
@@ -15,6 +35,64 @@ func main() {
 	// Define a flag for the connection string
 	connStringPtr := flag.String("connString", defaultConnString, "SQL Server connection string")
 
+	// Invoicing backend
+	apiUrl := flag.String("apiUrl", produccionApiUrl, "URL del backend de facturación")
+	apiKey := flag.String("apiKey", defaultApiKey, "api_key del backend de facturación")
+	retry := api.DefaultRetryPolicy()
+	flag.IntVar(&retry.MaxAttempts, "reintentos", retry.MaxAttempts, "Intentos por llamada a la API, incluido el primero")
+	flag.DurationVar(&retry.BaseDelay, "reintentoEspera", retry.BaseDelay, "Espera inicial entre reintentos (se duplica en cada intento)")
+	flag.DurationVar(&retry.MaxDelay, "reintentoMaximo", retry.MaxDelay, "Espera máxima entre reintentos")
+	connectTimeout := flag.Duration("timeoutConexion", 10*time.Second, "Tiempo máximo para conectar con el backend")
+	responseTimeout := flag.Duration("timeoutRespuesta", 60*time.Second, "Tiempo máximo de espera de la respuesta del backend")
+	requestTimeout := flag.Duration("timeoutSolicitud", 2*time.Minute, "Tiempo máximo por intento, incluida la descarga (0 sin límite)")
+
+	// Simulated backend for local testing and training
+	simular := flag.Bool("simular", false, "Usar un backend simulado en lugar del real")
+	simOpts := mockapi.Options{}
+	flag.DurationVar(&simOpts.Latency, "simularLatencia", 0, "Latencia de cada llamada simulada")
+	flag.Float64Var(&simOpts.ValidationErrorRate, "simularRechazos", 0, "Fracción de facturas rechazadas por validación (0-1)")
+	flag.Float64Var(&simOpts.OutageRate, "simularCaidas", 0, "Fracción de llamadas que fallan con 503 (0-1)")
+	flag.BoolVar(&simOpts.Offline, "simularSinRed", false, "Simular que el backend no es accesible")
+
+	// NIT verification against SIN, cached for the whole run
+	verificarNit := flag.Bool("verificarNit", false, "Verificar cada NIT con SIN antes de emitir (codigoExcepcion solo si no es válido)")
+
+	// Leyendas catalog synced with the leyendas command
+	leyendasPath := flag.String("leyendas", "leyendas.json", "Catálogo de leyendas de SIN (si no existe se usa el incluido)")
+	catalogosPath := flag.String("catalogos", "catalogos.json", "Catálogos paramétricos de SIN (si no existe se usan los incluidos)")
+
+	// Local XML validation against the SIN XSD before sending
+	validarXml := flag.Bool("validarXml", true, "Validar el XML de cada factura contra el XSD de SIN antes de enviarla")
+
+	// Audit log of every API call
+	auditoriaDir := flag.String("auditoria", "auditoria", "Directorio de la auditoría de llamadas a la API (vacío: no auditar)")
+	auditoriaDias := flag.Int("auditoriaRetencion", 0, "Días que se conservan los archivos de auditoría (0: siempre)")
+
+	// Rate and adaptive concurrency of the emission
+	flujoOpts := flujo.DefaultOpciones()
+	flag.Float64Var(&flujoOpts.PorSegundo, "tasa", flujoOpts.PorSegundo, "Máximo de facturas enviadas por segundo (0 sin límite)")
+	flag.IntVar(&flujoOpts.Min, "concurrenciaMin", flujoOpts.Min, "Mínimo de envíos simultáneos")
+	flag.IntVar(&flujoOpts.Max, "concurrenciaMax", flujoOpts.Max, "Máximo de envíos simultáneos")
+	flag.IntVar(&flujoOpts.Inicial, "concurrenciaInicial", flujoOpts.Inicial, "Envíos simultáneos al empezar; sube si el backend responde bien")
+
+	// Offline contingency queue
+	contingenciaDir := flag.String("contingencia", "contingencia", "Directorio de facturas emitidas fuera de línea")
+	cufdPath := flag.String("cufd", "cufd.json", "CUFD vigente para armar el CUF de las facturas fuera de línea (si no existe las arma el backend)")
+
+	// Email delivery of the emitted invoices; disabled without -smtpHost
+	smtpConfig := correo.Config{}
+	flag.StringVar(&smtpConfig.Host, "smtpHost", "", "Servidor SMTP para enviar las facturas por correo (vacío: no enviar)")
+	flag.IntVar(&smtpConfig.Port, "smtpPuerto", 25, "Puerto del servidor SMTP")
+	flag.StringVar(&smtpConfig.Usuario, "smtpUsuario", "", "Usuario SMTP (vacío: sin autenticación)")
+	flag.StringVar(&smtpConfig.Remitente, "smtpRemitente", "", "Dirección del remitente")
+	flag.IntVar(&smtpConfig.Reintentos, "smtpReintentos", 3, "Intentos por correo")
+	correoBitacora := flag.String("correoBitacora", filepath.Join("correos", "entregas.jsonl"), "Bitácora de correos enviados")
+
+	// Emisor profile: file, then EMISOR_* env vars, then these flags
+	emisorPath := flag.String("emisor", "", "Archivo JSON con el perfil del emisor")
+	emisorFlags := emisorFlagSet{}
+	emisorFlags.register(flag.CommandLine)
+
 	// Parse the command-line flags
 	flag.Parse()
 
@@ -23,9 +101,242 @@ func main() {
 
 	fmt.Printf("Using connection string: %s\n", connString)
 
+	// A simulated backend hands out made-up CUFs; they must not reach the
+	// production database. The production backend is never probed.
+	if *simular || (!esProduccion(*apiUrl) && backendSimulado(*apiUrl)) {
+		if err := exigirBaseDePractica(connString, defaultConnString); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	emisor, err := loadEmisor(*emisorPath, emisorFlags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Emisor: %s (NIT %d)\n", emisor.RazonSocial, emisor.Nit)
+
 	// Initialize the database connection
 	db.InitDB(connString)
+	if faltantes, err := db.ColumnasFaltantes(); err != nil {
+		log.Println("Error checking database schema:", err)
+	} else if len(faltantes) > 0 {
+		log.Fatalf("Faltan columnas en la base de datos: %s. Aplique los ALTER TABLE del readme antes de usar el programa", strings.Join(faltantes, ", "))
+	}
+
+	apiConfig := api.ApiConfig{
+		Url:    *apiUrl,
+		ApiKey: *apiKey,
+		Retry:  retry,
+
+		ConnectTimeout:  *connectTimeout,
+		ResponseTimeout: *responseTimeout,
+		RequestTimeout:  *requestTimeout,
+	}
+	cola := contingencia.NewCola(*contingenciaDir)
+
+	fe := api.NewFacturacionElectronica(apiConfig, emisor)
+	fe.SetValidarXML(*validarXml)
+	var client api.InvoiceClient = fe
+	if *auditoriaDir != "" {
+		auditoria := api.NewAuditoria(*auditoriaDir, time.Duration(*auditoriaDias)*24*time.Hour)
+		fe.SetAuditoria(auditoria)
+		fmt.Printf("Auditoría: %s (corrida %s)\n", *auditoriaDir, auditoria.Corrida())
+	}
+	if catalogo, err := api.LoadCatalogoLeyendas(*leyendasPath); err == nil {
+		fe.SetLeyendas(catalogo)
+		fmt.Printf("Leyendas: %d del catálogo %s\n", catalogo.Cantidad(emisor.CodigoActividad), *leyendasPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	if catalogos, err := api.LoadCatalogos(*catalogosPath); err == nil {
+		fe.SetCatalogos(catalogos)
+		fmt.Printf("Catálogos: %s\n", *catalogosPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	if cufd, err := api.LoadCufd(*cufdPath); err == nil {
+		fe.SetCufd(cufd)
+		fmt.Printf("CUFD: %s\n", *cufdPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	if *simular {
+		fmt.Println("Usando backend simulado")
+		client = mockapi.NewClient(simOpts)
+		if _, err := os.Stat(*cufdPath); err != nil {
+			fe.SetCufd(&mockapi.CufdSimulado)
+		}
+	}
+
+	var verificadorNit *api.VerificadorNit
+	if *verificarNit {
+		verificadorNit = api.NewVerificadorNit(client, 24*time.Hour)
+	}
+
+	var enviador *correo.Enviador
+	if smtpConfig.Host != "" {
+		smtpConfig.Password = os.Getenv("SMTP_PASSWORD")
+		enviador = correo.NewEnviador(smtpConfig, emisor.RazonSocial, client, correo.NewBitacora(*correoBitacora))
+	}
+
+	// Subcommands run without the UI and stop on Ctrl+C
+	if flag.NArg() > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := runCommand(ctx, flag.Args(), fe, client, cola, enviador, *leyendasPath, *catalogosPath)
+		stop()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	// Set up the UI
-	ui.SetupUI()
-}
\ No newline at end of file
+	ui.SetupUI(ui.Config{
+		Api:            apiConfig,
+		Emisor:         emisor,
+		Builder:        fe,
+		Client:         client,
+		Contingencia:   cola,
+		Correo:         enviador,
+		VerificadorNit: verificadorNit,
+		Flujo:          flujoOpts,
+	})
+}
+
+func runCommand(ctx context.Context, args []string, builder *api.FacturacionElectronica, fe api.InvoiceClient, cola *contingencia.Cola, enviador *correo.Enviador, leyendasPath, catalogosPath string) error {
+	switch args[0] {
+	case "anular":
+		return runAnular(ctx, fe, args[1:])
+	case "verificar":
+		return runVerificar(ctx, fe, args[1:])
+	case "contingencia":
+		return runContingencia(ctx, fe, cola, args[1:])
+	case "nota":
+		return runNota(ctx, builder, fe, args[1:])
+	case "descargar":
+		return runDescargar(ctx, fe, args[1:])
+	case "imprimir":
+		return runImprimir(ctx, builder, fe, args[1:])
+	case "correo":
+		return runCorreo(ctx, enviador, args[1:])
+	case "leyendas":
+		return runLeyendas(ctx, builder, fe, leyendasPath, args[1:])
+	case "catalogos":
+		return runCatalogos(ctx, builder, fe, catalogosPath, args[1:])
+	}
+	return fmt.Errorf("comando desconocido: %s (disponibles: anular, verificar, contingencia, nota, descargar, imprimir, correo, leyendas, catalogos)", args[0])
+}
+
+// exigirBaseDePractica rejects a connection string that points to the
+// production database, or that does not name a database at all.
+func exigirBaseDePractica(connString, produccion string) error {
+	base := baseDeDatos(connString)
+	if base == "" || strings.EqualFold(base, baseDeDatos(produccion)) {
+		return fmt.Errorf("con un backend simulado se requiere -connString con una base de datos de práctica distinta de %s", baseDeDatos(produccion))
+	}
+	return nil
+}
+
+// esProduccion reports whether apiUrl is the production backend.
+func esProduccion(apiUrl string) bool {
+	return strings.TrimRight(apiUrl, "/") == produccionApiUrl
+}
+
+// backendSimulado reports whether apiUrl is a cmd/mockserver, which is the
+// only backend that serves /mock/opciones.
+func backendSimulado(apiUrl string) bool {
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(strings.TrimRight(apiUrl, "/") + "/mock/opciones")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// baseDeDatos returns the database named in a SQL Server connection string,
+// in either the key=value or the sqlserver:// URL form.
+func baseDeDatos(connString string) string {
+	if u, err := url.Parse(connString); err == nil && u.Scheme == "sqlserver" {
+		return u.Query().Get("database")
+	}
+	for _, parte := range strings.Split(connString, ";") {
+		clave, valor, _ := strings.Cut(parte, "=")
+		switch strings.ToLower(strings.TrimSpace(clave)) {
+		case "database", "initial catalog":
+			return strings.TrimSpace(valor)
+		}
+	}
+	return ""
+}
+
+// emisorFlagSet holds the command-line overrides for the emisor profile.
+type emisorFlagSet struct {
+	nit         int64
+	razonSocial string
+	municipio   string
+	ciudad      string
+	telefono    string
+	direccion   string
+	sucursal    int
+	puntoVenta  int
+	ambiente    int
+	usuario     string
+}
+
+func (f *emisorFlagSet) register(fs *flag.FlagSet) {
+	fs.Int64Var(&f.nit, "nit", 0, "NIT del emisor")
+	fs.StringVar(&f.razonSocial, "razonSocial", "", "Razón social del emisor")
+	fs.StringVar(&f.municipio, "municipio", "", "Municipio del emisor")
+	fs.StringVar(&f.ciudad, "ciudad", "", "Ciudad impresa en la factura")
+	fs.StringVar(&f.telefono, "telefono", "", "Teléfono del emisor")
+	fs.StringVar(&f.direccion, "direccion", "", "Dirección del emisor")
+	fs.IntVar(&f.sucursal, "sucursal", 0, "Código de sucursal")
+	fs.IntVar(&f.puntoVenta, "puntoVenta", 0, "Código de punto de venta")
+	fs.IntVar(&f.ambiente, "ambiente", 0, "Código de ambiente (1 producción, 2 pruebas)")
+	fs.StringVar(&f.usuario, "usuario", "", "Usuario que emite las facturas")
+}
+
+// apply copies only the flags that were explicitly set on the command line.
+func (f *emisorFlagSet) apply(fs *flag.FlagSet, profile *api.EmisorProfile) {
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "nit":
+			profile.Nit = f.nit
+		case "razonSocial":
+			profile.RazonSocial = f.razonSocial
+		case "municipio":
+			profile.Municipio = f.municipio
+		case "ciudad":
+			profile.Ciudad = f.ciudad
+		case "telefono":
+			profile.Telefono = f.telefono
+		case "direccion":
+			profile.Direccion = f.direccion
+		case "sucursal":
+			profile.CodigoSucursal = f.sucursal
+		case "puntoVenta":
+			profile.CodigoPuntoVenta = f.puntoVenta
+		case "ambiente":
+			profile.CodigoAmbiente = f.ambiente
+		case "usuario":
+			profile.Usuario = f.usuario
+		}
+	})
+}
+
+func loadEmisor(path string, overrides emisorFlagSet) (api.EmisorProfile, error) {
+	emisor := api.DefaultEmisorProfile()
+	if path != "" {
+		var err error
+		emisor, err = api.LoadEmisorProfile(path)
+		if err != nil {
+			return emisor, err
+		}
+	}
+	if err := emisor.ApplyEnv(); err != nil {
+		return emisor, err
+	}
+	overrides.apply(flag.CommandLine, &emisor)
+	return emisor, nil
+}
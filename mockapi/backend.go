@@ -0,0 +1,657 @@
+// Package mockapi simula el backend de facturación (invoice-utils) para
+// probar la facturación masiva sin el servidor real: Client es un
+// api.InvoiceClient en proceso y NewHandler/NewServer exponen el mismo
+// comportamiento por HTTP.
+package mockapi
+
+import (
+	"app/api"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options controla el comportamiento simulado.
+type Options struct {
+	// Latency es la demora de cada llamada; LatencyJitter agrega hasta esa
+	// cantidad al azar.
+	Latency       time.Duration
+	LatencyJitter time.Duration
+	// ValidationErrorRate es la fracción de facturas rechazadas con 400.
+	ValidationErrorRate float64
+	// OutageRate es la fracción de llamadas que fallan con 503.
+	OutageRate float64
+	// Offline hace que todas las llamadas fallen como si no hubiera red.
+	Offline bool
+	// ApiKey, si no está vacía, se exige en la cabecera api_key.
+	ApiKey string
+	Seed   int64
+}
+
+// Factura es una factura registrada en el backend simulado.
+type Factura struct {
+	Request api.FacturaRequest `json:"request"`
+	// Nota reemplaza a Request cuando el documento es una nota de
+	// crédito-débito.
+	Nota          *api.NotaCreditoDebitoRequest `json:"nota,omitempty"`
+	Cuf           string                        `json:"cuf"`
+	NumeroFactura int                           `json:"numeroFactura"`
+	Estado        api.EstadoFactura             `json:"estado"`
+	Fecha         string                        `json:"fecha"`
+	Motivo        api.MotivoAnulacion           `json:"motivoAnulacion,omitempty"`
+}
+
+// Backend guarda las facturas en memoria y aplica las fallas configuradas.
+type Backend struct {
+	mu       sync.Mutex
+	opts     Options
+	rand     *rand.Rand
+	facturas map[string]*Factura
+	numeros  map[int]string
+	// solicitudes guarda el cuf creado con cada X-Request-Id
+	solicitudes map[string]string
+	ultimo      int
+	notas       int
+	eventos     int
+	cambios     int
+}
+
+func NewBackend(opts Options) *Backend {
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Backend{
+		opts:     opts,
+		rand:     rand.New(rand.NewSource(seed)),
+		facturas: map[string]*Factura{},
+		numeros:  map[int]string{},
+
+		solicitudes: map[string]string{},
+	}
+}
+
+// SetOptions cambia el comportamiento simulado en caliente, por ejemplo
+// para simular que el servicio vuelve después de una caída.
+func (b *Backend) SetOptions(opts Options) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.opts = opts
+}
+
+func (b *Backend) Options() Options {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.opts
+}
+
+// Facturas devuelve una copia de las facturas registradas.
+func (b *Backend) Facturas() []Factura {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	facturas := make([]Factura, 0, len(b.facturas))
+	for _, f := range b.facturas {
+		facturas = append(facturas, *f)
+	}
+	return facturas
+}
+
+// errOffline simula una falla de red; api.IsOutage lo trata como caída.
+var errOffline = errors.New("mockapi: conexión rechazada (modo sin red)")
+
+// esperar aplica la latencia configurada y las fallas de red o de
+// disponibilidad.
+func (b *Backend) esperar(ctx context.Context, op string) error {
+	b.mu.Lock()
+	opts := b.opts
+	delay := opts.Latency
+	if opts.LatencyJitter > 0 {
+		delay += time.Duration(b.rand.Int63n(int64(opts.LatencyJitter)))
+	}
+	outage := opts.OutageRate > 0 && b.rand.Float64() < opts.OutageRate
+	b.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if opts.Offline {
+		return &url.Error{Op: op, URL: "mockapi", Err: errOffline}
+	}
+	if outage {
+		return &api.APIError{
+			StatusCode: http.StatusServiceUnavailable,
+			Code:       "Service Unavailable",
+			Messages:   []string{"servicio de impuestos no disponible (simulado)"},
+		}
+	}
+	return nil
+}
+
+func validationError(messages ...string) *api.APIError {
+	return &api.APIError{
+		StatusCode: http.StatusBadRequest,
+		Code:       "Bad Request",
+		Messages:   messages,
+	}
+}
+
+// Crear registra una factura como lo hace third-party-create.
+func (b *Backend) Crear(req api.FacturaRequest) (*api.FacturaResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if problemas := validar(req); len(problemas) > 0 {
+		return nil, validationError(problemas...)
+	}
+	if b.opts.ValidationErrorRate > 0 && b.rand.Float64() < b.opts.ValidationErrorRate {
+		return nil, validationError("NIT/CI del cliente inválido (simulado)")
+	}
+
+	numero := req.Cabecera.NumeroFactura
+	if numero == 0 {
+		numero = b.ultimo + 1
+	}
+	if cuf, ok := b.numeros[numero]; ok {
+		return nil, &api.APIError{
+			StatusCode: http.StatusConflict,
+			Code:       "Conflict",
+			Messages:   []string{fmt.Sprintf("la factura %d ya fue emitida con cuf %s", numero, cuf)},
+		}
+	}
+	if numero > b.ultimo {
+		b.ultimo = numero
+	}
+
+	factura := &Factura{
+		Request:       req,
+		NumeroFactura: numero,
+		Estado:        api.EstadoValidada,
+		Fecha:         ifEmpty(req.Cabecera.FechaEmision, time.Now().Format("2006-01-02T15:04:05.000")),
+	}
+	// las facturas fuera de línea pueden traer el cuf armado por el emisor
+	factura.Cuf = req.Cabecera.Cuf
+	if factura.Cuf == "" || req.Solicitud.CodigoEmision == api.EmisionEnLinea {
+		datos, err := api.DatosCUFDeFactura(req)
+		if err != nil {
+			datos = api.DatosCUF{Nit: req.Cabecera.NitEmisor, FechaHora: time.Now(), Modalidad: req.Solicitud.CodigoModalidad,
+				TipoEmision: req.Solicitud.CodigoEmision, TipoFactura: api.TipoFacturaCreditoFiscal, DocumentoSector: req.Cabecera.CodigoDocumentoSector}
+		}
+		datos.Numero = numero
+		factura.Cuf = b.nuevoCuf(datos)
+	}
+	factura.Request.Cabecera.NumeroFactura = numero
+	factura.Request.Cabecera.Cuf = factura.Cuf
+	if req.Solicitud.CodigoEmision == api.EmisionFueraDeLinea {
+		factura.Estado = api.EstadoPendiente
+	}
+	b.facturas[factura.Cuf] = factura
+	b.numeros[numero] = factura.Cuf
+	b.cambios++
+
+	return factura.response(), nil
+}
+
+// CrearNota registra una nota de crédito-débito sobre una factura vigente.
+// Las notas tienen su propia numeración.
+func (b *Backend) CrearNota(req api.NotaCreditoDebitoRequest) (*api.FacturaResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := req.Cabecera
+	original, ok := b.facturas[c.NumeroAutorizacionCuf]
+	if !ok || original.Nota != nil {
+		return nil, validationError(fmt.Sprintf("numeroAutorizacionCuf %s no corresponde a una factura emitida", c.NumeroAutorizacionCuf))
+	}
+	var problemas []string
+	if original.Estado == api.EstadoAnulada {
+		problemas = append(problemas, "la factura original está anulada")
+	}
+	if c.NumeroFactura != original.NumeroFactura {
+		problemas = append(problemas, fmt.Sprintf("numeroFactura %d no coincide con la factura original %d", c.NumeroFactura, original.NumeroFactura))
+	}
+	if c.MontoTotalOriginal != original.Request.Cabecera.MontoTotal {
+		problemas = append(problemas, fmt.Sprintf("montoTotalOriginal %s no coincide con la factura original %s", c.MontoTotalOriginal, original.Request.Cabecera.MontoTotal))
+	}
+	if c.MontoTotalDevuelto <= 0 || c.MontoTotalDevuelto > c.MontoTotalOriginal {
+		problemas = append(problemas, "montoTotalDevuelto debe ser mayor a cero y no superar montoTotalOriginal")
+	}
+	if len(problemas) > 0 {
+		return nil, validationError(problemas...)
+	}
+
+	numero := c.NumeroNotaCreditoDebito
+	if numero == 0 {
+		numero = b.notas + 1
+	}
+	if numero > b.notas {
+		b.notas = numero
+	}
+
+	nota := &Factura{
+		Nota: &req,
+		Cuf: b.nuevoCuf(api.DatosCUF{
+			Nit:             c.NitEmisor,
+			FechaHora:       time.Now(),
+			Sucursal:        c.CodigoSucursal,
+			Modalidad:       req.Solicitud.CodigoModalidad,
+			TipoEmision:     api.EmisionEnLinea,
+			TipoFactura:     api.TipoDocumentoAjuste,
+			DocumentoSector: api.SectorNotaCreditoDebito,
+			Numero:          numero,
+			PuntoVenta:      c.CodigoPuntoVenta,
+		}),
+		NumeroFactura: numero,
+		Estado:        api.EstadoValidada,
+		Fecha:         ifEmpty(c.FechaEmision, time.Now().Format("2006-01-02T15:04:05.000")),
+	}
+	nota.Nota.Cabecera.NumeroNotaCreditoDebito = numero
+	nota.Nota.Cabecera.Cuf = nota.Cuf
+	b.facturas[nota.Cuf] = nota
+	b.cambios++
+
+	return nota.response(), nil
+}
+
+func (f *Factura) codigoCliente() string {
+	if f.Nota != nil {
+		return f.Nota.Cabecera.CodigoCliente
+	}
+	return f.Request.Cabecera.CodigoCliente
+}
+
+func (f *Factura) response() *api.FacturaResponse {
+	return &api.FacturaResponse{
+		Cuf:           f.Cuf,
+		NumeroFactura: f.NumeroFactura,
+		Estado:        string(f.Estado),
+		Fecha:         f.Fecha,
+		Urls: map[string]string{
+			"pdf": "/api/v1/invoice-utils/pdf?cuf=" + f.Cuf,
+		},
+		Observaciones: []string{},
+	}
+}
+
+// validar aplica las reglas mínimas que el backend real rechaza con 400.
+func validar(req api.FacturaRequest) []string {
+	var problemas []string
+	if strings.TrimSpace(req.Cabecera.NumeroDocumento) == "" {
+		problemas = append(problemas, "numeroDocumento es requerido")
+	}
+	if strings.TrimSpace(req.Cabecera.NombreRazonSocial) == "" {
+		problemas = append(problemas, "nombreRazonSocial es requerido")
+	}
+	if req.Cabecera.MontoTotal <= 0 {
+		problemas = append(problemas, "montoTotal debe ser mayor a cero")
+	}
+	if len(req.Detalle) == 0 {
+		problemas = append(problemas, "la factura no tiene detalle")
+	}
+	if api.TipoDocumento(req.Cabecera.CodigoTipoDocumentoIdentidad) == api.DocumentoNIT &&
+		req.Cabecera.CodigoExcepcion != 1 && !api.ValidarNIT(req.Cabecera.NumeroDocumento) {
+		problemas = append(problemas, "NIT inválido; use codigoExcepcion 1 para emitir igual")
+	}
+	return problemas
+}
+
+// Leyendas devuelve el catálogo incluido en el paquete api; otras
+// actividades no tienen leyendas.
+func (b *Backend) Leyendas(actividad int) []api.Leyenda {
+	catalogo := api.DefaultCatalogoLeyendas()
+	leyendas := []api.Leyenda{}
+	for _, l := range catalogo.Leyendas() {
+		if l.CodigoActividad == actividad {
+			leyendas = append(leyendas, l)
+		}
+	}
+	return leyendas
+}
+
+// Catalogo devuelve los catálogos incluidos en el paquete api.
+func (b *Backend) Catalogo(tipo api.TipoCatalogo) []api.ItemCatalogo {
+	items := api.DefaultCatalogos().Items(tipo)
+	if items == nil {
+		items = []api.ItemCatalogo{}
+	}
+	return items
+}
+
+// VerificarNit acepta los NIT con dígito verificador correcto.
+func (b *Backend) VerificarNit(nit string) *api.VerificacionNit {
+	if api.ValidarNIT(nit) {
+		return &api.VerificacionNit{Nit: nit, Valido: true, Codigo: 986, Descripcion: "NIT ACTIVO"}
+	}
+	return &api.VerificacionNit{Nit: nit, Valido: false, Codigo: 994, Descripcion: "NIT INEXISTENTE"}
+}
+
+// CufdSimulado es el CUFD con el que el backend simulado arma los CUF.
+var CufdSimulado = api.Cufd{Codigo: "CUFDSIMULADO", CodigoControl: "A19E95B0C3DAB92"}
+
+// nuevoCuf arma el CUF como SIN; si los datos no alcanzan devuelve uno al
+// azar.
+func (b *Backend) nuevoCuf(datos api.DatosCUF) string {
+	if cuf, err := api.GenerarCUF(datos, CufdSimulado.CodigoControl); err == nil {
+		if _, existe := b.facturas[cuf]; !existe {
+			return cuf
+		}
+	}
+	buf := make([]byte, 24)
+	b.rand.Read(buf)
+	return fmt.Sprintf("%X", buf)
+}
+
+// Estado devuelve el estado de la factura o 404.
+func (b *Backend) Estado(cuf string) (*api.EstadoFacturaResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	factura, ok := b.facturas[cuf]
+	if !ok {
+		return nil, &api.APIError{
+			StatusCode: http.StatusNotFound,
+			Code:       "Not Found",
+			Messages:   []string{"factura no encontrada"},
+		}
+	}
+	return factura.estado(), nil
+}
+
+// RegistrarSolicitud asocia el X-Request-Id de un envío con el cuf creado.
+func (b *Backend) RegistrarSolicitud(requestID, cuf string) {
+	if requestID == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.solicitudes[requestID] = cuf
+}
+
+// Buscar encuentra una factura por el X-Request-Id con que se envió o, si
+// requestID está vacío, por su número y documento sector, como
+// invoice-utils/status sin cuf.
+func (b *Backend) Buscar(requestID string, numero, sector int) (*api.EstadoFacturaResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if requestID != "" {
+		if factura, ok := b.facturas[b.solicitudes[requestID]]; ok {
+			estado := factura.estado()
+			estado.RequestID = requestID
+			return estado, nil
+		}
+	} else {
+		for _, factura := range b.facturas {
+			nota := factura.Nota != nil
+			if factura.NumeroFactura == numero && nota == (sector == api.SectorNotaCreditoDebito) {
+				return factura.estado(), nil
+			}
+		}
+	}
+	return nil, &api.APIError{
+		StatusCode: http.StatusNotFound,
+		Code:       "Not Found",
+		Messages:   []string{"factura no encontrada"},
+	}
+}
+
+func (f *Factura) estado() *api.EstadoFacturaResponse {
+	return &api.EstadoFacturaResponse{
+		Cuf:           f.Cuf,
+		NumeroFactura: f.NumeroFactura,
+		CodigoCliente: f.codigoCliente(),
+		Estado:        f.Estado,
+		Fecha:         f.Fecha,
+		Observaciones: []string{},
+	}
+}
+
+func (b *Backend) Anular(cuf string, motivo api.MotivoAnulacion) (*api.AnulacionResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	factura, ok := b.facturas[cuf]
+	if !ok {
+		return nil, &api.APIError{
+			StatusCode: http.StatusNotFound,
+			Code:       "Not Found",
+			Messages:   []string{"factura no encontrada"},
+		}
+	}
+	if factura.Estado == api.EstadoAnulada {
+		return nil, validationError("la factura ya está anulada")
+	}
+	factura.Estado = api.EstadoAnulada
+	factura.Motivo = motivo
+	b.cambios++
+	return &api.AnulacionResponse{
+		Cuf:     cuf,
+		Estado:  string(factura.Estado),
+		Fecha:   time.Now().Format("2006-01-02T15:04:05.000"),
+		Mensaje: motivo.String(),
+	}, nil
+}
+
+func (b *Backend) RegistrarEvento(motivo api.EventoSignificativo) (*api.EventoResponse, error) {
+	if _, ok := api.EventosSignificativos[motivo]; !ok {
+		return nil, validationError(fmt.Sprintf("evento significativo inválido: %d", motivo))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.eventos++
+	b.cambios++
+	return &api.EventoResponse{CodigoRecepcionEvento: fmt.Sprintf("EV%06d", b.eventos)}, nil
+}
+
+// Paquete registra las facturas de contingencia una por una; las
+// rechazadas vuelven sin cuf y con sus observaciones.
+func (b *Backend) Paquete(codigoEvento string, facturas []api.FacturaRequest) (*api.PaqueteResponse, error) {
+	if codigoEvento == "" {
+		return nil, validationError("codigoRecepcionEventoSignificativo es requerido")
+	}
+
+	result := &api.PaqueteResponse{
+		CodigoRecepcion: fmt.Sprintf("PQ-%s", codigoEvento),
+		Estado:          "PROCESADO",
+	}
+	for _, req := range facturas {
+		resp, err := b.Crear(req)
+		if err != nil {
+			var apiErr *api.APIError
+			errors.As(err, &apiErr)
+			result.Facturas = append(result.Facturas, api.FacturaResponse{
+				NumeroFactura: req.Cabecera.NumeroFactura,
+				Estado:        string(api.EstadoRechazada),
+				Observaciones: apiErr.Messages,
+			})
+			continue
+		}
+		result.Facturas = append(result.Facturas, *resp)
+	}
+	return result, nil
+}
+
+// PDF genera una representación gráfica de prueba.
+func (b *Backend) PDF(cuf string, formato api.FormatoPdf) ([]byte, error) {
+	factura, err := b.buscar(cuf)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := factura.Nota; n != nil {
+		return placeholderPDF(formato, []string{
+			fmt.Sprintf("%s - NIT %d", n.Cabecera.RazonSocialEmisor, n.Cabecera.NitEmisor),
+			fmt.Sprintf("NOTA DE CREDITO-DEBITO N. %d", factura.NumeroFactura),
+			fmt.Sprintf("CUF: %s", factura.Cuf),
+			fmt.Sprintf("Fecha: %s", factura.Fecha),
+			fmt.Sprintf("Factura original N. %d - CUF %s", n.Cabecera.NumeroFactura, n.Cabecera.NumeroAutorizacionCuf),
+			fmt.Sprintf("Cliente: %s (%s)", n.Cabecera.NombreRazonSocial, n.Cabecera.NumeroDocumento),
+			fmt.Sprintf("Monto devuelto Bs: %s", n.Cabecera.MontoTotalDevuelto),
+			fmt.Sprintf("Credito fiscal Bs: %s", n.Cabecera.MontoEfectivoCreditoDebito),
+			"DOCUMENTO DE PRUEBA - SIN VALIDEZ FISCAL",
+		}), nil
+	}
+
+	c := factura.Request.Cabecera
+	return placeholderPDF(formato, []string{
+		fmt.Sprintf("%s - NIT %d", c.RazonSocialEmisor, c.NitEmisor),
+		fmt.Sprintf("FACTURA N. %d", factura.NumeroFactura),
+		fmt.Sprintf("CUF: %s", factura.Cuf),
+		fmt.Sprintf("Fecha: %s", factura.Fecha),
+		fmt.Sprintf("Cliente: %s (%s)", c.NombreRazonSocial, c.NumeroDocumento),
+		fmt.Sprintf("Codigo cliente: %s", c.CodigoCliente),
+		fmt.Sprintf("Total Bs: %s", c.MontoTotal),
+		fmt.Sprintf("Estado: %s", factura.Estado),
+		"DOCUMENTO DE PRUEBA - SIN VALIDEZ FISCAL",
+	}), nil
+}
+
+// XML genera el XML de SIN de las facturas con api.GenerarXML; para las
+// notas, uno de prueba con los datos principales.
+func (b *Backend) XML(cuf string) ([]byte, error) {
+	factura, err := b.buscar(cuf)
+	if err != nil {
+		return nil, err
+	}
+
+	if factura.Nota == nil {
+		req := factura.Request
+		req.Cabecera.Cuf = factura.Cuf
+		req.Cabecera.Cufd = ifEmpty(req.Cabecera.Cufd, CufdSimulado.Codigo)
+		req.Cabecera.NumeroFactura = factura.NumeroFactura
+		if doc, err := api.GenerarXML(req); err == nil {
+			return doc, nil
+		}
+	}
+
+	doc := xmlDocumento{XMLName: xml.Name{Local: "facturaElectronicaServicioBasico"}}
+	if n := factura.Nota; n != nil {
+		doc.XMLName.Local = "notaFiscalElectronicaCreditoDebito"
+		doc.NitEmisor = n.Cabecera.NitEmisor
+		doc.CodigoCliente = n.Cabecera.CodigoCliente
+		doc.NombreRazonSocial = n.Cabecera.NombreRazonSocial
+		doc.MontoTotal = n.Cabecera.MontoTotalDevuelto.String()
+	} else {
+		c := factura.Request.Cabecera
+		if c.CodigoDocumentoSector == api.SectorCompraVenta {
+			doc.XMLName.Local = "facturaElectronicaCompraVenta"
+		}
+		doc.NitEmisor = c.NitEmisor
+		doc.CodigoCliente = c.CodigoCliente
+		doc.NombreRazonSocial = c.NombreRazonSocial
+		doc.MontoTotal = c.MontoTotal.String()
+	}
+	doc.NumeroFactura = factura.NumeroFactura
+	doc.Cuf = factura.Cuf
+	doc.FechaEmision = factura.Fecha
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+type xmlDocumento struct {
+	XMLName           xml.Name
+	NitEmisor         int64  `xml:"cabecera>nitEmisor"`
+	NumeroFactura     int    `xml:"cabecera>numeroFactura"`
+	Cuf               string `xml:"cabecera>cuf"`
+	FechaEmision      string `xml:"cabecera>fechaEmision"`
+	NombreRazonSocial string `xml:"cabecera>nombreRazonSocial"`
+	CodigoCliente     string `xml:"cabecera>codigoCliente"`
+	MontoTotal        string `xml:"cabecera>montoTotal"`
+}
+
+func (b *Backend) buscar(cuf string) (*Factura, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	factura, ok := b.facturas[cuf]
+	if !ok {
+		return nil, &api.APIError{
+			StatusCode: http.StatusNotFound,
+			Code:       "Not Found",
+			Messages:   []string{"factura no encontrada"},
+		}
+	}
+	return factura, nil
+}
+
+func ifEmpty(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// snapshot es el formato en que se guarda el backend en disco.
+type snapshot struct {
+	Facturas []Factura `json:"facturas"`
+	Ultimo   int       `json:"ultimo"`
+	Notas    int       `json:"notas"`
+	Eventos  int       `json:"eventos"`
+}
+
+// Save escribe las facturas registradas como JSON.
+func (b *Backend) Save(w io.Writer) error {
+	b.mu.Lock()
+	snap := snapshot{Ultimo: b.ultimo, Notas: b.notas, Eventos: b.eventos}
+	for _, f := range b.facturas {
+		snap.Facturas = append(snap.Facturas, *f)
+	}
+	b.mu.Unlock()
+
+	sort.Slice(snap.Facturas, func(i, j int) bool {
+		return snap.Facturas[i].NumeroFactura < snap.Facturas[j].NumeroFactura
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snap)
+}
+
+// Load reemplaza el contenido del backend con lo guardado por Save.
+func (b *Backend) Load(r io.Reader) error {
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("error al leer datos del backend simulado: %v", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.facturas = map[string]*Factura{}
+	b.numeros = map[int]string{}
+	for i := range snap.Facturas {
+		f := snap.Facturas[i]
+		b.facturas[f.Cuf] = &f
+		if f.Nota == nil {
+			b.numeros[f.NumeroFactura] = f.Cuf
+		}
+	}
+	b.ultimo = snap.Ultimo
+	b.notas = snap.Notas
+	b.eventos = snap.Eventos
+	b.cambios++
+	return nil
+}
+
+// Cambios cuenta las modificaciones, para guardar solo cuando hay algo nuevo.
+func (b *Backend) Cambios() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.cambios
+}
@@ -0,0 +1,131 @@
+package mockapi
+
+import (
+	"app/api"
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Client implementa api.InvoiceClient sobre un Backend en proceso, sin
+// HTTP ni reintentos: cada falla simulada llega tal cual al llamador.
+type Client struct {
+	backend *Backend
+	// Dir es el directorio donde GetFile guarda los PDF.
+	Dir string
+}
+
+var _ api.InvoiceClient = (*Client)(nil)
+
+func NewClient(opts Options) *Client {
+	return &Client{backend: NewBackend(opts), Dir: "./facturas"}
+}
+
+func (c *Client) Backend() *Backend {
+	return c.backend
+}
+
+func (c *Client) EnviarFactura(ctx context.Context, facturaRequest api.FacturaRequest) (*api.FacturaResponse, error) {
+	if err := c.backend.esperar(ctx, "Post"); err != nil {
+		return nil, err
+	}
+	return c.backend.Crear(facturaRequest)
+}
+
+func (c *Client) EnviarNotaCreditoDebito(ctx context.Context, notaRequest api.NotaCreditoDebitoRequest) (*api.FacturaResponse, error) {
+	if err := c.backend.esperar(ctx, "Post"); err != nil {
+		return nil, err
+	}
+	return c.backend.CrearNota(notaRequest)
+}
+
+func (c *Client) GetFile(ctx context.Context, cuf string, abonado int) (string, error) {
+	if err := c.backend.esperar(ctx, "Get"); err != nil {
+		return "", err
+	}
+	pdf, err := c.backend.PDF(cuf, api.FormatoCarta)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return "", err
+	}
+	tempFile, err := os.CreateTemp(c.Dir, fmt.Sprintf("factura_abonado_%d_*.pdf", abonado))
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+
+	if _, err := tempFile.Write(pdf); err != nil {
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
+
+func (c *Client) DescargarPDF(ctx context.Context, cuf string, formato api.FormatoPdf) ([]byte, error) {
+	if err := c.backend.esperar(ctx, "Get"); err != nil {
+		return nil, err
+	}
+	return c.backend.PDF(cuf, formato)
+}
+
+func (c *Client) DescargarXML(ctx context.Context, cuf string) ([]byte, error) {
+	if err := c.backend.esperar(ctx, "Get"); err != nil {
+		return nil, err
+	}
+	return c.backend.XML(cuf)
+}
+
+func (c *Client) ConsultarEstado(ctx context.Context, cuf string) (*api.EstadoFacturaResponse, error) {
+	if err := c.backend.esperar(ctx, "Get"); err != nil {
+		return nil, err
+	}
+	return c.backend.Estado(cuf)
+}
+
+func (c *Client) VerificarNit(ctx context.Context, nit string) (*api.VerificacionNit, error) {
+	if err := c.backend.esperar(ctx, "Get"); err != nil {
+		return nil, err
+	}
+	return c.backend.VerificarNit(nit), nil
+}
+
+func (c *Client) ListarLeyendas(ctx context.Context, actividad int) ([]api.Leyenda, error) {
+	if err := c.backend.esperar(ctx, "Get"); err != nil {
+		return nil, err
+	}
+	return c.backend.Leyendas(actividad), nil
+}
+
+func (c *Client) ListarCatalogo(ctx context.Context, tipo api.TipoCatalogo) ([]api.ItemCatalogo, error) {
+	if err := c.backend.esperar(ctx, "Get"); err != nil {
+		return nil, err
+	}
+	return c.backend.Catalogo(tipo), nil
+}
+
+func (c *Client) AnularFactura(ctx context.Context, cuf string, motivo api.MotivoAnulacion) (*api.AnulacionResponse, error) {
+	if err := c.backend.esperar(ctx, "Post"); err != nil {
+		return nil, err
+	}
+	return c.backend.Anular(cuf, motivo)
+}
+
+func (c *Client) RegistrarEvento(ctx context.Context, motivo api.EventoSignificativo, descripcion string, inicio, fin time.Time) (*api.EventoResponse, error) {
+	if err := c.backend.esperar(ctx, "Post"); err != nil {
+		return nil, err
+	}
+	if !fin.After(inicio) {
+		return nil, validationError("la fecha de fin del evento debe ser posterior al inicio")
+	}
+	return c.backend.RegistrarEvento(motivo)
+}
+
+func (c *Client) EnviarPaquete(ctx context.Context, codigoEvento string, facturas []api.FacturaRequest) (*api.PaqueteResponse, error) {
+	if err := c.backend.esperar(ctx, "Post"); err != nil {
+		return nil, err
+	}
+	return c.backend.Paquete(codigoEvento, facturas)
+}
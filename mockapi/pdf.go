@@ -0,0 +1,65 @@
+package mockapi
+
+import (
+	"app/api"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// placeholderPDF arma un PDF de una página con las líneas de texto dadas,
+// suficiente para probar descargas e impresión. El formato rollo usa una
+// página de 80 mm de ancho.
+func placeholderPDF(formato api.FormatoPdf, lines []string) []byte {
+	width, text := 612, "BT /F1 11 Tf 50 780 Td 16 TL\n"
+	if formato == api.FormatoRollo {
+		width, text = 227, "BT /F1 6 Tf 10 780 Td 9 TL\n"
+	}
+	var content bytes.Buffer
+	content.WriteString(text)
+	for _, line := range lines {
+		fmt.Fprintf(&content, "(%s) Tj T*\n", escapePDF(line))
+	}
+	content.WriteString("ET")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>", width),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+// escapePDF escapa el texto para un string literal de PDF; los caracteres
+// fuera de ASCII se reemplazan porque la fuente base no los incluye.
+func escapePDF(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 128:
+			b.WriteRune(r)
+		default:
+			b.WriteRune('?')
+		}
+	}
+	return b.String()
+}
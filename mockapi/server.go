@@ -0,0 +1,211 @@
+package mockapi
+
+import (
+	"app/api"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+)
+
+const basePath = "/api/v1/invoice-utils"
+
+// NewHandler expone el backend simulado con las mismas rutas que
+// invoice-utils.
+func NewHandler(b *Backend) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+basePath+"/third-party-create", b.handle(func(r *http.Request) (int, interface{}, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return 0, nil, err
+		}
+		var documento struct {
+			Solicitud api.SolicitudModel `json:"solicitud"`
+		}
+		if err := json.Unmarshal(body, &documento); err != nil {
+			return 0, nil, validationError("JSON inválido: " + err.Error())
+		}
+		if documento.Solicitud.CodigoDocumentoSector == api.SectorNotaCreditoDebito {
+			var req api.NotaCreditoDebitoRequest
+			if err := json.Unmarshal(body, &req); err != nil {
+				return 0, nil, validationError("JSON inválido: " + err.Error())
+			}
+			resp, err := b.CrearNota(req)
+			return http.StatusCreated, resp, err
+		}
+		var req api.FacturaRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return 0, nil, validationError("JSON inválido: " + err.Error())
+		}
+		resp, err := b.Crear(req)
+		if err == nil {
+			b.RegistrarSolicitud(r.Header.Get("X-Request-Id"), resp.Cuf)
+		}
+		return http.StatusCreated, resp, err
+	}))
+	mux.HandleFunc("GET "+basePath+"/status", b.handle(func(r *http.Request) (int, interface{}, error) {
+		query := r.URL.Query()
+		if cuf := query.Get("cuf"); cuf != "" {
+			resp, err := b.Estado(cuf)
+			return http.StatusOK, resp, err
+		}
+		numero, _ := strconv.Atoi(query.Get("numeroFactura"))
+		sector, _ := strconv.Atoi(query.Get("codigoDocumentoSector"))
+		resp, err := b.Buscar(query.Get("requestId"), numero, sector)
+		return http.StatusOK, resp, err
+	}))
+	mux.HandleFunc("GET "+basePath+"/nit", b.handle(func(r *http.Request) (int, interface{}, error) {
+		return http.StatusOK, b.VerificarNit(r.URL.Query().Get("nit")), nil
+	}))
+	mux.HandleFunc("GET "+basePath+"/leyendas", b.handle(func(r *http.Request) (int, interface{}, error) {
+		actividad, err := strconv.Atoi(r.URL.Query().Get("codigoActividad"))
+		if err != nil {
+			return 0, nil, validationError("codigoActividad inválido")
+		}
+		return http.StatusOK, b.Leyendas(actividad), nil
+	}))
+	mux.HandleFunc("GET "+basePath+"/catalogos", b.handle(func(r *http.Request) (int, interface{}, error) {
+		return http.StatusOK, b.Catalogo(api.TipoCatalogo(r.URL.Query().Get("tipo"))), nil
+	}))
+	mux.HandleFunc("POST "+basePath+"/third-party-cancel", b.handle(func(r *http.Request) (int, interface{}, error) {
+		var req struct {
+			Cuf          string              `json:"cuf"`
+			CodigoMotivo api.MotivoAnulacion `json:"codigoMotivo"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return 0, nil, validationError("JSON inválido: " + err.Error())
+		}
+		resp, err := b.Anular(req.Cuf, req.CodigoMotivo)
+		return http.StatusCreated, resp, err
+	}))
+	mux.HandleFunc("POST "+basePath+"/third-party-event", b.handle(func(r *http.Request) (int, interface{}, error) {
+		var req api.EventoContingencia
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return 0, nil, validationError("JSON inválido: " + err.Error())
+		}
+		inicio, errInicio := time.Parse("2006-01-02T15:04:05.000", req.FechaHoraInicioEvento)
+		fin, errFin := time.Parse("2006-01-02T15:04:05.000", req.FechaHoraFinEvento)
+		if errInicio != nil || errFin != nil || !fin.After(inicio) {
+			return 0, nil, validationError("fechas del evento inválidas")
+		}
+		resp, err := b.RegistrarEvento(req.CodigoMotivoEvento)
+		return http.StatusCreated, resp, err
+	}))
+	mux.HandleFunc("POST "+basePath+"/third-party-package", b.handle(func(r *http.Request) (int, interface{}, error) {
+		var req struct {
+			CodigoEvento string               `json:"codigoRecepcionEventoSignificativo"`
+			Facturas     []api.FacturaRequest `json:"facturas"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return 0, nil, validationError("JSON inválido: " + err.Error())
+		}
+		resp, err := b.Paquete(req.CodigoEvento, req.Facturas)
+		return http.StatusCreated, resp, err
+	}))
+	mux.HandleFunc("GET "+basePath+"/pdf", func(w http.ResponseWriter, r *http.Request) {
+		if !b.admitir(w, r) {
+			return
+		}
+		formato := api.FormatoCarta
+		if f := r.URL.Query().Get("formato"); f != "" {
+			var err error
+			if formato, err = api.ParseFormatoPdf(f); err != nil {
+				writeError(w, validationError(err.Error()))
+				return
+			}
+		}
+		pdf, err := b.PDF(r.URL.Query().Get("cuf"), formato)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(pdf)
+	})
+	mux.HandleFunc("GET "+basePath+"/xml", func(w http.ResponseWriter, r *http.Request) {
+		if !b.admitir(w, r) {
+			return
+		}
+		doc, err := b.XML(r.URL.Query().Get("cuf"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(doc)
+	})
+	return mux
+}
+
+// NewServer inicia un httptest.Server con un backend nuevo; la URL se usa
+// como api.ApiConfig.Url.
+func NewServer(opts Options) (*httptest.Server, *Backend) {
+	b := NewBackend(opts)
+	return httptest.NewServer(NewHandler(b)), b
+}
+
+// admitir aplica api_key, latencia y fallas simuladas. Devuelve false si
+// la solicitud ya fue respondida.
+func (b *Backend) admitir(w http.ResponseWriter, r *http.Request) bool {
+	opts := b.Options()
+	if opts.ApiKey != "" && r.Header.Get("api_key") != opts.ApiKey {
+		writeError(w, &api.APIError{StatusCode: http.StatusUnauthorized, Code: "Unauthorized", Messages: []string{"api_key inválida"}})
+		return false
+	}
+
+	err := b.esperar(r.Context(), r.Method)
+	if err == nil {
+		return true
+	}
+	var apiErr *api.APIError
+	if errors.As(err, &apiErr) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, apiErr)
+		return false
+	}
+	// sin red: se corta la conexión sin responder
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+			return false
+		}
+	}
+	writeError(w, &api.APIError{StatusCode: http.StatusBadGateway, Code: "Bad Gateway", Messages: []string{err.Error()}})
+	return false
+}
+
+func (b *Backend) handle(fn func(r *http.Request) (int, interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !b.admitir(w, r) {
+			return
+		}
+		status, resp, err := fn(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Println("mockapi: error writing response:", err)
+		}
+	}
+}
+
+// writeError responde con el formato de error del backend real.
+func writeError(w http.ResponseWriter, err error) {
+	apiErr := &api.APIError{StatusCode: http.StatusInternalServerError, Code: "Internal Server Error", Messages: []string{err.Error()}}
+	errors.As(err, &apiErr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.StatusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"statusCode": apiErr.StatusCode,
+		"message":    apiErr.Messages,
+		"error":      apiErr.Code,
+	})
+}
@@ -0,0 +1,161 @@
+package money
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money es un monto en bolivianos guardado como centavos, para que las
+// sumas de montoTotal, subTotal y ajustes cuadren al centavo.
+type Money int64
+
+// FromCentavos construye un monto a partir de centavos.
+func FromCentavos(centavos int64) Money {
+	return Money(centavos)
+}
+
+// FromFloat redondea f al centavo (mitad hacia arriba, lejos de cero).
+func FromFloat(f float64) Money {
+	return Money(math.Round(f * 100))
+}
+
+// Parse interpreta un decimal como "12.5", "12.50" o "-3.40". Los montos
+// con centavos fraccionarios, como "1.005", se rechazan en lugar de
+// redondearse; los ceros de más ("12.3400", como devuelve SQL Server para
+// money) se aceptan.
+func Parse(s string) (Money, error) {
+	original := s
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("monto vacío")
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	entero, decimales, _ := strings.Cut(s, ".")
+	if entero == "" && decimales == "" {
+		return 0, fmt.Errorf("monto inválido: %q", original)
+	}
+	for _, c := range entero + decimales {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("monto inválido: %q", original)
+		}
+	}
+	if len(strings.TrimRight(decimales, "0")) > 2 {
+		return 0, fmt.Errorf("monto con más de dos decimales: %q", original)
+	}
+
+	if entero == "" {
+		entero = "0"
+	}
+	units, err := strconv.ParseInt(entero, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("monto inválido: %q", original)
+	}
+	decimales += "00"
+	centavos, _ := strconv.ParseInt(decimales[:2], 10, 64)
+
+	m := Money(units*100 + centavos)
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+// Centavos devuelve el monto en centavos.
+func (m Money) Centavos() int64 {
+	return int64(m)
+}
+
+// Float64 devuelve el monto como float64, solo para mostrar o comparar.
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+// Mul multiplica el monto por una cantidad entera.
+func (m Money) Mul(cantidad int) Money {
+	return m * Money(cantidad)
+}
+
+// Porcentaje devuelve pct por ciento del monto, redondeado al centavo
+// (mitad hacia arriba, lejos de cero).
+func (m Money) Porcentaje(pct int64) Money {
+	c := int64(m) * pct
+	if c < 0 {
+		return -Money((-c + 50) / 100)
+	}
+	return Money((c + 50) / 100)
+}
+
+// String devuelve el monto con exactamente dos decimales, p. ej. "3.50".
+func (m Money) String() string {
+	sign := ""
+	c := int64(m)
+	if c < 0 {
+		sign = "-"
+		c = -c
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, c/100, c%100)
+}
+
+// MarshalJSON escribe el monto como número con dos decimales.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON acepta el monto como número o como texto.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" || s == "" {
+		*m = 0
+		return nil
+	}
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan implementa sql.Scanner para columnas money, decimal y float.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v * 100)
+	case float64:
+		*m = FromFloat(v)
+	case []byte:
+		parsed, err := Parse(string(v))
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	case string:
+		parsed, err := Parse(v)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	default:
+		return fmt.Errorf("no se puede convertir %T a money.Money", src)
+	}
+	return nil
+}
+
+// Value implementa driver.Valuer; el monto se envía como decimal en texto.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
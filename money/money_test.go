@@ -0,0 +1,43 @@
+package money
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Money
+		err  bool
+	}{
+		{s: "12.5", want: 1250},
+		{s: "12.50", want: 1250},
+		{s: "-3.4", want: -340},
+		{s: "+7", want: 700},
+		{s: ".05", want: 5},
+		{s: "1.", want: 100},
+		{s: "12.3400", want: 1234},
+		{s: " 0.00 ", want: 0},
+		{s: "1.005", err: true},
+		{s: "-0.125", err: true},
+		{s: "", err: true},
+		{s: "-", err: true},
+		{s: "+", err: true},
+		{s: ".", err: true},
+		{s: "-.", err: true},
+		{s: "1.2.3", err: true},
+		{s: "1,50", err: true},
+		{s: "--1", err: true},
+		{s: "1.-5", err: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.s)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, se esperaba un error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %v, %v; se esperaba %v", tt.s, got, err, tt.want)
+		}
+	}
+}
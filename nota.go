@@ -0,0 +1,117 @@
+package main
+
+import (
+	"app/api"
+	"app/db"
+	"app/money"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runNota issues a nota de crédito-débito against an emitted invoice, found
+// by abonado (current emission), Factura or Codigo_Control:
+//
+//	facturacion.exe nota -devolver 12.50 1001
+//	facturacion.exe nota -devolver 12.50 -factura 1708001
+//	facturacion.exe nota -devolver 12.50 -cuf 4A1B...
+func runNota(ctx context.Context, builder *api.FacturacionElectronica, fe api.InvoiceClient, args []string) error {
+	fs := flag.NewFlagSet("nota", flag.ExitOnError)
+	devolver := fs.String("devolver", "", "Monto a devolver en bolivianos, p. ej. 12.50")
+	factura := fs.Int("factura", 0, "Factura (columna Factura) de la factura original")
+	cuf := fs.String("cuf", "", "Codigo_Control (CUF) de la factura original")
+	emision := fs.String("emision", "", "Fecha de emisión al buscar por abonado (por defecto la emisión actual de Factores)")
+	numero := fs.Int("numero", 0, "Número de la nota (0: lo asigna el backend)")
+	fecha := fs.String("fecha", "", "Fecha de emisión de la factura original (por defecto la que informa el backend)")
+	yes := fs.Bool("si", false, "No pedir confirmación")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Uso: nota -devolver monto (abonado | -factura n | -cuf cuf)")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	busquedas := fs.NArg()
+	if *factura != 0 {
+		busquedas++
+	}
+	if *cuf != "" {
+		busquedas++
+	}
+	if busquedas != 1 || *devolver == "" {
+		fs.Usage()
+		return fmt.Errorf("indique el monto a devolver y un solo abonado, factura o cuf")
+	}
+	montoDevuelto, err := money.Parse(*devolver)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() == 1 {
+		if *emision == "" {
+			*emision, err = db.GetEmisionActual()
+			if err != nil {
+				return err
+			}
+		}
+		emitida, err := db.GetFacturaEmitida(*emision, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		*factura = emitida.FacturaID
+	}
+
+	original, err := db.GetFacturaOriginal(*factura, *cuf)
+	if err != nil {
+		return err
+	}
+
+	// the amounts and detail are the ones SIN has for the original CUF, not
+	// the current row, which may have changed since
+	ctx = api.ConAbonado(ctx, original.Abonado)
+	doc, err := fe.DescargarXML(ctx, original.CodigoControl)
+	if err != nil {
+		return fmt.Errorf("no se pudo descargar el XML de la factura original: %v", err)
+	}
+	facturaRequest, err := builder.LeerFacturaXML(doc)
+	if err != nil {
+		return err
+	}
+	if facturaRequest.Cabecera.Cuf != original.CodigoControl {
+		return fmt.Errorf("el XML descargado es de la factura %s, no de %s", facturaRequest.Cabecera.Cuf, original.CodigoControl)
+	}
+
+	if *fecha == "" {
+		estado, err := fe.ConsultarEstado(ctx, original.CodigoControl)
+		if err != nil {
+			return fmt.Errorf("no se pudo consultar la factura original: %v", err)
+		}
+		if estado.Estado == api.EstadoAnulada {
+			return fmt.Errorf("la factura original %s está anulada", original.CodigoControl)
+		}
+		*fecha = estado.Fecha
+		if *fecha == "" {
+			*fecha = facturaRequest.Cabecera.FechaEmision
+		}
+	}
+	notaRequest, err := builder.BuildNotaCreditoDebito(facturaRequest, original.CodigoControl, *fecha, montoDevuelto, *numero)
+	if err != nil {
+		return err
+	}
+
+	c := notaRequest.Cabecera
+	fmt.Printf("Abonado %s  %s\n", original.Abonado, c.NombreRazonSocial)
+	fmt.Printf("  factura %d del %s  cuf %s\n", c.NumeroFactura, c.FechaEmisionFactura, c.NumeroAutorizacionCuf)
+	fmt.Printf("  total original %s  devuelto %s  crédito fiscal %s\n", c.MontoTotalOriginal, c.MontoTotalDevuelto, c.MontoEfectivoCreditoDebito)
+	if !*yes && !confirmar(os.Stdin, "¿Emitir la nota de crédito-débito?") {
+		fmt.Println("Cancelado")
+		return nil
+	}
+
+	result, err := fe.EnviarNotaCreditoDebito(ctx, notaRequest)
+	if err != nil {
+		return fmt.Errorf("error al emitir la nota: %v", err)
+	}
+	fmt.Printf("Nota %d emitida, cuf %s (%s)\n", result.NumeroFactura, result.Cuf, result.Estado)
+	return nil
+}
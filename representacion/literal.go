@@ -0,0 +1,92 @@
+package representacion
+
+import (
+	"app/money"
+	"fmt"
+	"strings"
+)
+
+var unidades = []string{
+	"cero", "uno", "dos", "tres", "cuatro", "cinco", "seis", "siete", "ocho", "nueve",
+	"diez", "once", "doce", "trece", "catorce", "quince", "dieciséis", "diecisiete", "dieciocho", "diecinueve",
+	"veinte", "veintiuno", "veintidós", "veintitrés", "veinticuatro", "veinticinco", "veintiséis", "veintisiete", "veintiocho", "veintinueve",
+}
+
+var decenas = []string{"", "", "", "treinta", "cuarenta", "cincuenta", "sesenta", "setenta", "ochenta", "noventa"}
+
+var centenas = []string{"", "ciento", "doscientos", "trescientos", "cuatrocientos", "quinientos", "seiscientos", "setecientos", "ochocientos", "novecientos"}
+
+// Literal escribe el monto como en la representación gráfica de SIN:
+// 1520.50 es "Son: Mil quinientos veinte 50/100 Bolivianos".
+func Literal(m money.Money) string {
+	centavos := m.Centavos()
+	if centavos < 0 {
+		centavos = -centavos
+	}
+	texto := enLetras(centavos / 100)
+	if m < 0 {
+		texto = "menos " + texto
+	}
+	return fmt.Sprintf("Son: %s%s %02d/100 Bolivianos", strings.ToUpper(texto[:1]), texto[1:], centavos%100)
+}
+
+// enLetras escribe n en palabras, hasta cientos de miles de millones.
+func enLetras(n int64) string {
+	switch {
+	case n < 1000:
+		return cientos(int(n))
+	case n < 1000000:
+		miles, resto := n/1000, n%1000
+		texto := "mil"
+		if miles > 1 {
+			texto = apocope(cientos(int(miles))) + " mil"
+		}
+		if resto > 0 {
+			texto += " " + cientos(int(resto))
+		}
+		return texto
+	default:
+		millones, resto := n/1000000, n%1000000
+		texto := "un millón"
+		if millones > 1 {
+			texto = apocope(enLetras(millones)) + " millones"
+		}
+		if resto > 0 {
+			texto += " " + enLetras(resto)
+		}
+		return texto
+	}
+}
+
+func cientos(n int) string {
+	if n < 30 {
+		return unidades[n]
+	}
+	if n < 100 {
+		texto := decenas[n/10]
+		if n%10 > 0 {
+			texto += " y " + unidades[n%10]
+		}
+		return texto
+	}
+	if n == 100 {
+		return "cien"
+	}
+	texto := centenas[n/100]
+	if n%100 > 0 {
+		texto += " " + cientos(n%100)
+	}
+	return texto
+}
+
+// apocope acorta "uno" delante de mil y millones: veintiún mil, treinta y
+// un millones.
+func apocope(texto string) string {
+	switch {
+	case strings.HasSuffix(texto, "veintiuno"):
+		return strings.TrimSuffix(texto, "veintiuno") + "veintiún"
+	case strings.HasSuffix(texto, "uno"):
+		return strings.TrimSuffix(texto, "uno") + "un"
+	}
+	return texto
+}
@@ -0,0 +1,474 @@
+// Package representacion genera la representación gráfica de las facturas
+// de servicios básicos en PDF, en rollo o carta, sin pasar por el backend:
+// sirve para imprimir miles de facturas rápido y para las emitidas fuera de
+// línea.
+package representacion
+
+import (
+	"app/api"
+	"app/money"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+const (
+	textoContribuye = "ESTA FACTURA CONTRIBUYE AL DESARROLLO DEL PAÍS, EL USO ILÍCITO SERÁ SANCIONADO PENALMENTE DE ACUERDO A LEY"
+	textoEnLinea    = "“Este documento es la Representación Gráfica de un Documento Fiscal Digital emitido en una modalidad de facturación en línea”"
+	textoFueraLinea = "“Este documento es la Representación Gráfica de un Documento Fiscal Digital emitido fuera de línea, verifique su envío con su proveedor o en la página web www.impuestos.gob.bo”"
+)
+
+// anchoRollo es el ancho del papel de rollo en milímetros.
+const anchoRollo = 80
+
+// catalogos da las descripciones de las unidades de medida.
+var catalogos = sync.OnceValue(api.DefaultCatalogos)
+
+// linea es un renglón etiqueta-valor de la cabecera o de los totales;
+// Detalle marca el desglose de un ajuste, que se imprime más chico.
+type linea struct {
+	Etiqueta string
+	Valor    string
+	Detalle  bool
+}
+
+// factura son los datos de la solicitud ya listos para imprimir.
+type factura struct {
+	req         api.FacturaRequest
+	cuf         string
+	adicionales map[string]string
+}
+
+func newFactura(req api.FacturaRequest, cuf string) *factura {
+	f := &factura{req: req, cuf: cuf, adicionales: map[string]string{}}
+	for _, campo := range req.Cabecera.CamposAdicionales {
+		f.adicionales[campo.Clave] = campo.Valor
+	}
+	return f
+}
+
+// Generar arma el PDF de la factura con su cuf en el formato pedido.
+func Generar(req api.FacturaRequest, cuf string, formato api.FormatoPdf) ([]byte, error) {
+	if req.Cabecera.CodigoDocumentoSector != api.SectorServiciosBasicos && req.Cabecera.CodigoDocumentoSector != api.SectorCompraVenta {
+		return nil, fmt.Errorf("no hay representación gráfica local del documento sector %d", req.Cabecera.CodigoDocumentoSector)
+	}
+	f := newFactura(req, cuf)
+	qr, err := api.GenerarQR(req.Cabecera, cuf, formato)
+	if err != nil {
+		return nil, err
+	}
+
+	var pdf *fpdf.Fpdf
+	if formato == api.FormatoRollo {
+		// se dibuja una vez para medir el largo del rollo
+		medida := nuevoRollo(1000)
+		alto := f.rollo(medida, qr)
+		pdf = nuevoRollo(alto + 5)
+		f.rollo(pdf, qr)
+	} else {
+		pdf = fpdf.New("P", "mm", "Letter", "")
+		pdf.SetMargins(15, 15, 15)
+		pdf.SetAutoPageBreak(true, 15)
+		pdf.AddPage()
+		f.carta(pdf, qr)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("error al generar el PDF de la factura %d: %v", req.Cabecera.NumeroFactura, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func nuevoRollo(alto float64) *fpdf.Fpdf {
+	pdf := fpdf.NewCustom(&fpdf.InitType{
+		OrientationStr: "P",
+		UnitStr:        "mm",
+		Size:           fpdf.SizeType{Wd: anchoRollo, Ht: alto},
+	})
+	pdf.SetMargins(5, 5, 5)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+	return pdf
+}
+
+func (f *factura) carta(pdf *fpdf.Fpdf, qr *api.CodigoQR) {
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	c := f.req.Cabecera
+	izquierda, _, derecha, _ := pdf.GetMargins()
+	ancho, _ := pdf.GetPageSize()
+	util := ancho - izquierda - derecha
+
+	// emisor a la izquierda, NIT, número y CUF a la derecha
+	inicio := pdf.GetY()
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.MultiCell(util/2, 5, tr(c.RazonSocialEmisor), "", "L", false)
+	pdf.SetFont("Helvetica", "", 9)
+	for _, texto := range f.datosEmisor() {
+		pdf.MultiCell(util/2, 4, tr(texto), "", "L", false)
+	}
+	finEmisor := pdf.GetY()
+
+	pdf.SetY(inicio)
+	for _, l := range f.datosFactura() {
+		pdf.SetX(izquierda + util/2)
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.CellFormat(35, 5, tr(l.Etiqueta), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.MultiCell(util/2-35, 5, tr(l.Valor), "", "L", false)
+	}
+	pdf.SetY(max(finEmisor, pdf.GetY()) + 4)
+
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 7, "FACTURA", "", 1, "C", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.CellFormat(0, 5, tr("(Con Derecho a Crédito Fiscal)"), "", 1, "C", false, 0, "")
+	pdf.Ln(3)
+
+	// cliente en dos columnas
+	cliente := f.datosCliente()
+	mitad := (len(cliente) + 1) / 2
+	inicio = pdf.GetY()
+	for i, l := range cliente {
+		x := izquierda
+		if i >= mitad {
+			x = izquierda + util/2
+		}
+		if i == mitad {
+			pdf.SetY(inicio)
+		}
+		pdf.SetX(x)
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.CellFormat(38, 5, tr(l.Etiqueta+":"), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.CellFormat(util/2-38, 5, tr(l.Valor), "", 1, "L", false, 0, "")
+	}
+	pdf.SetY(max(pdf.GetY(), inicio+float64(mitad)*5) + 3)
+
+	// detalle
+	columnas := []struct {
+		titulo string
+		ancho  float64
+		alinea string
+	}{
+		{"CÓDIGO PRODUCTO / SERVICIO", 30, "L"},
+		{"CANTIDAD", 18, "R"},
+		{"UNIDAD DE MEDIDA", 22, "L"},
+		{"DESCRIPCIÓN", util - 30 - 18 - 22 - 26*3, "L"},
+		{"PRECIO UNITARIO", 26, "R"},
+		{"DESCUENTO", 26, "R"},
+		{"SUBTOTAL", 26, "R"},
+	}
+	pdf.SetFont("Helvetica", "B", 7)
+	pdf.SetFillColor(230, 230, 230)
+	for _, col := range columnas {
+		pdf.CellFormat(col.ancho, 8, tr(col.titulo), "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 8)
+	for _, d := range f.req.Detalle {
+		valores := []string{
+			d.CodigoProducto, strconv.Itoa(d.Cantidad), f.unidad(d.UnidadMedida), d.Descripcion,
+			d.PrecioUnitario.String(), d.MontoDescuento.String(), d.SubTotal.String(),
+		}
+		for i, col := range columnas {
+			pdf.CellFormat(col.ancho, 6, tr(valores[i]), "1", 0, col.alinea, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	// totales alineados con las últimas columnas
+	for _, l := range f.totales() {
+		pdf.SetX(izquierda + util - 26*4)
+		if l.Detalle {
+			pdf.SetFont("Helvetica", "", 7)
+			pdf.CellFormat(26*3, 4, tr(l.Etiqueta), "LR", 0, "R", false, 0, "")
+			pdf.CellFormat(26, 4, l.Valor, "LR", 1, "R", false, 0, "")
+			continue
+		}
+		pdf.SetFont("Helvetica", estiloTotal(l), 8)
+		pdf.CellFormat(26*3, 6, tr(l.Etiqueta), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(26, 6, l.Valor, "1", 1, "R", false, 0, "")
+	}
+	pdf.Ln(2)
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.MultiCell(0, 5, tr(Literal(c.MontoTotal)), "", "L", false)
+	pdf.Ln(4)
+
+	// leyendas a la izquierda y QR a la derecha
+	inicio = pdf.GetY()
+	ladoQR := 30.0
+	pdf.SetFont("Helvetica", "B", 8)
+	pdf.MultiCell(util-ladoQR-5, 4, tr(textoContribuye), "", "C", false)
+	pdf.SetFont("Helvetica", "", 8)
+	pdf.MultiCell(util-ladoQR-5, 4, tr(c.Leyenda), "", "C", false)
+	pdf.MultiCell(util-ladoQR-5, 4, tr(f.textoEmision()), "", "C", false)
+	imagenQR(pdf, qr, izquierda+util-ladoQR, inicio, ladoQR)
+}
+
+func (f *factura) rollo(pdf *fpdf.Fpdf, qr *api.CodigoQR) float64 {
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	c := f.req.Cabecera
+	izquierda, _, derecha, _ := pdf.GetMargins()
+	util := anchoRollo - izquierda - derecha
+	centrado := func(estilo string, tam float64, texto string) {
+		pdf.SetFont("Helvetica", estilo, tam)
+		pdf.MultiCell(util, tam*0.45, tr(texto), "", "C", false)
+	}
+	separador := func() {
+		pdf.Ln(1)
+		pdf.SetDashPattern([]float64{0.8, 0.8}, 0)
+		pdf.Line(izquierda, pdf.GetY(), izquierda+util, pdf.GetY())
+		pdf.SetDashPattern([]float64{}, 0)
+		pdf.Ln(1.5)
+	}
+
+	centrado("B", 9, "FACTURA")
+	centrado("", 7, "(Con Derecho a Crédito Fiscal)")
+	centrado("B", 8, c.RazonSocialEmisor)
+	for _, texto := range f.datosEmisor() {
+		centrado("", 7, texto)
+	}
+	separador()
+	for _, l := range f.datosFactura() {
+		centrado("B", 7, l.Etiqueta)
+		centrado("", 7, l.Valor)
+	}
+	separador()
+	for _, l := range f.datosCliente() {
+		pdf.SetFont("Helvetica", "B", 7)
+		pdf.CellFormat(util*0.42, 3.5, tr(l.Etiqueta+":"), "", 0, "R", false, 0, "")
+		pdf.SetFont("Helvetica", "", 7)
+		pdf.MultiCell(util*0.58, 3.5, tr(" "+l.Valor), "", "L", false)
+	}
+	separador()
+	centrado("B", 7, "DETALLE")
+	for _, d := range f.req.Detalle {
+		pdf.SetFont("Helvetica", "B", 7)
+		pdf.MultiCell(util, 3.5, tr(d.CodigoProducto+" - "+d.Descripcion), "", "L", false)
+		pdf.SetFont("Helvetica", "", 7)
+		pdf.MultiCell(util, 3.5, tr(fmt.Sprintf("Unidad de medida: %s", f.unidad(d.UnidadMedida))), "", "L", false)
+		pdf.CellFormat(util*0.62, 3.5, tr(fmt.Sprintf("%d X %s - %s", d.Cantidad, d.PrecioUnitario, d.MontoDescuento)), "", 0, "L", false, 0, "")
+		pdf.CellFormat(util*0.38, 3.5, d.SubTotal.String(), "", 1, "R", false, 0, "")
+	}
+	separador()
+	for _, l := range f.totales() {
+		tam := 7.0
+		if l.Detalle {
+			tam = 6
+		}
+		pdf.SetFont("Helvetica", estiloTotal(l), tam)
+		pdf.CellFormat(util*0.62, 3.5, tr(l.Etiqueta), "", 0, "R", false, 0, "")
+		pdf.CellFormat(util*0.38, 3.5, l.Valor, "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(1)
+	pdf.SetFont("Helvetica", "", 7)
+	pdf.MultiCell(util, 3.5, tr(Literal(c.MontoTotal)), "", "L", false)
+	separador()
+	centrado("B", 7, textoContribuye)
+	pdf.Ln(1)
+	centrado("", 7, c.Leyenda)
+	pdf.Ln(1)
+	centrado("", 7, f.textoEmision())
+	pdf.Ln(2)
+
+	ladoQR := 30.0
+	y := pdf.GetY()
+	imagenQR(pdf, qr, izquierda+(util-ladoQR)/2, y, ladoQR)
+	return y + ladoQR
+}
+
+// estiloTotal resalta el monto a pagar y la base del crédito fiscal.
+func estiloTotal(l linea) string {
+	if strings.HasPrefix(l.Etiqueta, "MONTO TOTAL") || strings.HasPrefix(l.Etiqueta, "IMPORTE BASE") {
+		return "B"
+	}
+	return ""
+}
+
+// imagenQR dibuja el QR de lado milímetros en x, y.
+func imagenQR(pdf *fpdf.Fpdf, qr *api.CodigoQR, x, y, lado float64) {
+	pdf.RegisterImageOptionsReader("qr", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qr.PNG(4)))
+	pdf.ImageOptions("qr", x, y, lado, lado, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+}
+
+func (f *factura) datosEmisor() []string {
+	c := f.req.Cabecera
+	sucursal := "CASA MATRIZ"
+	if c.CodigoSucursal > 0 {
+		sucursal = fmt.Sprintf("SUCURSAL No. %d", c.CodigoSucursal)
+	}
+	textos := []string{sucursal, fmt.Sprintf("No. Punto de Venta %d", c.CodigoPuntoVenta), c.Direccion}
+	if c.Telefono != "" {
+		textos = append(textos, "Teléfono: "+c.Telefono)
+	}
+	return append(textos, c.Municipio)
+}
+
+func (f *factura) datosFactura() []linea {
+	c := f.req.Cabecera
+	return []linea{
+		{Etiqueta: "NIT", Valor: strconv.FormatInt(c.NitEmisor, 10)},
+		{Etiqueta: "FACTURA N°", Valor: strconv.Itoa(c.NumeroFactura)},
+		{Etiqueta: "CÓD. AUTORIZACIÓN", Valor: f.cuf},
+	}
+}
+
+func (f *factura) datosCliente() []linea {
+	c := f.req.Cabecera
+	documento := c.NumeroDocumento
+	if c.Complemento != "" {
+		documento += "-" + c.Complemento
+	}
+	lineas := []linea{
+		{Etiqueta: "Fecha", Valor: fechaEmision(c.FechaEmision)},
+		{Etiqueta: "Nombre/Razón Social", Valor: c.NombreRazonSocial},
+		{Etiqueta: "NIT/CI/CEX", Valor: documento},
+		{Etiqueta: "Cod. Cliente", Valor: c.CodigoCliente},
+	}
+	if c.CodigoDocumentoSector != api.SectorServiciosBasicos {
+		return lineas
+	}
+	for _, l := range []linea{
+		{Etiqueta: "Mes/Gestión", Valor: strings.TrimSpace(f.adicionales["mes"] + " " + f.adicionales["gestion"])},
+		{Etiqueta: "Ciudad", Valor: f.adicionales["ciudad"]},
+		{Etiqueta: "Zona", Valor: f.adicionales["zona"]},
+		{Etiqueta: "Domicilio", Valor: f.adicionales["domicilioCliente"]},
+		{Etiqueta: "N° Medidor", Valor: f.adicionales["numeroMedidor"]},
+		{Etiqueta: "Consumo del periodo", Valor: consumo(f.adicionales["consumoPeriodo"])},
+	} {
+		if l.Valor != "" {
+			lineas = append(lineas, l)
+		}
+	}
+	return lineas
+}
+
+// totales sigue el orden de la representación gráfica del sector 13: los
+// descuentos y ajustes que no aplican no se imprimen.
+func (f *factura) totales() []linea {
+	c := f.req.Cabecera
+	var subtotal money.Money
+	for _, d := range f.req.Detalle {
+		subtotal += d.SubTotal
+	}
+	lineas := []linea{{Etiqueta: "SUBTOTAL Bs", Valor: subtotal.String()}}
+	if c.DescuentoAdicional > 0 {
+		lineas = append(lineas, linea{Etiqueta: "DESCUENTO Bs", Valor: c.DescuentoAdicional.String()})
+	}
+	lineas = append(lineas, linea{Etiqueta: "TOTAL Bs", Valor: (subtotal - c.DescuentoAdicional).String()})
+
+	montos := []struct {
+		clave    string
+		etiqueta string
+		detalle  string
+	}{
+		{"ajusteSujetoIva", "(+) AJUSTES SUJETOS A IVA Bs", "detalleAjusteSujetoIva"},
+		{"montoDescuentoLey1886", "(-) DESCUENTO LEY N° 1886 Bs", ""},
+		{"montoDescuentoTarifaDignidad", "(-) DESCUENTO TARIFA DIGNIDAD Bs", ""},
+	}
+	for _, m := range montos {
+		if monto := f.monto(m.clave); monto != 0 {
+			lineas = append(lineas, linea{Etiqueta: m.etiqueta, Valor: monto.String()})
+			lineas = append(lineas, f.desglose(m.detalle)...)
+		}
+	}
+	if f.adicionales["beneficiarioLey1886"] != "" && f.monto("montoDescuentoLey1886") == 0 {
+		var descuento money.Money
+		for _, d := range f.req.Detalle {
+			descuento += d.MontoDescuento
+		}
+		if descuento > 0 {
+			lineas = append(lineas, linea{Etiqueta: "DESCUENTO LEY N° 1886 (INCLUIDO) Bs", Valor: descuento.String()})
+		}
+	}
+
+	noSujetos := []struct {
+		clave    string
+		etiqueta string
+		detalle  string
+	}{
+		{"tasaAseo", "(+) TASA DE ASEO Bs", ""},
+		{"tasaAlumbrado", "(+) TASA DE ALUMBRADO Bs", ""},
+		{"otrasTasas", "(+) OTRAS TASAS Bs", ""},
+		{"otrosPagosNoSujetoIva", "(+) OTROS PAGOS NO SUJETOS A IVA Bs", "detalleOtrosPagosNoSujetoIva"},
+		{"ajusteNoSujetoIva", "(+) AJUSTES NO SUJETOS A IVA Bs", "detalleAjusteNoSujetoIva"},
+	}
+	for _, m := range noSujetos {
+		if monto := f.monto(m.clave); monto != 0 {
+			lineas = append(lineas, linea{Etiqueta: m.etiqueta, Valor: monto.String()})
+			lineas = append(lineas, f.desglose(m.detalle)...)
+		}
+	}
+	return append(lineas,
+		linea{Etiqueta: "MONTO TOTAL A PAGAR Bs", Valor: c.MontoTotal.String()},
+		linea{Etiqueta: "IMPORTE BASE CRÉDITO FISCAL Bs", Valor: c.MontoTotalSujetoIva.String()},
+	)
+}
+
+// desglose detalla un ajuste u otro pago, que viene como un objeto JSON de
+// concepto a monto.
+func (f *factura) desglose(clave string) []linea {
+	detalle := map[string]interface{}{}
+	if clave == "" || json.Unmarshal([]byte(f.adicionales[clave]), &detalle) != nil {
+		return nil
+	}
+	conceptos := make([]string, 0, len(detalle))
+	for concepto := range detalle {
+		conceptos = append(conceptos, concepto)
+	}
+	sort.Strings(conceptos)
+	lineas := make([]linea, len(conceptos))
+	for i, concepto := range conceptos {
+		lineas[i] = linea{Etiqueta: concepto, Valor: fmt.Sprint(detalle[concepto]), Detalle: true}
+	}
+	return lineas
+}
+
+func (f *factura) monto(clave string) money.Money {
+	valor, ok := f.adicionales[clave]
+	if !ok {
+		return 0
+	}
+	m, err := money.Parse(valor)
+	if err != nil {
+		return 0
+	}
+	return m
+}
+
+func (f *factura) unidad(codigo int) string {
+	if descripcion := catalogos().Descripcion(api.CatalogoUnidadesMedida, codigo); descripcion != "" {
+		return descripcion
+	}
+	return strconv.Itoa(codigo)
+}
+
+func (f *factura) textoEmision() string {
+	if f.req.Solicitud.CodigoEmision == api.EmisionFueraDeLinea {
+		return textoFueraLinea
+	}
+	return textoEnLinea
+}
+
+func fechaEmision(fecha string) string {
+	t, err := time.Parse("2006-01-02T15:04:05.000", fecha)
+	if err != nil {
+		return fecha
+	}
+	return t.Format("02/01/2006 03:04 PM")
+}
+
+func consumo(m3 string) string {
+	if m3 == "" {
+		return ""
+	}
+	return m3 + " m3"
+}
@@ -2,12 +2,18 @@ package ui
 
 import (
 	"app/api"
+	"app/contingencia"
+	"app/correo"
 	"app/db"
+	"app/emision"
+	"app/flujo"
+	"app/verificacion"
+	"context"
 	"fmt"
 	"image/color"
 	"log"
 	"os"
-	"sync"
+	"path/filepath"
 	"time"
 
 	"gioui.org/app"
@@ -26,6 +32,24 @@ type AppState struct {
 	Facturas    []db.Factura
 	Emision     string
 	ErrorMessage string
+	Config      Config
+}
+
+// Config holds the settings the UI receives from the command line.
+type Config struct {
+	Api    api.ApiConfig
+	Emisor api.EmisorProfile
+	// Builder builds the requests with the loaded leyendas and catalogs
+	Builder *api.FacturacionElectronica
+	// Client sends the invoices; the real backend or a mockapi.Client
+	Client       api.InvoiceClient
+	Contingencia *contingencia.Cola
+	// Correo sends each emitted invoice to the client; nil disables it
+	Correo *correo.Enviador
+	// VerificadorNit checks NITs against SIN before sending; nil skips it
+	VerificadorNit *api.VerificadorNit
+	// Flujo limits the rate and concurrency of the emission
+	Flujo flujo.Opciones
 }
 
 type C = layout.Context
@@ -55,7 +79,7 @@ type Step struct {
 // Define the progress variables, a channel and a variable
 var progressIncrementer chan bool
 
-func SetupUI() {
+func SetupUI(config Config) {
 	// Setup a separate channel to provide ticks to increment progress
 	progressIncrementer = make(chan bool)
 	go func() {
@@ -73,6 +97,7 @@ func SetupUI() {
 	// Initialize the app state
 	appState := &AppState{
 		CurrentView: "main",
+		Config:      config,
 	}
 
 	// Start the loading screen
@@ -232,7 +257,7 @@ func drawLoadingScreen(w *app.Window, appState *AppState) error {
 				// create new window
 				w := new(app.Window)
 				w.Option(app.Title("Facturación Masiva"))
-				w.Option(app.Size(unit.Dp(500), unit.Dp(300)))
+				w.Option(app.Size(unit.Dp(500), unit.Dp(400)))
 				if err := drawMainScreen(w, appState); err != nil {
 					log.Fatal(err)
 				}
@@ -251,9 +276,15 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 	// startButton is a clickable widget
 	var startButton widget.Clickable
 
+	// verificarButton opens the QR verification window
+	var verificarButton widget.Clickable
+
 	// is the process running?
 	var running bool
 
+	// cancelRun stops the running process
+	cancelRun := context.CancelFunc(func() {})
+
 	// th defines the material design style
 	th := material.NewTheme()
 
@@ -274,6 +305,9 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 	// Progress info label
 	var progressInfoText string
 
+	// Emisor info label
+	emisorInfoText := emisorInfo(appState.Config)
+
 	// listen for events in the incrementor channel
 	go func() {
 		for range progressIncrementer {
@@ -293,12 +327,18 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 			gtx := app.NewContext(&ops, e)
 
 			// Let's try out the flexbox layout concept
-			if startButton.Clicked(gtx) {
-				// Start (or stop) the process
-				if running {
-					return nil
-				}
+			clicked := startButton.Clicked(gtx)
+			if clicked && running {
+				// Stop the process: cancels in-flight requests and pending facturas
+				cancelRun()
+				progressInfoText = "Deteniendo..."
+				clicked = false
+			}
+			if clicked {
+				// Start the process
 				running = true
+				ctx, cancel := context.WithCancel(api.ConCorrida(context.Background(), api.NewCorrida()))
+				cancelRun = cancel
 
 				defer func() {
 					running = false
@@ -314,6 +354,8 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 					}
 				}
 				go func() {
+					defer cancel()
+
 					// Step 1: Consultar a la base de datos
 					steps[0].status = Processing
 					factores, err := db.GetFactores()
@@ -325,7 +367,8 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 						log.Println("No factores found")
 						return
 					}
-					emision := factores[0]["Emision"].(time.Time).Format("2006-01-02")
+					periodo := factores[0]["Emision"].(time.Time)
+					emision := periodo.Format("2006-01-02")
 					log.Println("Emision:", emision)
 					// Paso 2: Verificar los datos
 					faltantes, err := db.VerificarLecturasFaltantes(emision)
@@ -352,6 +395,15 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 
 					totalFacturas := len(facturas)
 
+					descuadres, err := conciliarMontos(emision, periodo, facturas, appState.Config)
+					if err != nil {
+						log.Println("Error reconciling montos:", err)
+						steps[0].hasError = true
+					} else if len(descuadres) > 0 {
+						log.Printf("%d facturas with amounts that don't reconcile, see reportes/montos_%s.csv", len(descuadres), emision)
+						steps[0].hasError = true
+					}
+
 					// selecionar la primera factura
 					// facturas = facturas[0:100]
 
@@ -360,16 +412,28 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 					steps[0].status = Completed
 
 					steps[1].status = Processing
-					procesados, exitos, fallos := facturacionMasiva(facturas, &totalProgress, w, &progressInfoText)
+					sincronizarContingencia(ctx, appState.Config)
+					procesados, exitos, fallos, enCola := facturacionMasiva(ctx, periodo, facturas, appState.Config, &totalProgress, w, &progressInfoText)
 					steps[1].status = Completed
 
-					// Step 4: Verificar integridad de datos
-					// Simulate data integrity check
+					// Step 3: Verificar cada CUF emitido contra el backend
 					steps[2].status = Processing
-					time.Sleep(1 * time.Second)
+					resumen, err := verificarEmision(ctx, emision, appState, &totalProgress, w, &progressInfoText)
 					steps[2].status = Completed
+					if err != nil {
+						log.Println("Error verifying facturas:", err)
+						steps[2].hasError = true
+					} else if len(resumen.Diferencias) > 0 || len(resumen.Errores) > 0 {
+						steps[2].hasError = true
+					}
 
-					progressInfoText = fmt.Sprintf("Procesando factura %d/%d, exitoso = %d, errores = %d", len(procesados), len(facturas), len(exitos), len(fallos))
+					progressInfoText = fmt.Sprintf("Procesando factura %d/%d, exitoso = %d, errores = %d, contingencia = %d", len(procesados), len(facturas), len(exitos), len(fallos), len(enCola))
+					if len(descuadres) > 0 {
+						progressInfoText += fmt.Sprintf("\nMontos que no cuadran = %d, ver reportes/montos_%s.csv", len(descuadres), emision)
+					}
+					if resumen != nil {
+						progressInfoText += "\n" + resumen.String()
+					}
 					running = false
 					w.Invalidate()
 				}()
@@ -380,6 +444,17 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 				w.Invalidate()
 			}
 
+			if verificarButton.Clicked(gtx) {
+				go func() {
+					w := new(app.Window)
+					w.Option(app.Title("Verificar factura"))
+					w.Option(app.Size(unit.Dp(450), unit.Dp(600)))
+					if err := drawVerificarScreen(w, appState.Config); err != nil {
+						log.Println("Error in verificar window:", err)
+					}
+				}()
+			}
+
 			layout.Flex{
 				// Vertical alignment, from top to bottom
 				Axis: layout.Vertical,
@@ -388,6 +463,20 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 			}.Layout(
 				gtx,
 
+				// Emisor info label
+				layout.Rigid(
+					func(gtx C) D {
+						return layout.Inset{
+							Top:    unit.Dp(5),
+							Bottom: unit.Dp(5),
+							Left:   unit.Dp(10),
+							Right:  unit.Dp(10),
+						}.Layout(gtx, func(gtx C) D {
+							return material.Body2(th, emisorInfoText).Layout(gtx)
+						})
+					},
+				),
+
 				// Steps and their status
 				layout.Rigid(
 					func(gtx C) D {
@@ -484,7 +573,7 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 								var text string = "Iniciar"
 
 								if running && totalProgress < 1 {
-									text = "Pausar"
+									text = "Detener"
 								}
 
 								// if running && totalProgress >= 1 {
@@ -501,6 +590,17 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 					},
 				),
 
+				// The verification button
+				layout.Rigid(
+					func(gtx C) D {
+						return layout.Inset{
+							Bottom: unit.Dp(25),
+							Right:  unit.Dp(35),
+							Left:   unit.Dp(35),
+						}.Layout(gtx, material.Button(th, &verificarButton, "Verificar factura").Layout)
+					},
+				),
+
 				// Error dialog
 				layout.Rigid(
 					func(gtx C) D {
@@ -523,60 +623,129 @@ func drawMainScreen(w *app.Window, appState *AppState) error {
 }
 
 
-func facturacionMasiva(facturas []db.Factura, totalProgress *float32, w *app.Window, progressInfoText *string) ([]db.Factura, []db.Factura, []db.Factura) {
-    fe := api.NewFacturacionElectronica(api.ApiConfig{
-        Url:    "http://192.168.0.102:3001",
-        ApiKey: "8b6d1b35ea7998191033237d588abd859e24af22895e2ec7574c8748a3be2cdcf5153f9bfca1d617167c6128ecd2880e7225fa0c7ada6461ca55fc52daec0fe4e1acee0d380323fccdb67b8a1cbf40c4d2718988e5bf5f7d95f98733af5152b84f0ceb500359fe385916bc775323a2d154ff0acc694e4ce36d8b696eea07c1498e5c642440022eef8a954eeee90dfd8d0c1d5d935cc5a768e640dd1fc764726fb5f7fca2c8ccb238d381fe03c8cb89a75f61e3fe32e7a984a7e8470b795a4df3637edcd913bdce45304a62ed8bd8485147ce0bd29dcbd8a82276568497146a02f6536288c3bb1f01c5c328ad92fff30568a1781634f8ed6052340d082d04dd81b20ed6ea77a4cecf1ab66d96b6a97107",
+func facturacionMasiva(ctx context.Context, periodo time.Time, facturas []db.Factura, config Config, totalProgress *float32, w *app.Window, progressInfoText *string) ([]db.Factura, []db.Factura, []db.Factura, []db.Factura) {
+    var correos *correo.Despacho
+    if config.Correo != nil {
+        correos = config.Correo.Iniciar(ctx, 4, len(facturas))
+    }
+
+    resultado := emision.Masiva(ctx, facturas, emision.Config{
+        Periodo:        periodo,
+        Builder:        config.Builder,
+        Client:         config.Client,
+        Contingencia:   config.Contingencia,
+        VerificadorNit: config.VerificadorNit,
+        Flujo:          config.Flujo,
+        Correos:        correos,
+        Registrar:      registrarFactura,
+    }, func(p emision.Progreso) {
+        *totalProgress = float32(p.Exitos) / float32(len(facturas))
+        *progressInfoText = fmt.Sprintf("Procesando factura %d/%d, exitoso = %d, errores = %d, contingencia = %d", p.Procesados, len(facturas), p.Exitos, p.Fallos, p.EnCola) +
+            fmt.Sprintf("\n%.1f facturas/s, en curso %d de %d, latencia %v", p.Flujo.PorSegundo, p.Flujo.EnCurso, p.Flujo.Limite, p.Flujo.Latencia.Round(time.Millisecond))
+        w.Invalidate()
     })
-    const maxGoroutines = 100
-    sem := make(chan struct{}, maxGoroutines)
-    var wg sync.WaitGroup
-
-    procesados := []db.Factura{}
-    exitos := []db.Factura{}
-    fallos := []db.Factura{}
-
-    for _, factura := range facturas {
-        sem <- struct{}{}
-        wg.Add(1)
-        go func(factura db.Factura) {
-            defer func() {
-                <-sem
-                wg.Done()
-            }()
-            
-            result, err := fe.FacturaServicios(
-                time.Now(),
-                factura.ConM3, factura.ImpTotal, factura.ImpAlcanta,
-                factura.ImpRep, factura.ImpFactura, factura.ImpRecargo,
-                factura.ImpLey1886, factura.Razon, factura.Abonado,
-                factura.Nit, factura.Zona, factura.Calle, factura.NumFactura,
-            )
-            procesados = append(procesados, factura)
-            if err != nil {
-                log.Println("Error generating factura:", err)
-                fallos = append(fallos, factura)
-                return
-            }
-
-            cuf := result["cuf"].(string)
-            err = db.UpdateFacturaCodigoControl(factura.FacturaID, cuf)
-            if err != nil {
-                log.Println("Error updating factura codigo control:", err)
-                fallos = append(fallos, factura)
-                return
-            }
-
-            *totalProgress += 1.0 / float32(len(facturas))
-            if *totalProgress >= 1 {
-                *totalProgress = 1
-            }
-            exitos = append(exitos, factura)
-            *progressInfoText = fmt.Sprintf("Procesando factura %d/%d, exitoso = %d, errores = %d", len(procesados), len(facturas), len(exitos), len(fallos))
-            w.Invalidate()
-        }(factura)
+
+    if correos != nil {
+        *progressInfoText = "Enviando correos pendientes..."
+        w.Invalidate()
+        enviados, fallidos := correos.Cerrar()
+        log.Printf("Correos: %d enviados, %d fallidos", enviados, fallidos)
+    }
+    return resultado.Procesados, resultado.Exitos, resultado.Fallos, resultado.EnCola
+}
+
+// registrarFactura guarda el CUF de una factura aceptada y la leyenda con
+// la que se emitió.
+func registrarFactura(factura db.Factura, cuf, leyenda string) error {
+    if err := db.UpdateFacturaCodigoControl(factura.FacturaID, cuf); err != nil {
+        return err
+    }
+    if err := db.UpdateFacturaLeyenda(factura.FacturaID, leyenda); err != nil {
+        log.Println("Error updating factura leyenda:", err)
     }
+    return nil
+}
 
-    wg.Wait()
-    return procesados, exitos, fallos
-}
\ No newline at end of file
+// sincronizarContingencia envía las facturas que quedaron en cola de una
+// corrida anterior y registra su CUF.
+func sincronizarContingencia(ctx context.Context, config Config) {
+    pendientes, err := config.Contingencia.Pendientes()
+    if err != nil || len(pendientes) == 0 {
+        if err != nil {
+            log.Println("Error reading contingencia:", err)
+        }
+        return
+    }
+
+    enviadas, err := config.Contingencia.Sincronizar(ctx, config.Client, func(p contingencia.Pendiente, cuf string) error {
+        if err := db.UpdateFacturaCodigoControl(p.FacturaID, cuf); err != nil {
+            return err
+        }
+        return db.UpdateFacturaLeyenda(p.FacturaID, p.Request.Cabecera.Leyenda)
+    })
+    log.Printf("Contingencia: %d de %d facturas enviadas", enviadas, len(pendientes))
+    if err != nil {
+        log.Println("Error sending contingencia:", err)
+    }
+}
+
+// verificarEmision consulta el estado de todas las facturas emitidas y guarda
+// las diferencias en reportes/verificacion_<emision>.csv.
+func verificarEmision(ctx context.Context, emision string, appState *AppState, totalProgress *float32, w *app.Window, progressInfoText *string) (*verificacion.Resumen, error) {
+	emitidas, err := db.GetFacturasEmitidas(emision)
+	if err != nil {
+		return nil, err
+	}
+
+	*totalProgress = 0
+	resumen, err := verificacion.Verificar(ctx, appState.Config.Client, emision, emitidas, func(done int) {
+		*totalProgress = float32(done) / float32(len(emitidas))
+		*progressInfoText = fmt.Sprintf("Verificando factura %d/%d", done, len(emitidas))
+		w.Invalidate()
+	})
+	if err != nil {
+		return resumen, err
+	}
+	log.Println(resumen)
+
+	if err := os.MkdirAll("reportes", 0755); err != nil {
+		return resumen, err
+	}
+	f, err := os.Create(filepath.Join("reportes", fmt.Sprintf("verificacion_%s.csv", emision)))
+	if err != nil {
+		return resumen, err
+	}
+	defer f.Close()
+	return resumen, resumen.WriteCSV(f)
+}
+
+// conciliarMontos revisa los importes de las facturas de la emisión antes
+// de emitir, con el mismo periodo con que se emiten, y escribe las que no
+// cuadran en reportes/montos_<emision>.csv.
+func conciliarMontos(emision string, periodo time.Time, facturas []db.Factura, config Config) ([]verificacion.Descuadre, error) {
+	descuadres, err := verificacion.ConciliarMontos(config.Builder, periodo, facturas)
+	if err != nil || len(descuadres) == 0 {
+		return descuadres, err
+	}
+
+	if err := os.MkdirAll("reportes", 0755); err != nil {
+		return descuadres, err
+	}
+	f, err := os.Create(filepath.Join("reportes", fmt.Sprintf("montos_%s.csv", emision)))
+	if err != nil {
+		return descuadres, err
+	}
+	defer f.Close()
+	return descuadres, verificacion.WriteDescuadresCSV(f, descuadres)
+}
+
+// emisorInfo describe el emisor con las descripciones de los catálogos de SIN.
+func emisorInfo(config Config) string {
+	emisor := config.Emisor
+	catalogos := config.Builder.Catalogos()
+	return fmt.Sprintf("%s (NIT %d)\nActividad %s\nSector %s, sucursal %d, punto de venta %d",
+		emisor.RazonSocial, emisor.Nit,
+		catalogos.Etiqueta(api.CatalogoActividades, emisor.CodigoActividad),
+		catalogos.Etiqueta(api.CatalogoDocumentosSector, api.SectorServiciosBasicos),
+		emisor.CodigoSucursal, emisor.CodigoPuntoVenta)
+}
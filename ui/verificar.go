@@ -0,0 +1,177 @@
+package ui
+
+import (
+	"app/api"
+	"app/db"
+	"app/emision"
+	"context"
+	"fmt"
+	"image"
+	"strings"
+	"time"
+
+	"gioui.org/app"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// facturaQR es lo que muestra la pantalla de verificación de un abonado.
+type facturaQR struct {
+	Abonado string
+	Numero  int
+	Cuf     string
+	URL     string
+	Estado  string
+	Imagen  image.Image
+}
+
+// buscarFacturaQR arma el QR de la factura emitida del abonado en la emisión
+// actual y consulta su estado en el backend.
+func buscarFacturaQR(ctx context.Context, config Config, abonado string) (*facturaQR, error) {
+	actual, err := db.GetEmisionActual()
+	if err != nil {
+		return nil, err
+	}
+	emitida, err := db.GetFacturaEmitida(actual, abonado)
+	if err != nil {
+		return nil, err
+	}
+
+	cabecera := api.CabeceraModel{NitEmisor: config.Emisor.Nit, NumeroFactura: emitida.NumFactura}
+	codigo, err := api.GenerarQR(cabecera, emitida.CodigoControl, api.FormatoCarta)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &facturaQR{
+		Abonado: abonado,
+		Numero:  emitida.NumFactura,
+		Cuf:     emitida.CodigoControl,
+		URL:     codigo.URL,
+		Imagen:  codigo.Image(6),
+	}
+	estado, err := config.Client.ConsultarEstado(api.ConAbonado(ctx, abonado), emitida.CodigoControl)
+	if err != nil {
+		f.Estado = "no se pudo consultar: " + emision.DescribirError(err)
+	} else {
+		f.Estado = string(estado.Estado)
+		if len(estado.Observaciones) > 0 {
+			f.Estado += " (" + strings.Join(estado.Observaciones, "; ") + ")"
+		}
+	}
+	return f, nil
+}
+
+// drawVerificarScreen muestra el QR de verificación de SIN de la factura de
+// un abonado, para comprobarla o mostrarla en ventanilla.
+func drawVerificarScreen(w *app.Window, config Config) error {
+	var ops op.Ops
+	th := material.NewTheme()
+
+	abonadoEditor := widget.Editor{SingleLine: true, Submit: true}
+	var buscarButton widget.Clickable
+
+	// el estado de la pantalla solo se toca en el bucle de eventos; la
+	// búsqueda manda su resultado por este canal
+	type resultado struct {
+		factura *facturaQR
+		err     error
+	}
+	resultados := make(chan resultado, 1)
+
+	var buscando bool
+	var mensaje string
+	var factura *facturaQR
+	var imagen paint.ImageOp
+
+	buscar := func() {
+		abonado := strings.TrimSpace(abonadoEditor.Text())
+		if abonado == "" || buscando {
+			return
+		}
+		buscando = true
+		mensaje = "Buscando " + abonado + "..."
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			f, err := buscarFacturaQR(ctx, config, abonado)
+			resultados <- resultado{factura: f, err: err}
+			w.Invalidate()
+		}()
+	}
+
+	for {
+		switch e := w.Event().(type) {
+		case app.FrameEvent:
+			gtx := app.NewContext(&ops, e)
+
+			select {
+			case r := <-resultados:
+				buscando = false
+				if r.err != nil {
+					factura = nil
+					mensaje = r.err.Error()
+				} else {
+					factura = r.factura
+					imagen = paint.NewImageOp(r.factura.Imagen)
+					mensaje = ""
+				}
+			default:
+			}
+
+			for {
+				ev, ok := abonadoEditor.Update(gtx)
+				if !ok {
+					break
+				}
+				if _, ok := ev.(widget.SubmitEvent); ok {
+					buscar()
+				}
+			}
+			if buscarButton.Clicked(gtx) {
+				buscar()
+			}
+
+			inset := layout.Inset{Top: unit.Dp(5), Bottom: unit.Dp(5), Left: unit.Dp(10), Right: unit.Dp(10)}
+			layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					return inset.Layout(gtx, func(gtx C) D {
+						return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+							layout.Flexed(1, material.Editor(th, &abonadoEditor, "Abonado").Layout),
+							layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+							layout.Rigid(material.Button(th, &buscarButton, "Verificar").Layout),
+						)
+					})
+				}),
+				layout.Rigid(func(gtx C) D {
+					if mensaje == "" {
+						return D{}
+					}
+					return inset.Layout(gtx, material.Body1(th, mensaje).Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					if factura == nil {
+						return D{}
+					}
+					texto := fmt.Sprintf("Abonado %s, factura %d\nEstado: %s\nCUF: %s\n%s",
+						factura.Abonado, factura.Numero, factura.Estado, factura.Cuf, factura.URL)
+					return inset.Layout(gtx, material.Body2(th, texto).Layout)
+				}),
+				layout.Flexed(1, func(gtx C) D {
+					if factura == nil {
+						return D{}
+					}
+					return layout.Center.Layout(gtx, widget.Image{Src: imagen, Fit: widget.Contain}.Layout)
+				}),
+			)
+			e.Frame(gtx.Ops)
+
+		case app.DestroyEvent:
+			return e.Err
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package verificacion
+
+import (
+	"app/api"
+	"app/db"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Descuadre es una factura cuyos importes en la base de datos no cumplen
+// las reglas de montos de SIN.
+type Descuadre struct {
+	Factura db.Factura
+	Errores []api.ErrorCampo
+}
+
+// ConciliarMontos arma la solicitud de cada factura de la emisión sin
+// validarla ni enviarla y devuelve las que no pasan ValidarAritmetica,
+// tengan o no otros rechazos; esos se informan al emitir. periodo es la
+// fecha de la emisión.
+func ConciliarMontos(fe *api.FacturacionElectronica, periodo time.Time, facturas []db.Factura) ([]Descuadre, error) {
+	var descuadres []Descuadre
+	for _, f := range facturas {
+		req, err := fe.ArmarFacturaServicios(
+			periodo,
+			f.ConM3, f.ImpTotal, f.ImpAlcanta, f.ImpRep, f.ImpFactura, f.ImpRecargo, f.ImpLey1886,
+			api.CargosServicios(f.Cargos),
+			f.Razon, f.Abonado, f.Nit, f.Zona, f.Calle, f.Email,
+			f.NumFactura,
+		)
+		if err != nil {
+			return descuadres, fmt.Errorf("abonado %s: %v", f.Abonado, err)
+		}
+		var aritErr *api.ErrorAritmetico
+		if errors.As(api.ValidarAritmetica(req), &aritErr) {
+			descuadres = append(descuadres, Descuadre{Factura: f, Errores: aritErr.Errores})
+		}
+	}
+	return descuadres, nil
+}
+
+// WriteDescuadresCSV escribe los importes de cada factura que no cuadra y
+// las reglas que no cumple, una factura por línea.
+func WriteDescuadresCSV(w io.Writer, descuadres []Descuadre) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"abonado", "num_factura", "imp_total", "imp_alcanta", "imp_rep", "imp_recargo", "imp_ley1886",
+		"imp_tarifa_dignidad", "imp_aseo", "imp_alumbrado", "imp_otras_tasas", "imp_ajuste_no_iva", "imp_otros_pagos",
+		"imp_factura", "errores",
+	})
+	for _, d := range descuadres {
+		f := d.Factura
+		errores := make([]string, len(d.Errores))
+		for i, e := range d.Errores {
+			errores[i] = e.String()
+		}
+		cw.Write([]string{
+			f.Abonado, strconv.Itoa(f.NumFactura),
+			f.ImpTotal.String(), f.ImpAlcanta.String(), f.ImpRep.String(), f.ImpRecargo.String(),
+			f.ImpLey1886.String(), f.Cargos.DescuentoTarifaDignidad.String(),
+			f.Cargos.TasaAseo.String(), f.Cargos.TasaAlumbrado.String(), f.Cargos.OtrasTasas.String(),
+			f.Cargos.AjusteNoSujetoIva.String(), f.Cargos.OtrosPagosNoSujetoIva.String(),
+			f.ImpFactura.String(),
+			strings.Join(errores, "; "),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
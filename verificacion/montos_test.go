@@ -0,0 +1,37 @@
+package verificacion
+
+import (
+	"app/api"
+	"app/db"
+	"testing"
+	"time"
+)
+
+func TestConciliarMontosConOtrosRechazos(t *testing.T) {
+	emisor := api.DefaultEmisorProfile()
+	// una actividad fuera del catálogo hace fallar la validación de
+	// catálogos antes que la de montos
+	emisor.CodigoActividad = 999999
+	emisor.Leyenda = "Ley N° 453: Tienes derecho a recibir información sobre las características y contenidos de los servicios que utilices."
+	fe := api.NewFacturacionElectronica(api.ApiConfig{}, emisor)
+
+	cuadra := db.Factura{Abonado: "1001", NumFactura: 1, ConM3: 10, ImpTotal: 5000, ImpAlcanta: 1000, ImpFactura: 6000, Nit: "0", Razon: "PEREZ"}
+	noCuadra := cuadra
+	noCuadra.Abonado, noCuadra.NumFactura, noCuadra.ImpFactura = "1002", 2, 6100
+
+	periodo := time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local)
+	_, err := fe.BuildFacturaServicios(periodo, noCuadra.ConM3, noCuadra.ImpTotal, noCuadra.ImpAlcanta, noCuadra.ImpRep,
+		noCuadra.ImpFactura, noCuadra.ImpRecargo, noCuadra.ImpLey1886, api.CargosServicios(noCuadra.Cargos),
+		noCuadra.Razon, noCuadra.Abonado, noCuadra.Nit, noCuadra.Zona, noCuadra.Calle, noCuadra.Email, noCuadra.NumFactura)
+	if !api.IsCatalogo(err) {
+		t.Fatalf("err = %v, se esperaba el rechazo de catálogos", err)
+	}
+
+	descuadres, err := ConciliarMontos(fe, periodo, []db.Factura{cuadra, noCuadra})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descuadres) != 1 || descuadres[0].Factura.Abonado != "1002" {
+		t.Fatalf("descuadres = %+v, se esperaba solo el abonado 1002", descuadres)
+	}
+}
@@ -0,0 +1,172 @@
+package verificacion
+
+import (
+	"app/api"
+	"app/db"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const maxConsultas = 10
+
+// Diferencia describe una factura cuyo estado en la base de datos no
+// coincide con el del backend.
+type Diferencia struct {
+	Abonado       string
+	NumFactura    int
+	Cuf           string
+	Estado        api.EstadoFactura
+	Detalle       string
+	Observaciones []string
+}
+
+// Resumen es el resultado de verificar una emisión. Errores tiene las
+// facturas que no se pudieron consultar, con el error en Detalle.
+type Resumen struct {
+	Emision     string
+	Total       int
+	PorEstado   map[api.EstadoFactura]int
+	Errores     []Diferencia
+	Diferencias []Diferencia
+}
+
+func (r *Resumen) String() string {
+	return fmt.Sprintf("Verificadas %d: validadas = %d, observadas = %d, pendientes = %d, rechazadas = %d, anuladas = %d, diferencias = %d, errores = %d",
+		r.Total,
+		r.PorEstado[api.EstadoValidada], r.PorEstado[api.EstadoObservada],
+		r.PorEstado[api.EstadoPendiente], r.PorEstado[api.EstadoRechazada],
+		r.PorEstado[api.EstadoAnulada], len(r.Diferencias), len(r.Errores))
+}
+
+// WriteCSV escribe las diferencias encontradas y luego las facturas que no
+// se pudieron consultar, una por línea.
+func (r *Resumen) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"abonado", "num_factura", "cuf", "estado", "detalle", "observaciones"})
+	for _, lista := range [][]Diferencia{r.Diferencias, r.Errores} {
+		for _, d := range lista {
+			cw.Write([]string{
+				d.Abonado, strconv.Itoa(d.NumFactura), d.Cuf, string(d.Estado),
+				d.Detalle, strings.Join(d.Observaciones, "; "),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Verificar consulta el estado de cada CUF registrado en la base de datos y
+// compara la respuesta del backend con los datos locales. progress se
+// llama después de cada consulta con la cantidad procesada.
+func Verificar(ctx context.Context, fe api.InvoiceClient, emision string, facturas []db.FacturaEmitida, progress func(done int)) (*Resumen, error) {
+	resumen := &Resumen{
+		Emision:   emision,
+		Total:     len(facturas),
+		PorEstado: map[api.EstadoFactura]int{},
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConsultas)
+	done := 0
+
+	for _, factura := range facturas {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(factura db.FacturaEmitida) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			estado, err := fe.ConsultarEstado(api.ConAbonado(ctx, factura.Abonado), factura.CodigoControl)
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if progress != nil {
+				progress(done)
+			}
+			if err != nil {
+				var apiErr *api.APIError
+				if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+					resumen.Diferencias = append(resumen.Diferencias, Diferencia{
+						Abonado:    factura.Abonado,
+						NumFactura: factura.NumFactura,
+						Cuf:        factura.CodigoControl,
+						Detalle:    "el CUF no existe en el backend",
+					})
+					return
+				}
+				resumen.Errores = append(resumen.Errores, Diferencia{
+					Abonado:    factura.Abonado,
+					NumFactura: factura.NumFactura,
+					Cuf:        factura.CodigoControl,
+					Detalle:    fmt.Sprintf("error al consultar: %v", err),
+				})
+				return
+			}
+
+			resumen.PorEstado[estado.Estado]++
+			if diferencia := comparar(factura, estado); diferencia != nil {
+				resumen.Diferencias = append(resumen.Diferencias, *diferencia)
+			}
+		}(factura)
+	}
+
+	wg.Wait()
+
+	sort.Slice(resumen.Diferencias, func(i, j int) bool {
+		return resumen.Diferencias[i].NumFactura < resumen.Diferencias[j].NumFactura
+	})
+	sort.Slice(resumen.Errores, func(i, j int) bool {
+		return resumen.Errores[i].NumFactura < resumen.Errores[j].NumFactura
+	})
+	return resumen, ctx.Err()
+}
+
+func comparar(factura db.FacturaEmitida, estado *api.EstadoFacturaResponse) *Diferencia {
+	var detalles []string
+	if estado.NumeroFactura != 0 && estado.NumeroFactura != factura.NumFactura {
+		detalles = append(detalles, fmt.Sprintf("número de factura %d en el backend", estado.NumeroFactura))
+	}
+	if estado.CodigoCliente != "" && estado.CodigoCliente != factura.Abonado {
+		detalles = append(detalles, fmt.Sprintf("código de cliente %s en el backend", estado.CodigoCliente))
+	}
+	switch estado.Estado {
+	case api.EstadoValidada, api.EstadoPendiente:
+	case api.EstadoObservada:
+		detalles = append(detalles, "factura observada")
+	case api.EstadoRechazada:
+		detalles = append(detalles, "factura rechazada pero registrada con CUF")
+	case api.EstadoAnulada:
+		detalles = append(detalles, "factura anulada pero registrada con CUF")
+	default:
+		detalles = append(detalles, fmt.Sprintf("estado desconocido %q", estado.Estado))
+	}
+	if len(detalles) == 0 {
+		return nil
+	}
+	return &Diferencia{
+		Abonado:       factura.Abonado,
+		NumFactura:    factura.NumFactura,
+		Cuf:           factura.CodigoControl,
+		Estado:        estado.Estado,
+		Detalle:       strings.Join(detalles, "; "),
+		Observaciones: estado.Observaciones,
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"app/api"
+	"app/db"
+	"app/verificacion"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runVerificar checks every emitted CUF against the backend:
+//
+//	facturacion.exe verificar [-emision 2024-07-01] [-csv reporte.csv]
+func runVerificar(ctx context.Context, fe api.InvoiceClient, args []string) error {
+	fs := flag.NewFlagSet("verificar", flag.ExitOnError)
+	emision := fs.String("emision", "", "Fecha de emisión (por defecto la emisión actual de Factores)")
+	csvPath := fs.String("csv", "", "Archivo donde guardar las diferencias encontradas")
+	fs.Parse(args)
+
+	if *emision == "" {
+		var err error
+		*emision, err = db.GetEmisionActual()
+		if err != nil {
+			return err
+		}
+	}
+
+	emitidas, err := db.GetFacturasEmitidas(*emision)
+	if err != nil {
+		return err
+	}
+
+	resumen, err := verificacion.Verificar(ctx, fe, *emision, emitidas, func(done int) {
+		fmt.Printf("\rVerificando factura %d/%d", done, len(emitidas))
+	})
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(resumen)
+	for _, d := range resumen.Diferencias {
+		fmt.Printf("  abonado %s  factura %d  %s  %s\n", d.Abonado, d.NumFactura, d.Estado, d.Detalle)
+	}
+	for _, d := range resumen.Errores {
+		fmt.Printf("  abonado %s  factura %d  cuf %s  %s\n", d.Abonado, d.NumFactura, d.Cuf, d.Detalle)
+	}
+
+	if *csvPath != "" {
+		f, err := os.Create(*csvPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return resumen.WriteCSV(f)
+	}
+	return nil
+}